@@ -0,0 +1,94 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SecretKeyReference selects a key of a Secret.
+type SecretKeyReference struct {
+	// The name of the Secret
+	//+kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// The namespace of the Secret
+	//+kubebuilder:validation:MinLength=1
+	Namespace string `json:"namespace"`
+
+	// The key holding the value
+	//+optional
+	//+kubebuilder:default=token
+	Key string `json:"key,omitempty"`
+}
+
+// DesecAccountSpec defines the desired state of DesecAccount
+type DesecAccountSpec struct {
+	// The Secret holding the token of the account
+	TokenSecretRef SecretKeyReference `json:"tokenSecretRef"`
+
+	// The host of the management API, defaults to https://desec.io
+	//+optional
+	//+kubebuilder:validation:Pattern=`^https://`
+	MgmtHost string `json:"mgmtHost,omitempty"`
+
+	// The host of the dynDNS API, defaults to https://update.dedyn.io
+	//+optional
+	//+kubebuilder:validation:Pattern=`^https://`
+	UpdateIpHost string `json:"updateIpHost,omitempty"`
+
+	// The namespaces whose DesecRecords, DesecTokens and sources, like
+	// Ingresses, may use the account, along with the ones in
+	// tokenManagerNamespaces and the namespace of the operator
+	//+optional
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// The namespaces whose DesecTokens may create privileged tokens, i.e. ones
+	// permitted to manage tokens, create or delete domains, or write RRSets of
+	// any domain, none if empty
+	//+optional
+	TokenManagerNamespaces []string `json:"tokenManagerNamespaces,omitempty"`
+}
+
+// DesecAccountStatus defines the observed state of DesecAccount
+type DesecAccountStatus struct {
+	// Conditions
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+
+// DesecAccount is the Schema for the desecaccounts API
+type DesecAccount struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DesecAccountSpec   `json:"spec,omitempty"`
+	Status DesecAccountStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// DesecAccountList contains a list of DesecAccount
+type DesecAccountList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DesecAccount `json:"items"`
+}
@@ -23,13 +23,46 @@ import (
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
+// DeletionPolicy defines what happens on deSEC once a DesecDns is deleted.
+// +kubebuilder:validation:Enum=Retain;Delete
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyRetain leaves the domain and its RRSets alone
+	DeletionPolicyRetain DeletionPolicy = "Retain"
+	// DeletionPolicyDelete removes the domain if the operator created it, and
+	// the RRSets managed by the operator otherwise, once the domain is not
+	// declared anymore
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+)
+
 // DesecDnsSpec defines the desired state of DesecDns
 type DesecDnsSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
-	// The IPs associated with this domain
+	// The IPs associated with this domain. IPv4 addresses are published as A,
+	// IPv6 addresses as AAAA records.
 	IPs []string `json:"ips"`
+
+	// The name of the DesecAccount to use, the mounted credentials are used
+	// if empty
+	//+optional
+	Account string `json:"account,omitempty"`
+
+	// The adoption policy of the domain, the IPs are not updated but only
+	// compared if Observe
+	//+optional
+	AdoptionPolicy AdoptionPolicy `json:"adoptionPolicy,omitempty"`
+
+	// What happens on deSEC once the DesecDns is deleted. Delete only takes
+	// effect once the domain is not declared by a DesecDomain or the
+	// configuration anymore, so never for the domain of the configuration.
+	// Until then, the DesecDns is released as if Retain, as it would be
+	// recreated right away.
+	//+optional
+	//+kubebuilder:default=Retain
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
 }
 
 // DesecDnsStatus defines the observed state of DesecDns
@@ -39,6 +72,28 @@ type DesecDnsStatus struct {
 
 	// Conditions
 	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// Subnames of the SRV records published for the ports of services
+	SRVSubnames []string `json:"srvSubnames,omitempty"`
+
+	// RRSets which existed before, and were taken over, as subname/type,
+	// e.g. www/CNAME or @/A for the domain itself
+	Adopted []string `json:"adopted,omitempty"`
+
+	// Changes deSEC rejected, e.g. set www/CNAME to [some-domain.dedyn.io.]
+	// with TTL 3600, which are not retried until the sources ask for
+	// something else
+	//+optional
+	Rejected []string `json:"rejected,omitempty"`
+
+	// Whether the operator created the domain on deSEC
+	//+optional
+	CreatedDomain bool `json:"createdDomain,omitempty"`
+
+	// Changes to deSEC, which would be applied if not in dry-run, of the
+	// domain as well as its IPs
+	//+optional
+	PlannedChanges []string `json:"plannedChanges,omitempty"`
 }
 
 //+kubebuilder:object:root=true
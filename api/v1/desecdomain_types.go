@@ -0,0 +1,119 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CreationPolicy defines whether the operator creates a domain missing on
+// deSEC.
+// +kubebuilder:validation:Enum=IfNotPresent;Never
+type CreationPolicy string
+
+const (
+	// CreationPolicyIfNotPresent creates the domain unless it exists
+	CreationPolicyIfNotPresent CreationPolicy = "IfNotPresent"
+	// CreationPolicyNever only uses an existing domain
+	CreationPolicyNever CreationPolicy = "Never"
+)
+
+// AdoptionPolicy defines how the operator treats domains and RRSets, which
+// exist on deSEC before it manages them.
+// +kubebuilder:validation:Enum=Create;Adopt;Observe
+type AdoptionPolicy string
+
+const (
+	// AdoptionPolicyCreate refuses to manage anything, which already exists
+	AdoptionPolicyCreate AdoptionPolicy = "Create"
+	// AdoptionPolicyAdopt takes over what already exists
+	AdoptionPolicyAdopt AdoptionPolicy = "Adopt"
+	// AdoptionPolicyObserve never changes anything, but reports drift from
+	// the desired state
+	AdoptionPolicyObserve AdoptionPolicy = "Observe"
+)
+
+// DesecDomainSpec defines the desired state of DesecDomain
+type DesecDomainSpec struct {
+	// Whether the domain is created if it does not exist on deSEC yet
+	//+optional
+	//+kubebuilder:default=IfNotPresent
+	CreationPolicy CreationPolicy `json:"creationPolicy,omitempty"`
+
+	// How a domain, and RRSets in it, existing before the DesecDomain are
+	// treated. If empty, the domain is adopted, and so are RRSets unless the
+	// registry is enabled.
+	//+optional
+	AdoptionPolicy AdoptionPolicy `json:"adoptionPolicy,omitempty"`
+
+	// The TTL in seconds of records created for ingresses in this domain
+	//+optional
+	//+kubebuilder:default=3600
+	//+kubebuilder:validation:Minimum=1
+	TTL int64 `json:"ttl,omitempty"`
+
+	// The name of the DesecAccount owning the domain, the mounted credentials
+	// are used if empty
+	//+optional
+	Account string `json:"account,omitempty"`
+}
+
+// DesecDomainStatus defines the observed state of DesecDomain
+type DesecDomainStatus struct {
+	// Conditions
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// The minimum TTL deSEC allows for records in this domain
+	//+optional
+	MinimumTTL int64 `json:"minimumTTL,omitempty"`
+
+	// When deSEC created the domain
+	//+optional
+	Created string `json:"created,omitempty"`
+
+	// Whether the operator created the domain on deSEC
+	//+optional
+	CreatedDomain bool `json:"createdDomain,omitempty"`
+
+	// Changes to deSEC, which would be applied if not in dry-run
+	//+optional
+	PlannedChanges []string `json:"plannedChanges,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Policy",type=string,JSONPath=`.spec.creationPolicy`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+
+// DesecDomain is the Schema for the desecdomains API. Its name is the domain
+// on deSEC, e.g. some-domain.dedyn.io.
+type DesecDomain struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DesecDomainSpec   `json:"spec,omitempty"`
+	Status DesecDomainStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// DesecDomainList contains a list of DesecDomain
+type DesecDomainList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DesecDomain `json:"items"`
+}
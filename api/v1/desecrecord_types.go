@@ -0,0 +1,121 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DesecRecordSpec defines the desired state of DesecRecord
+type DesecRecordSpec struct {
+	// The deSEC domain the RRSet belongs to, e.g. some-domain.dedyn.io
+	//+kubebuilder:validation:MinLength=1
+	//+kubebuilder:validation:XValidation:rule="self == oldSelf",message="domain is immutable"
+	Domain string `json:"domain"`
+
+	// The subname of the RRSet, empty for the domain itself
+	//+optional
+	//+kubebuilder:validation:XValidation:rule="self == oldSelf",message="subname is immutable"
+	Subname string `json:"subname,omitempty"`
+
+	// The type of the RRSet, e.g. MX or TXT
+	//+kubebuilder:validation:Pattern=`^[A-Z0-9]+$`
+	//+kubebuilder:validation:XValidation:rule="self == oldSelf",message="type is immutable"
+	Type string `json:"type"`
+
+	// The TTL of the RRSet in seconds
+	//+optional
+	//+kubebuilder:default=3600
+	//+kubebuilder:validation:Minimum=1
+	TTL int64 `json:"ttl,omitempty"`
+
+	// The records of the RRSet, in the presentation format deSEC expects,
+	// e.g. "10 mail.some-domain.dedyn.io." for MX or "\"some text\"" for TXT
+	//+kubebuilder:validation:MinItems=1
+	Records []string `json:"records"`
+
+	// How an RRSet existing before the DesecRecord is treated
+	//+optional
+	//+kubebuilder:default=Adopt
+	AdoptionPolicy AdoptionPolicy `json:"adoptionPolicy,omitempty"`
+
+	// The name of the DesecAccount owning the domain, the mounted credentials
+	// are used if empty
+	//+optional
+	Account string `json:"account,omitempty"`
+}
+
+// DesecRecordStatus defines the observed state of DesecRecord
+type DesecRecordStatus struct {
+	// Conditions
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// The generation last applied to deSEC
+	//+optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// The records as deSEC stores them after applying the spec. deSEC may
+	// normalize them, so these are compared to detect changes done outside
+	// the cluster.
+	//+optional
+	Records []string `json:"records,omitempty"`
+
+	// When deSEC created the RRSet
+	//+optional
+	Created string `json:"created,omitempty"`
+
+	// When deSEC last touched the RRSet
+	//+optional
+	Touched string `json:"touched,omitempty"`
+
+	// Whether the operator created the RRSet on deSEC
+	//+optional
+	CreatedRRSet bool `json:"createdRRSet,omitempty"`
+
+	// Whether the RRSet existed before, and was taken over
+	//+optional
+	Adopted bool `json:"adopted,omitempty"`
+
+	// Changes to deSEC, which would be applied if not in dry-run
+	//+optional
+	PlannedChanges []string `json:"plannedChanges,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Domain",type=string,JSONPath=`.spec.domain`
+//+kubebuilder:printcolumn:name="Subname",type=string,JSONPath=`.spec.subname`
+//+kubebuilder:printcolumn:name="Type",type=string,JSONPath=`.spec.type`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+
+// DesecRecord is the Schema for the desecrecords API
+type DesecRecord struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DesecRecordSpec   `json:"spec,omitempty"`
+	Status DesecRecordStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// DesecRecordList contains a list of DesecRecord
+type DesecRecordList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DesecRecord `json:"items"`
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DesecTokenPolicy restricts which RRSets the token may write. Omitted fields
+// match any value.
+type DesecTokenPolicy struct {
+	// The domain of the RRSets
+	//+optional
+	Domain *string `json:"domain,omitempty"`
+
+	// The subname of the RRSets, empty for the domain itself
+	//+optional
+	Subname *string `json:"subname,omitempty"`
+
+	// The type of the RRSets
+	//+optional
+	Type *string `json:"type,omitempty"`
+
+	// Whether the token may write the RRSets
+	//+optional
+	PermWrite bool `json:"permWrite,omitempty"`
+}
+
+// DesecTokenSpec defines the desired state of DesecToken
+type DesecTokenSpec struct {
+	// The name of the Secret in the same namespace the token is stored in, in
+	// the key token
+	//+kubebuilder:validation:MinLength=1
+	SecretName string `json:"secretName"`
+
+	// The name of the DesecAccount the token is created for, the mounted
+	// credentials are used if empty, which is only allowed in the namespace of
+	// the operator. Its token needs permission to manage tokens.
+	//+optional
+	Account string `json:"account,omitempty"`
+
+	// Whether the token may create domains
+	//+optional
+	PermCreateDomain bool `json:"permCreateDomain,omitempty"`
+
+	// Whether the token may delete domains
+	//+optional
+	PermDeleteDomain bool `json:"permDeleteDomain,omitempty"`
+
+	// Whether the token may manage tokens, only allowed for namespaces listed
+	// in tokenManagerNamespaces of the DesecAccount
+	//+optional
+	PermManageTokens bool `json:"permManageTokens,omitempty"`
+
+	// The subnets the token may be used from, any if empty
+	//+optional
+	AllowedSubnets []string `json:"allowedSubnets,omitempty"`
+
+	// The time after which deSEC invalidates the token, unlimited if empty
+	//+optional
+	MaxAge *metav1.Duration `json:"maxAge,omitempty"`
+
+	// The time without use after which deSEC invalidates the token, unlimited
+	// if empty
+	//+optional
+	MaxUnusedPeriod *metav1.Duration `json:"maxUnusedPeriod,omitempty"`
+
+	// How long before maxAge the token is replaced by a new one, at most half
+	// of maxAge
+	//+optional
+	//+kubebuilder:default="24h"
+	RotateBefore *metav1.Duration `json:"rotateBefore,omitempty"`
+
+	// The RRSet policies of the token, the token may write no RRSet if empty
+	//+optional
+	Policies []DesecTokenPolicy `json:"policies,omitempty"`
+}
+
+// DesecTokenStatus defines the observed state of DesecToken
+type DesecTokenStatus struct {
+	// Conditions
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// The generation the current token was created for
+	//+optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// The ID of the current token on deSEC
+	//+optional
+	TokenID string `json:"tokenID,omitempty"`
+
+	// When the current token was created
+	//+optional
+	Created *metav1.Time `json:"created,omitempty"`
+
+	// When the current token is replaced by a new one
+	//+optional
+	RotateAt *metav1.Time `json:"rotateAt,omitempty"`
+
+	// Changes to deSEC, which would be applied if not in dry-run
+	//+optional
+	PlannedChanges []string `json:"plannedChanges,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Secret",type=string,JSONPath=`.spec.secretName`
+//+kubebuilder:printcolumn:name="Rotate At",type=string,JSONPath=`.status.rotateAt`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+
+// DesecToken is the Schema for the desectokens API
+type DesecToken struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DesecTokenSpec   `json:"spec,omitempty"`
+	Status DesecTokenStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// DesecTokenList contains a list of DesecToken
+type DesecTokenList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DesecToken `json:"items"`
+}
@@ -41,6 +41,14 @@ func addKnownTypes(s *runtime.Scheme) error {
 	s.AddKnownTypes(GroupVersion,
 		&DesecDns{},
 		&DesecDnsList{},
+		&DesecRecord{},
+		&DesecRecordList{},
+		&DesecDomain{},
+		&DesecDomainList{},
+		&DesecAccount{},
+		&DesecAccountList{},
+		&DesecToken{},
+		&DesecTokenList{},
 	)
 	metav1.AddToGroupVersion(s, GroupVersion)
 	return nil
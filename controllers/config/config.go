@@ -1,32 +1,84 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"k8s.io/apimachinery/pkg/types"
 )
 
+// HostnameMode defines how load balancers publishing a hostname instead of
+// an IP are handled.
+type HostnameMode string
+
+const (
+	// HostnameModeCNAME points the CNAMEs at the hostname of the load balancer
+	HostnameModeCNAME HostnameMode = "CNAME"
+	// HostnameModeFlatten resolves the hostname of the load balancer and
+	// publishes its IPs like the IPs of any other load balancer
+	HostnameModeFlatten HostnameMode = "Flatten"
+)
+
 type Config struct {
+	// Domain managed without a DesecDomain, optional
 	Domain    string
 	Namespace string
+
+	HostnameMode HostnameMode
+	// Address of the DNS server used to resolve hostnames, the system's
+	// resolver is used if empty
+	Resolver string
+	// Interval in which resolved hostnames are resolved again
+	ResolveInterval time.Duration
+	// AdoptionPolicy of Domain, see DesecDomain
+	AdoptionPolicy string
 }
 
 func NewConfigFor(configDir string) (Config, error) {
-	domain, err := os.ReadFile(configDir + "/config/domain")
+	namespace, err := os.ReadFile(configDir + "/config/namespace")
 	if err != nil {
 		return Config{}, err
 	}
-	namespace, err := os.ReadFile(configDir + "/config/namespace")
+
+	hostnameMode := HostnameMode(readOptional(configDir+"/config/hostnameMode", string(HostnameModeCNAME)))
+	if hostnameMode != HostnameModeCNAME && hostnameMode != HostnameModeFlatten {
+		return Config{}, fmt.Errorf("unknown hostnameMode %q", hostnameMode)
+	}
+	resolveInterval, err := time.ParseDuration(readOptional(configDir+"/config/resolveInterval", "5m"))
 	if err != nil {
 		return Config{}, err
 	}
+	adoptionPolicy := readOptional(configDir+"/config/adoptionPolicy", "")
+	if adoptionPolicy != "" && adoptionPolicy != "Create" && adoptionPolicy != "Adopt" && adoptionPolicy != "Observe" {
+		return Config{}, fmt.Errorf("unknown adoptionPolicy %q", adoptionPolicy)
+	}
 
 	return Config{
-		Domain:    string(domain),
+		Domain:    readOptional(configDir+"/config/domain", ""),
 		Namespace: string(namespace),
+
+		HostnameMode:    hostnameMode,
+		Resolver:        readOptional(configDir+"/config/resolver", ""),
+		ResolveInterval: resolveInterval,
+		AdoptionPolicy:  adoptionPolicy,
 	}, nil
 }
 
+func readOptional(path string, fallback string) string {
+	value, err := os.ReadFile(path)
+	if err != nil {
+		return fallback
+	}
+	return strings.TrimSpace(string(value))
+}
+
 func (d Config) GetNamespacedName() types.NamespacedName {
-	return types.NamespacedName{Name: d.Domain, Namespace: d.Namespace}
+	return d.NamespacedNameFor(d.Domain)
+}
+
+// NamespacedNameFor returns the name of the CRs of the domain.
+func (d Config) NamespacedNameFor(domain string) types.NamespacedName {
+	return types.NamespacedName{Name: domain, Namespace: d.Namespace}
 }
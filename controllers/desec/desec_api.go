@@ -25,3 +25,51 @@ type RRSet struct {
 type createDomainPayload struct {
 	Name string `json:"name"`
 }
+
+// bulkRRSet is an item of a bulk request, an empty list of records deletes
+// the RRSet.
+type bulkRRSet struct {
+	Subname string   `json:"subname"`
+	Type    string   `json:"type"`
+	Records []string `json:"records"`
+	TTL     int64    `json:"ttl,omitempty"`
+}
+
+// Token is an API token of an account. Durations are formatted like
+// "[DD] [HH:[MM:]]ss", nil means unlimited.
+type Token struct {
+	ID               string   `json:"id"`
+	Created          string   `json:"created"`
+	LastUsed         string   `json:"last_used"`
+	Name             string   `json:"name"`
+	PermCreateDomain bool     `json:"perm_create_domain"`
+	PermDeleteDomain bool     `json:"perm_delete_domain"`
+	PermManageTokens bool     `json:"perm_manage_tokens"`
+	AllowedSubnets   []string `json:"allowed_subnets"`
+	MaxAge           *string  `json:"max_age"`
+	MaxUnusedPeriod  *string  `json:"max_unused_period"`
+	IsValid          bool     `json:"is_valid"`
+	// Token is the secret value, which deSEC only returns on creation
+	Token string `json:"token"`
+}
+
+type createTokenPayload struct {
+	Name             string   `json:"name"`
+	PermCreateDomain bool     `json:"perm_create_domain"`
+	PermDeleteDomain bool     `json:"perm_delete_domain"`
+	PermManageTokens bool     `json:"perm_manage_tokens"`
+	AllowedSubnets   []string `json:"allowed_subnets,omitempty"`
+	MaxAge           *string  `json:"max_age"`
+	MaxUnusedPeriod  *string  `json:"max_unused_period"`
+}
+
+// TokenPolicy restricts which RRSets a token may write. A nil domain, subname
+// or type matches any. deSEC requires a default policy, matching anything,
+// before any other policy is created.
+type TokenPolicy struct {
+	ID        string  `json:"id,omitempty"`
+	Domain    *string `json:"domain"`
+	Subname   *string `json:"subname"`
+	Type      *string `json:"type"`
+	PermWrite bool    `json:"perm_write"`
+}
@@ -2,8 +2,10 @@ package desec
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"os"
@@ -16,141 +18,400 @@ type Client struct {
 
 	mgmtHost     string
 	updateIpHost string
+
+	httpClient *http.Client
+	userAgent  string
 }
 
 func (c Client) getMgmtBaseUrl() string {
 	return c.mgmtHost + "/api/v1/domains/"
 }
 
+func (c Client) getTokensBaseUrl() string {
+	return c.mgmtHost + "/api/v1/auth/tokens/"
+}
+
 func (c Client) getUpdateIpBaseUrl() string {
 	return c.updateIpHost
 }
 
-func NewClient(domain string, configDir string) (Client, error) {
+// Account holds the credentials and endpoints of a deSEC account. Empty
+// hosts default to the ones of desec.io.
+type Account struct {
+	Token        string
+	MgmtHost     string
+	UpdateIpHost string
+}
+
+// NewClient creates a client using the account mounted to configDir.
+func NewClient(domain string, configDir string, opts ...Option) (Client, error) {
 	token, err := os.ReadFile(configDir + "/secret/token")
 	if err != nil {
 		return Client{}, err
 	}
-	mgmtHost, err := os.ReadFile(configDir + "/config/mgmtHost")
-	if err != nil {
-		mgmtHost = []byte("https://desec.io")
+	// Missing hosts fall back to the defaults
+	mgmtHost, _ := os.ReadFile(configDir + "/config/mgmtHost")
+	updateIpHost, _ := os.ReadFile(configDir + "/config/updateIpHost")
+
+	return NewClientFor(domain, Account{
+		Token:        string(token),
+		MgmtHost:     string(mgmtHost),
+		UpdateIpHost: string(updateIpHost),
+	}, opts...), nil
+}
+
+// NewClientFor creates a client using the given account.
+func NewClientFor(domain string, account Account, opts ...Option) Client {
+	if account.MgmtHost == "" {
+		account.MgmtHost = "https://desec.io"
 	}
-	updateIpHost, err := os.ReadFile(configDir + "/config/updateIpHost")
-	if err != nil {
-		updateIpHost = []byte("https://update.dedyn.io")
+	if account.UpdateIpHost == "" {
+		account.UpdateIpHost = "https://update.dedyn.io"
 	}
 
-	return Client{
+	client := Client{
 		Domain: domain,
-		token:  string(token),
+		token:  account.Token,
+
+		mgmtHost:     account.MgmtHost,
+		updateIpHost: account.UpdateIpHost,
 
-		mgmtHost:     string(mgmtHost),
-		updateIpHost: string(updateIpHost),
-	}, nil
+		httpClient: &http.Client{Timeout: DefaultTimeout},
+		userAgent:  DefaultUserAgent,
+	}
+	for _, opt := range opts {
+		opt(&client)
+	}
+	return client
 }
 
-func get[T any](url string, token string, dest *T) error {
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+// throttle returns the throttle of the given class for the endpoint the
+// class applies to.
+func (c Client) throttle(class ThrottleClass) *throttle {
+	switch class {
+	case ThrottleDynDns:
+		return getThrottle(class, c.updateIpHost+"|"+c.Domain)
+	case ThrottleDnsApiWriteRRSets:
+		return getThrottle(class, c.mgmtHost+"|"+c.Domain)
+	default:
+		return getThrottle(class, c.mgmtHost)
+	}
+}
+
+// do sends the request, unless it is throttled. The caller has to close the
+// body of the returned response.
+func do(c Client, t *throttle, req *http.Request) (*http.Response, error) {
+	if err := t.reserve(); err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Authorization", "Token "+c.token)
+	req.Header.Set("User-Agent", c.userAgent)
+	res, err := c.httpClient.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	req.Header.Add("Authorization", "Token "+token)
-	res, err := http.DefaultClient.Do(req)
+	if res.StatusCode == http.StatusTooManyRequests {
+		defer res.Body.Close()
+		return nil, t.throttled(res)
+	}
+	return res, nil
+}
+
+func get[T any](ctx context.Context, c Client, t *throttle, url string, dest *T) (http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	res, err := do(c, t, req)
+	if err != nil {
+		return nil, err
 	}
+	defer res.Body.Close()
 
-	if res.StatusCode == 404 {
-		return nil
+	if res.StatusCode != 200 {
+		return nil, newAPIError(res)
 	}
 
 	err = json.NewDecoder(res.Body).Decode(dest)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return res.Header, nil
+}
+
+// getAll fetches all pages of a listing, following the cursors deSEC
+// provides via the Link header.
+func getAll[T any](ctx context.Context, c Client, t *throttle, listUrl string, query url.Values) ([]T, error) {
+	// Asking for a cursor opts into pagination, which deSEC requires for large listings
+	query.Set("cursor", "")
+	pageUrl := listUrl + "?" + query.Encode()
+
+	items := make([]T, 0)
+	for pageUrl != "" {
+		page := make([]T, 0)
+		header, err := get(ctx, c, t, pageUrl, &page)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, page...)
+		pageUrl = getNextPageUrl(header.Get("Link"))
+	}
+	return items, nil
+}
+
+// getNextPageUrl extracts the URL with rel="next" from a Link header like
+// <https://desec.io/api/v1/domains/example.com/rrsets/?cursor=abc>; rel="next"
+func getNextPageUrl(link string) string {
+	for _, entry := range strings.Split(link, ",") {
+		target, params, found := strings.Cut(entry, ";")
+		if !found {
+			continue
+		}
+		for _, param := range strings.Split(params, ";") {
+			if strings.TrimSpace(param) == `rel="next"` {
+				return strings.Trim(strings.TrimSpace(target), "<>")
+			}
+		}
+	}
+	return ""
 }
 
-func post[T any, R any](url string, token string, payload R, dest *T) error {
+// send sends the payload as JSON and decodes the response into dest, if the
+// expected status is returned.
+func send[T any, R any](ctx context.Context, c Client, t *throttle, method string, url string, payload R, expectedStatus int, dest *T) error {
 	payloadJson, err := json.Marshal(payload)
 	if err != nil {
 		return err
 	}
 
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payloadJson))
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(payloadJson))
 	if err != nil {
 		return err
 	}
 
-	req.Header.Add("Authorization", "Token "+token)
 	req.Header.Add("Content-Type", "application/json")
-	res, err := http.DefaultClient.Do(req)
+	res, err := do(c, t, req)
 	if err != nil {
 		return err
 	}
+	defer res.Body.Close()
 
-	if res.StatusCode != 201 {
-		return fmt.Errorf("got status %d while trying to POST %v", res.StatusCode, payload)
+	if res.StatusCode != expectedStatus {
+		return newAPIError(res)
 	}
 
 	return json.NewDecoder(res.Body).Decode(dest)
 }
 
-func (c Client) GetDomains() ([]Domain, error) {
-	domains := make([]Domain, 0)
-	err := get(c.getMgmtBaseUrl(), c.token, &domains)
-	return domains, err
+func post[T any, R any](ctx context.Context, c Client, t *throttle, url string, payload R, dest *T) error {
+	return send(ctx, c, t, http.MethodPost, url, payload, 201, dest)
 }
 
-func (c Client) GetRRSets() ([]RRSet, error) {
-	rrsets := make([]RRSet, 0)
-	err := get(c.getMgmtBaseUrl()+c.Domain+"/rrsets/", c.token, &rrsets)
-	return rrsets, err
+func patch[T any, R any](ctx context.Context, c Client, t *throttle, url string, payload R, dest *T) error {
+	return send(ctx, c, t, http.MethodPatch, url, payload, 200, dest)
 }
 
-func (c Client) CreateRRSet(rrset RRSet) (RRSet, error) {
+func remove(ctx context.Context, c Client, t *throttle, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, url, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := do(c, t, req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 204 {
+		return newAPIError(res)
+	}
+
+	return nil
+}
+
+func (c Client) GetDomains(ctx context.Context) ([]Domain, error) {
+	return getAll[Domain](ctx, c, c.throttle(ThrottleDnsApiRead), c.getMgmtBaseUrl(), url.Values{})
+}
+
+func (c Client) getRRSets(ctx context.Context, query url.Values) ([]RRSet, error) {
+	return getAll[RRSet](ctx, c, c.throttle(ThrottleDnsApiRead), c.getMgmtBaseUrl()+c.Domain+"/rrsets/", query)
+}
+
+func (c Client) GetRRSets(ctx context.Context) ([]RRSet, error) {
+	return c.getRRSets(ctx, url.Values{})
+}
+
+// GetRRSetsBySubname returns the RRSets of all types for a subname. Use an
+// empty subname for the zone apex.
+func (c Client) GetRRSetsBySubname(ctx context.Context, subname string) ([]RRSet, error) {
+	return c.getRRSets(ctx, url.Values{"subname": {subname}})
+}
+
+// GetRRSet returns the RRSet of a type for a subname, or nil if there is
+// none.
+func (c Client) GetRRSet(ctx context.Context, subname string, rrType string) (*RRSet, error) {
+	rrsets, err := c.getRRSets(ctx, url.Values{"subname": {subname}, "type": {rrType}})
+	if err != nil || len(rrsets) == 0 {
+		return nil, err
+	}
+	return &rrsets[0], nil
+}
+
+func (c Client) CreateRRSet(ctx context.Context, rrset RRSet) (RRSet, error) {
 	dest := RRSet{}
-	err := post(c.getMgmtBaseUrl()+c.Domain+"/rrsets/", c.token, rrset, &dest)
+	err := post(ctx, c, c.throttle(ThrottleDnsApiWriteRRSets), c.getMgmtBaseUrl()+c.Domain+"/rrsets/", rrset, &dest)
 	return dest, err
 }
 
-func (c Client) CreateCNAME(subname string) (RRSet, error) {
-	return c.CreateRRSet(RRSet{
+// CNAME returns a CNAME from the subname to the domain.
+func (c Client) CNAME(subname string) RRSet {
+	return RRSet{
 		Domain:  c.Domain,
 		Subname: subname,
 		Name:    subname + "." + c.Domain + ".",
 		Type:    "CNAME",
 		Records: []string{c.Domain + "."},
 		TTL:     3600,
-	})
+	}
 }
 
-func (c Client) CreateDomain() (Domain, error) {
+func (c Client) CreateCNAME(ctx context.Context, subname string) (RRSet, error) {
+	return c.CreateRRSet(ctx, c.CNAME(subname))
+}
+
+// BulkUpsertRRSets creates or updates all RRSets in one atomic request. If
+// deSEC rejects any of them, nothing is changed and an *APIError with the
+// validation errors of each RRSet in Items is returned.
+func (c Client) BulkUpsertRRSets(ctx context.Context, rrsets []RRSet) ([]RRSet, error) {
+	payload := make([]bulkRRSet, 0, len(rrsets))
+	for _, rrset := range rrsets {
+		payload = append(payload, bulkRRSet{Subname: rrset.Subname, Type: rrset.Type, Records: rrset.Records, TTL: rrset.TTL})
+	}
+	return c.bulkPatch(ctx, payload)
+}
+
+// UpsertRRSet creates the RRSet, or replaces TTL and records of the existing
+// one.
+func (c Client) UpsertRRSet(ctx context.Context, rrset RRSet) (RRSet, error) {
+	upserted, err := c.BulkUpsertRRSets(ctx, []RRSet{rrset})
+	if err != nil {
+		return RRSet{}, err
+	}
+	if len(upserted) == 0 {
+		return RRSet{}, fmt.Errorf("deSEC did not return the RRSet %s/%s", rrset.Subname, rrset.Type)
+	}
+	return upserted[0], nil
+}
+
+// BulkDeleteRRSets deletes all RRSets in one atomic request. Only subname and
+// type of the RRSets are taken into account.
+func (c Client) BulkDeleteRRSets(ctx context.Context, rrsets []RRSet) error {
+	payload := make([]bulkRRSet, 0, len(rrsets))
+	for _, rrset := range rrsets {
+		payload = append(payload, bulkRRSet{Subname: rrset.Subname, Type: rrset.Type, Records: []string{}})
+	}
+	_, err := c.bulkPatch(ctx, payload)
+	return err
+}
+
+func (c Client) bulkPatch(ctx context.Context, payload []bulkRRSet) ([]RRSet, error) {
+	dest := make([]RRSet, 0)
+	if len(payload) == 0 {
+		return dest, nil
+	}
+	err := patch(ctx, c, c.throttle(ThrottleDnsApiWriteRRSets), c.getMgmtBaseUrl()+c.Domain+"/rrsets/", payload, &dest)
+	return dest, err
+}
+
+func (c Client) DeleteRRSet(ctx context.Context, subname string, rrType string) error {
+	if subname == "" {
+		subname = "@"
+	}
+	return remove(ctx, c, c.throttle(ThrottleDnsApiWriteRRSets), c.getMgmtBaseUrl()+c.Domain+"/rrsets/"+subname+"/"+rrType+"/")
+}
+
+func (c Client) CreateDomain(ctx context.Context) (Domain, error) {
 	dest := Domain{}
-	err := post(c.getMgmtBaseUrl(), c.token, createDomainPayload{Name: c.Domain}, &dest)
+	err := post(ctx, c, c.throttle(ThrottleDnsApiWriteDomains), c.getMgmtBaseUrl(), createDomainPayload{Name: c.Domain}, &dest)
+	return dest, err
+}
+
+// DeleteDomain deletes the domain along with all of its RRSets.
+func (c Client) DeleteDomain(ctx context.Context) error {
+	return remove(ctx, c, c.throttle(ThrottleDnsApiWriteDomains), c.getMgmtBaseUrl()+c.Domain+"/")
+}
+
+// CreateToken creates a token of the account with the permissions of the
+// given one. The returned token holds the secret value.
+func (c Client) CreateToken(ctx context.Context, token Token) (Token, error) {
+	dest := Token{}
+	payload := createTokenPayload{
+		Name:             token.Name,
+		PermCreateDomain: token.PermCreateDomain,
+		PermDeleteDomain: token.PermDeleteDomain,
+		PermManageTokens: token.PermManageTokens,
+		AllowedSubnets:   token.AllowedSubnets,
+		MaxAge:           token.MaxAge,
+		MaxUnusedPeriod:  token.MaxUnusedPeriod,
+	}
+	err := post(ctx, c, c.throttle(ThrottleDnsApiWriteDomains), c.getTokensBaseUrl(), payload, &dest)
+	return dest, err
+}
+
+func (c Client) GetToken(ctx context.Context, id string) (Token, error) {
+	dest := Token{}
+	_, err := get(ctx, c, c.throttle(ThrottleDnsApiRead), c.getTokensBaseUrl()+id+"/", &dest)
+	return dest, err
+}
+
+func (c Client) DeleteToken(ctx context.Context, id string) error {
+	return remove(ctx, c, c.throttle(ThrottleDnsApiWriteDomains), c.getTokensBaseUrl()+id+"/")
+}
+
+func (c Client) CreateTokenPolicy(ctx context.Context, tokenId string, policy TokenPolicy) (TokenPolicy, error) {
+	dest := TokenPolicy{}
+	err := post(ctx, c, c.throttle(ThrottleDnsApiWriteDomains), c.getTokensBaseUrl()+tokenId+"/policies/rrsets/", policy, &dest)
 	return dest, err
 }
 
-func (c Client) UpdateIp(ips []string) error {
+// UpdateIp sets the A and AAAA records of the domain via dynDNS. An empty
+// list removes the records of the respective family.
+func (c Client) UpdateIp(ctx context.Context, ipv4s []string, ipv6s []string) error {
 	url := fmt.Sprintf(
-		"%s?hostname=%s&myip=%s",
+		"%s?hostname=%s&myip=%s&myipv6=%s",
 		c.getUpdateIpBaseUrl(),
 		url.QueryEscape(c.Domain),
-		url.QueryEscape(strings.Join(ips, ",")),
+		url.QueryEscape(strings.Join(ipv4s, ",")),
+		url.QueryEscape(strings.Join(ipv6s, ",")),
 	)
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return err
 	}
 
-	req.Header.Add("Authorization", "Token "+c.token)
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := do(c, c.throttle(ThrottleDynDns), req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
 
-	if err == nil && resp.StatusCode != 200 {
-		return fmt.Errorf("got status code %d", resp.StatusCode)
+	if resp.StatusCode != 200 {
+		return newAPIError(resp)
 	}
 
-	return err
+	// The body is "good", or "nochg" if nothing changed
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return err
+	}
+	if answer := strings.TrimSpace(string(body)); answer != "good" && answer != "nochg" {
+		return &APIError{StatusCode: resp.StatusCode, Method: req.Method, Path: requestPath(req), Detail: answer}
+	}
+
+	return nil
 }
@@ -1,11 +1,15 @@
 package desec
 
 import (
+	"context"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/j-be/desec-dns-operator/controllers/util"
 	"github.com/stretchr/testify/assert"
@@ -44,7 +48,7 @@ func TestGetDomains(t *testing.T) {
 		defer server.Close()
 		var client = createClient(t, server)
 		// When
-		domains, err := client.GetDomains()
+		domains, err := client.GetDomains(context.TODO())
 		// Then
 		assert.NoError(t, err)
 		assert.Len(t, domains, 3)
@@ -67,7 +71,7 @@ func TestGetRrset(t *testing.T) {
 		defer server.Close()
 		var client = createClient(t, server)
 		// When
-		rrsets, err := client.GetRRSets()
+		rrsets, err := client.GetRRSets(context.TODO())
 		// Then
 		assert.NoError(t, err)
 		assert.Len(t, rrsets, 3)
@@ -103,6 +107,89 @@ func TestGetRrset(t *testing.T) {
 	})
 }
 
+func TestGetRrsetPaginated(t *testing.T) {
+	t.Run("TestFollowsCursor", func(t *testing.T) {
+		// Given
+		pages := map[string]string{
+			"":         "[" + mockCname + "]",
+			"page-two": mockRrsets,
+		}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "GET", r.Method)
+			assert.Equal(t, "/api/v1/domains/some-domain.dedyn.io/rrsets/", r.URL.Path)
+			assert.True(t, r.URL.Query().Has("cursor"))
+			cursor := r.URL.Query().Get("cursor")
+			if cursor == "" {
+				w.Header().Add("Link", `<http://`+r.Host+r.URL.Path+`?cursor=>; rel="first", <http://`+r.Host+r.URL.Path+`?cursor=page-two>; rel="next"`)
+			} else {
+				w.Header().Add("Link", `<http://`+r.Host+r.URL.Path+`?cursor=>; rel="first"`)
+			}
+			_, err := w.Write([]byte(pages[cursor]))
+			assert.NoError(t, err)
+		}))
+		defer server.Close()
+		var client = createClient(t, server)
+		// When
+		rrsets, err := client.GetRRSets(context.TODO())
+		// Then
+		assert.NoError(t, err)
+		assert.Len(t, rrsets, 4)
+		assert.Equal(t, "CNAME", rrsets[0].Type)
+		assert.Equal(t, "NS", rrsets[3].Type)
+	})
+
+	t.Run("TestFilters", func(t *testing.T) {
+		// Given
+		var query url.Values
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/api/v1/domains/some-domain.dedyn.io/rrsets/", r.URL.Path)
+			query = r.URL.Query()
+			_, err := w.Write([]byte("[" + mockCname + "]"))
+			assert.NoError(t, err)
+		}))
+		defer server.Close()
+		var client = createClient(t, server)
+		// When
+		_, err := client.GetRRSetsBySubname(context.TODO(), "")
+		// Then
+		assert.NoError(t, err)
+		assert.True(t, query.Has("subname"))
+		assert.Empty(t, query.Get("subname"))
+		assert.False(t, query.Has("type"))
+
+		// When
+		cname, err := client.GetRRSet(context.TODO(), "www", "CNAME")
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, "www", query.Get("subname"))
+		assert.Equal(t, "CNAME", query.Get("type"))
+		assert.Equal(t, []string{"some-domain.dedyn.io."}, cname.Records)
+	})
+
+	t.Run("TestNoRRSet", func(t *testing.T) {
+		// Given
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, err := w.Write([]byte("[]"))
+			assert.NoError(t, err)
+		}))
+		defer server.Close()
+		var client = createClient(t, server)
+		// When
+		cname, err := client.GetRRSet(context.TODO(), "www", "CNAME")
+		// Then
+		assert.NoError(t, err)
+		assert.Nil(t, cname)
+	})
+}
+
+func TestGetNextPageUrl(t *testing.T) {
+	assert.Equal(t, "https://desec.io/api/v1/domains/x/rrsets/?cursor=abc", getNextPageUrl(
+		`<https://desec.io/api/v1/domains/x/rrsets/?cursor=>; rel="first", <https://desec.io/api/v1/domains/x/rrsets/?cursor=abc>; rel="next"`,
+	))
+	assert.Empty(t, getNextPageUrl(`<https://desec.io/api/v1/domains/x/rrsets/?cursor=>; rel="first"`))
+	assert.Empty(t, getNextPageUrl(""))
+}
+
 func TestCreateCNAME(t *testing.T) {
 	t.Run("TestBasic", func(t *testing.T) {
 		// Given
@@ -123,7 +210,7 @@ func TestCreateCNAME(t *testing.T) {
 		defer server.Close()
 		var client = createClient(t, server)
 		// When
-		cname, err := client.CreateCNAME("www")
+		cname, err := client.CreateCNAME(context.TODO(), "www")
 		// Then
 		assert.NoError(t, err)
 		assert.Equal(t, "some-domain.dedyn.io", cname.Domain)
@@ -135,6 +222,119 @@ func TestCreateCNAME(t *testing.T) {
 	})
 }
 
+func TestBulkUpsertRRSets(t *testing.T) {
+	t.Run("TestBasic", func(t *testing.T) {
+		// Given
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "PATCH", r.Method)
+			assert.Equal(t, "/api/v1/domains/some-domain.dedyn.io/rrsets/", r.URL.Path)
+			assert.Equal(t, "Token I'm a token", r.Header.Get("Authorization"))
+			body, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			assert.JSONEq(t,
+				`[{"subname":"www","type":"CNAME","records":["some-domain.dedyn.io."],"ttl":3600},{"subname":"git","type":"CNAME","records":["some-domain.dedyn.io."],"ttl":3600}]`,
+				string(body),
+			)
+			_, err = w.Write([]byte("[" + mockCname + "]"))
+			assert.NoError(t, err)
+		}))
+		defer server.Close()
+		var client = createClient(t, server)
+		// When
+		rrsets, err := client.BulkUpsertRRSets(context.TODO(), []RRSet{client.CNAME("www"), client.CNAME("git")})
+		// Then
+		assert.NoError(t, err)
+		assert.Len(t, rrsets, 1)
+		assert.Equal(t, "www.some-domain.dedyn.io.", rrsets[0].Name)
+	})
+
+	t.Run("TestValidationErrors", func(t *testing.T) {
+		// Given
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(400)
+			_, err := w.Write([]byte(`[{}, {"records": [["Invalid record."], []], "ttl": ["Too small."]}]`))
+			assert.NoError(t, err)
+		}))
+		defer server.Close()
+		var client = createClient(t, server)
+		// When
+		_, err := client.BulkUpsertRRSets(context.TODO(), []RRSet{client.CNAME("www"), client.CNAME("git")})
+		// Then
+		apiErr := new(APIError)
+		assert.True(t, errors.As(err, &apiErr))
+		assert.True(t, IsInvalid(err))
+		assert.Len(t, apiErr.Items, 2)
+		assert.Empty(t, apiErr.Items[0])
+		assert.Equal(t, "records: Invalid record.; ttl: Too small.", apiErr.Items[1].String())
+		assert.EqualError(t, err, "got status 400 while trying to PATCH /api/v1/domains/some-domain.dedyn.io/rrsets/: #1: records: Invalid record.; ttl: Too small.")
+	})
+
+	t.Run("TestNothingToDo", func(t *testing.T) {
+		// Given
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { assert.Fail(t, "Should not have been called") }))
+		defer server.Close()
+		var client = createClient(t, server)
+		// When
+		rrsets, err := client.BulkUpsertRRSets(context.TODO(), []RRSet{})
+		// Then
+		assert.NoError(t, err)
+		assert.Empty(t, rrsets)
+	})
+}
+
+func TestBulkDeleteRRSets(t *testing.T) {
+	t.Run("TestBasic", func(t *testing.T) {
+		// Given
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "PATCH", r.Method)
+			assert.Equal(t, "/api/v1/domains/some-domain.dedyn.io/rrsets/", r.URL.Path)
+			body, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			assert.JSONEq(t, `[{"subname":"www","type":"CNAME","records":[]},{"subname":"","type":"A","records":[]}]`, string(body))
+			_, err = w.Write([]byte("[]"))
+			assert.NoError(t, err)
+		}))
+		defer server.Close()
+		var client = createClient(t, server)
+		// When
+		err := client.BulkDeleteRRSets(context.TODO(), []RRSet{client.CNAME("www"), {Type: "A"}})
+		// Then
+		assert.NoError(t, err)
+	})
+}
+
+func TestDeleteRRSet(t *testing.T) {
+	t.Run("TestBasic", func(t *testing.T) {
+		// Given
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "DELETE", r.Method)
+			assert.Equal(t, "/api/v1/domains/some-domain.dedyn.io/rrsets/www/CNAME/", r.URL.Path)
+			assert.Equal(t, "Token I'm a token", r.Header.Get("Authorization"))
+			w.WriteHeader(204)
+		}))
+		defer server.Close()
+		var client = createClient(t, server)
+		// When
+		err := client.DeleteRRSet(context.TODO(), "www", "CNAME")
+		// Then
+		assert.NoError(t, err)
+	})
+
+	t.Run("TestApex", func(t *testing.T) {
+		// Given
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/api/v1/domains/some-domain.dedyn.io/rrsets/@/A/", r.URL.Path)
+			w.WriteHeader(204)
+		}))
+		defer server.Close()
+		var client = createClient(t, server)
+		// When
+		err := client.DeleteRRSet(context.TODO(), "", "A")
+		// Then
+		assert.NoError(t, err)
+	})
+}
+
 func TestCreateDomain(t *testing.T) {
 	t.Run("TestBasic", func(t *testing.T) {
 		// Given
@@ -149,7 +349,7 @@ func TestCreateDomain(t *testing.T) {
 		defer server.Close()
 		var client = createClient(t, server)
 		// When
-		domain, err := client.CreateDomain()
+		domain, err := client.CreateDomain(context.TODO())
 		// Then
 		assert.NoError(t, err)
 		assert.Equal(t, "some-domain.dedyn.io", domain.Name)
@@ -157,6 +357,97 @@ func TestCreateDomain(t *testing.T) {
 	})
 }
 
+func TestDeleteDomain(t *testing.T) {
+	t.Run("TestBasic", func(t *testing.T) {
+		// Given
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "DELETE", r.Method)
+			assert.Equal(t, "/api/v1/domains/some-domain.dedyn.io/", r.URL.Path)
+			assert.Equal(t, "Token I'm a token", r.Header.Get("Authorization"))
+			w.WriteHeader(204)
+		}))
+		defer server.Close()
+		var client = createClient(t, server)
+		// When
+		err := client.DeleteDomain(context.TODO())
+		// Then
+		assert.NoError(t, err)
+	})
+}
+
+const mockToken = `{"id":"3a6b94b5-d20e-40bd-a7cc-521f5c79fab3","created":"2023-06-03T08:21:34.591942Z","last_used":null,"owner":"admin@some-domain.dedyn.io","user_override":null,"max_age":"30 00:00:00","max_unused_period":null,"name":"acme","perm_create_domain":false,"perm_delete_domain":false,"perm_manage_tokens":false,"allowed_subnets":["0.0.0.0/0","::/0"],"auto_policy":false,"is_valid":true,"token":"4pnk7u-NHvrEkFzrhFDRTjGFyX_S"}`
+
+func TestCreateToken(t *testing.T) {
+	t.Run("TestBasic", func(t *testing.T) {
+		// Given
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "POST", r.Method)
+			assert.Equal(t, "/api/v1/auth/tokens/", r.URL.Path)
+			assert.Equal(t, "Token I'm a token", r.Header.Get("Authorization"))
+			body, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			assert.JSONEq(t, `{"name":"acme","perm_create_domain":false,"perm_delete_domain":false,"perm_manage_tokens":false,"max_age":"2592000","max_unused_period":null}`, string(body))
+			w.WriteHeader(201)
+			_, err = w.Write([]byte(mockToken))
+			assert.NoError(t, err)
+		}))
+		defer server.Close()
+		var client = createClient(t, server)
+		maxAge := "2592000"
+		// When
+		token, err := client.CreateToken(context.TODO(), Token{Name: "acme", MaxAge: &maxAge})
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, "3a6b94b5-d20e-40bd-a7cc-521f5c79fab3", token.ID)
+		assert.Equal(t, "4pnk7u-NHvrEkFzrhFDRTjGFyX_S", token.Token)
+		assert.Equal(t, "30 00:00:00", *token.MaxAge)
+		assert.Nil(t, token.MaxUnusedPeriod)
+		assert.Equal(t, []string{"0.0.0.0/0", "::/0"}, token.AllowedSubnets)
+	})
+}
+
+func TestDeleteToken(t *testing.T) {
+	t.Run("TestBasic", func(t *testing.T) {
+		// Given
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "DELETE", r.Method)
+			assert.Equal(t, "/api/v1/auth/tokens/3a6b94b5-d20e-40bd-a7cc-521f5c79fab3/", r.URL.Path)
+			w.WriteHeader(204)
+		}))
+		defer server.Close()
+		var client = createClient(t, server)
+		// When
+		err := client.DeleteToken(context.TODO(), "3a6b94b5-d20e-40bd-a7cc-521f5c79fab3")
+		// Then
+		assert.NoError(t, err)
+	})
+}
+
+func TestCreateTokenPolicy(t *testing.T) {
+	t.Run("TestBasic", func(t *testing.T) {
+		// Given
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "POST", r.Method)
+			assert.Equal(t, "/api/v1/auth/tokens/3a6b94b5-d20e-40bd-a7cc-521f5c79fab3/policies/rrsets/", r.URL.Path)
+			body, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			assert.JSONEq(t, `{"domain":"some-domain.dedyn.io","subname":"_acme-challenge","type":"TXT","perm_write":true}`, string(body))
+			w.WriteHeader(201)
+			_, err = w.Write([]byte(`{"id":"7aed3f71-bc81-4f7e-90ae-8f0df0d1c211","domain":"some-domain.dedyn.io","subname":"_acme-challenge","type":"TXT","perm_write":true}`))
+			assert.NoError(t, err)
+		}))
+		defer server.Close()
+		var client = createClient(t, server)
+		domain, subname, rrType := "some-domain.dedyn.io", "_acme-challenge", "TXT"
+		// When
+		policy, err := client.CreateTokenPolicy(context.TODO(), "3a6b94b5-d20e-40bd-a7cc-521f5c79fab3", TokenPolicy{Domain: &domain, Subname: &subname, Type: &rrType, PermWrite: true})
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, "7aed3f71-bc81-4f7e-90ae-8f0df0d1c211", policy.ID)
+		assert.True(t, policy.PermWrite)
+	})
+}
+
 func TestUpdateIp(t *testing.T) {
 	t.Run("TestBasic", func(t *testing.T) {
 		// Given
@@ -165,6 +456,8 @@ func TestUpdateIp(t *testing.T) {
 			assert.Equal(t, "/", r.URL.Path)
 			assert.Equal(t, "Token I'm a token", r.Header.Get("Authorization"))
 			assert.Equal(t, "1.2.3.4,2.3.4.5", r.URL.Query().Get("myip"))
+			assert.True(t, r.URL.Query().Has("myipv6"))
+			assert.Empty(t, r.URL.Query().Get("myipv6"))
 			assert.Equal(t, "some-domain.dedyn.io", r.URL.Query().Get("hostname"))
 			_, err := w.Write([]byte("good"))
 			assert.NoError(t, err)
@@ -172,7 +465,25 @@ func TestUpdateIp(t *testing.T) {
 		defer server.Close()
 		var client = createClient(t, server)
 		// When
-		err := client.UpdateIp([]string{"1.2.3.4", "2.3.4.5"})
+		err := client.UpdateIp(context.TODO(), []string{"1.2.3.4", "2.3.4.5"}, []string{})
+		// Then
+		assert.NoError(t, err)
+	})
+}
+
+func TestUpdateIpDualStack(t *testing.T) {
+	t.Run("TestBasic", func(t *testing.T) {
+		// Given
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "1.2.3.4", r.URL.Query().Get("myip"))
+			assert.Equal(t, "2001:db8::1,2001:db8::2", r.URL.Query().Get("myipv6"))
+			_, err := w.Write([]byte("good"))
+			assert.NoError(t, err)
+		}))
+		defer server.Close()
+		var client = createClient(t, server)
+		// When
+		err := client.UpdateIp(context.TODO(), []string{"1.2.3.4"}, []string{"2001:db8::1", "2001:db8::2"})
 		// Then
 		assert.NoError(t, err)
 	})
@@ -192,6 +503,8 @@ func TestNewClient(t *testing.T) {
 		assert.Equal(t, "the-token", client.token)
 		assert.Equal(t, "https://desec.io", client.mgmtHost)
 		assert.Equal(t, "https://update.dedyn.io", client.updateIpHost)
+		assert.Equal(t, DefaultTimeout, client.httpClient.Timeout)
+		assert.Equal(t, DefaultUserAgent, client.userAgent)
 	})
 
 	t.Run("TestNoTokenNoParty", func(t *testing.T) {
@@ -199,3 +512,82 @@ func TestNewClient(t *testing.T) {
 		assert.EqualError(t, err, "open /IDoNotExist/secret/token: no such file or directory")
 	})
 }
+
+type recordingTransport struct {
+	requests []*http.Request
+}
+
+func (r *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.requests = append(r.requests, req)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestOptions(t *testing.T) {
+	t.Run("TestUserAgentAndTransport", func(t *testing.T) {
+		// Given
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "some-agent/1.0", r.Header.Get("User-Agent"))
+			_, err := w.Write([]byte(mockDomains))
+			assert.NoError(t, err)
+		}))
+		defer server.Close()
+		transport := new(recordingTransport)
+		client, err := NewClient("some-domain.dedyn.io", util.CreateConfigDir(t, server.URL),
+			WithUserAgent("some-agent/1.0"),
+			WithTransport(transport),
+		)
+		assert.NoError(t, err)
+		// When
+		_, err = client.GetDomains(context.TODO())
+		// Then
+		assert.NoError(t, err)
+		assert.Len(t, transport.requests, 1)
+		assert.Equal(t, DefaultTimeout, client.httpClient.Timeout)
+	})
+
+	t.Run("TestTimeout", func(t *testing.T) {
+		// Given
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(100 * time.Millisecond)
+		}))
+		defer server.Close()
+		client, err := NewClient("some-domain.dedyn.io", util.CreateConfigDir(t, server.URL), WithTimeout(10*time.Millisecond))
+		assert.NoError(t, err)
+		// When
+		_, err = client.GetDomains(context.TODO())
+		// Then
+		assert.ErrorContains(t, err, "Client.Timeout exceeded")
+	})
+
+	t.Run("TestHTTPClient", func(t *testing.T) {
+		// Given
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, err := w.Write([]byte(mockDomains))
+			assert.NoError(t, err)
+		}))
+		defer server.Close()
+		transport := new(recordingTransport)
+		httpClient := &http.Client{Transport: transport}
+		client, err := NewClient("some-domain.dedyn.io", util.CreateConfigDir(t, server.URL), WithHTTPClient(httpClient))
+		assert.NoError(t, err)
+		// When
+		_, err = client.GetDomains(context.TODO())
+		// Then
+		assert.NoError(t, err)
+		assert.Len(t, transport.requests, 1)
+		assert.Same(t, httpClient, client.httpClient)
+	})
+
+	t.Run("TestCanceledContext", func(t *testing.T) {
+		// Given
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { assert.Fail(t, "Should not have been called") }))
+		defer server.Close()
+		var client = createClient(t, server)
+		ctx, cancel := context.WithCancel(context.TODO())
+		cancel()
+		// When
+		_, err := client.GetDomains(ctx)
+		// Then
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
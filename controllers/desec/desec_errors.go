@@ -0,0 +1,161 @@
+package desec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// APIError is returned if deSEC responded with an unexpected status.
+type APIError struct {
+	StatusCode int
+	Method     string
+	// Path of the request, without query, to not leak any parameters
+	Path string
+	// Detail as reported by deSEC, or the plain text body of the response
+	Detail string
+	// Fields holds validation errors of a single object
+	Fields FieldErrors
+	// Items holds the validation errors per object of a bulk request, in the
+	// order of the request
+	Items []FieldErrors
+}
+
+func (e *APIError) Error() string {
+	message := fmt.Sprintf("got status %d while trying to %s %s", e.StatusCode, e.Method, e.Path)
+	details := []string{}
+	if e.Detail != "" {
+		details = append(details, e.Detail)
+	}
+	if len(e.Fields) > 0 {
+		details = append(details, e.Fields.String())
+	}
+	for i, item := range e.Items {
+		if len(item) > 0 {
+			details = append(details, fmt.Sprintf("#%d: %s", i, item))
+		}
+	}
+	if len(details) > 0 {
+		message += ": " + strings.Join(details, ", ")
+	}
+	return message
+}
+
+// IsNotFound returns true if deSEC reported that the domain or RRSet does not
+// exist.
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+// IsConflict returns true if deSEC rejected a request as it conflicts with
+// existing data, e.g. a domain already owned by somebody else.
+func IsConflict(err error) bool {
+	return hasStatus(err, http.StatusConflict)
+}
+
+// IsUnauthorized returns true if deSEC rejected the token.
+func IsUnauthorized(err error) bool {
+	return hasStatus(err, http.StatusUnauthorized) || hasStatus(err, http.StatusForbidden)
+}
+
+// IsInvalid returns true if deSEC rejected the request due to validation
+// errors.
+func IsInvalid(err error) bool {
+	return hasStatus(err, http.StatusBadRequest)
+}
+
+// IsThrottled returns true if the request was, or would have been, rejected
+// due to rate limiting.
+func IsThrottled(err error) bool {
+	throttled := new(ThrottledError)
+	return errors.As(err, &throttled) || hasStatus(err, http.StatusTooManyRequests)
+}
+
+func hasStatus(err error, statusCode int) bool {
+	apiErr := new(APIError)
+	return errors.As(err, &apiErr) && apiErr.StatusCode == statusCode
+}
+
+// FieldErrors maps a field to the validation errors deSEC reported for it.
+type FieldErrors map[string]any
+
+func (f FieldErrors) String() string {
+	fields := make([]string, 0, len(f))
+	for field := range f {
+		fields = append(fields, field)
+	}
+	slices.Sort(fields)
+
+	messages := make([]string, 0, len(fields))
+	for _, field := range fields {
+		messages = append(messages, field+": "+strings.Join(flattenMessages(f[field]), " "))
+	}
+	return strings.Join(messages, "; ")
+}
+
+// flattenMessages collects the messages of a field, which deSEC reports as
+// list of strings, or as list of such lists for list fields like records.
+func flattenMessages(value any) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		messages := []string{}
+		for _, item := range v {
+			messages = append(messages, flattenMessages(item)...)
+		}
+		return messages
+	case map[string]any:
+		return []string{FieldErrors(v).String()}
+	default:
+		return []string{}
+	}
+}
+
+// newAPIError reads the error deSEC reported in the body of the response.
+func newAPIError(res *http.Response) error {
+	apiErr := &APIError{
+		StatusCode: res.StatusCode,
+		Method:     res.Request.Method,
+		Path:       requestPath(res.Request),
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 64*1024))
+	if err != nil {
+		return apiErr
+	}
+	body = bytes.TrimSpace(body)
+
+	switch {
+	case bytes.HasPrefix(body, []byte("[")):
+		if json.Unmarshal(body, &apiErr.Items) != nil {
+			apiErr.Items = nil
+		}
+	case bytes.HasPrefix(body, []byte("{")):
+		fields := FieldErrors{}
+		if json.Unmarshal(body, &fields) == nil {
+			if detail, ok := fields["detail"].(string); ok {
+				apiErr.Detail = detail
+				delete(fields, "detail")
+			}
+			if len(fields) > 0 {
+				apiErr.Fields = fields
+			}
+		}
+	default:
+		apiErr.Detail = string(body)
+	}
+	return apiErr
+}
+
+func requestPath(req *http.Request) string {
+	if req.URL.Path == "" {
+		return "/"
+	}
+	return req.URL.Path
+}
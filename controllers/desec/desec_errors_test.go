@@ -0,0 +1,89 @@
+package desec
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIError(t *testing.T) {
+	t.Run("TestNotFound", func(t *testing.T) {
+		// Given
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(404)
+			_, err := w.Write([]byte(`{"detail": "Not found."}`))
+			assert.NoError(t, err)
+		}))
+		defer server.Close()
+		var client = createClient(t, server)
+		// When
+		_, err := client.GetRRSets(context.TODO())
+		// Then
+		assert.EqualError(t, err, "got status 404 while trying to GET /api/v1/domains/some-domain.dedyn.io/rrsets/: Not found.")
+		assert.True(t, IsNotFound(err))
+		assert.False(t, IsConflict(err))
+		assert.False(t, IsThrottled(err))
+	})
+
+	t.Run("TestFieldErrorsWithoutPayload", func(t *testing.T) {
+		// Given
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(409)
+			_, err := w.Write([]byte(`{"name": ["This domain name conflicts with an existing domain."]}`))
+			assert.NoError(t, err)
+		}))
+		defer server.Close()
+		var client = createClient(t, server)
+		// When
+		_, err := client.CreateDomain(context.TODO())
+		// Then
+		assert.EqualError(t, err, "got status 409 while trying to POST /api/v1/domains/: name: This domain name conflicts with an existing domain.")
+		assert.True(t, IsConflict(err))
+		apiErr := err.(*APIError)
+		assert.Equal(t, "POST", apiErr.Method)
+		assert.Equal(t, "/api/v1/domains/", apiErr.Path)
+		assert.Empty(t, apiErr.Detail)
+		assert.Equal(t, FieldErrors{"name": []any{"This domain name conflicts with an existing domain."}}, apiErr.Fields)
+	})
+
+	t.Run("TestUpdateIpAnswer", func(t *testing.T) {
+		// Given
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, err := w.Write([]byte("dnserr"))
+			assert.NoError(t, err)
+		}))
+		defer server.Close()
+		var client = createClient(t, server)
+		// When
+		err := client.UpdateIp(context.TODO(), []string{"1.2.3.4"}, []string{})
+		// Then
+		assert.EqualError(t, err, "got status 200 while trying to GET /: dnserr")
+	})
+
+	t.Run("TestUnauthorized", func(t *testing.T) {
+		// Given
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(401)
+			_, err := w.Write([]byte("badauth"))
+			assert.NoError(t, err)
+		}))
+		defer server.Close()
+		var client = createClient(t, server)
+		// When
+		err := client.UpdateIp(context.TODO(), []string{"1.2.3.4"}, []string{})
+		// Then
+		assert.True(t, IsUnauthorized(err))
+		assert.False(t, IsNotFound(err))
+	})
+
+	t.Run("TestWrapped", func(t *testing.T) {
+		err := fmt.Errorf("wrapped: %w", &APIError{StatusCode: 404})
+		assert.True(t, IsNotFound(err))
+		assert.True(t, IsThrottled(fmt.Errorf("wrapped: %w", &ThrottledError{})))
+		assert.False(t, IsNotFound(nil))
+	})
+}
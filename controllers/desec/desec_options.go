@@ -0,0 +1,48 @@
+package desec
+
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	DefaultTimeout   = 30 * time.Second
+	DefaultUserAgent = "desec-dns-operator"
+)
+
+// Option customizes a Client created by NewClient.
+type Option func(*Client)
+
+// WithHTTPClient makes the Client use the given HTTP client. Its timeout and
+// transport are used as is.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithTransport makes the Client send its requests via the given transport.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(c *Client) {
+		httpClient := *c.httpClient
+		httpClient.Transport = transport
+		c.httpClient = &httpClient
+	}
+}
+
+// WithTimeout limits the time a single request, including reading the
+// response, may take.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		httpClient := *c.httpClient
+		httpClient.Timeout = timeout
+		c.httpClient = &httpClient
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent with every request.
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) {
+		c.userAgent = userAgent
+	}
+}
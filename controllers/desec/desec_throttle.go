@@ -0,0 +1,124 @@
+package desec
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// ThrottleClass is one of the rate limit classes deSEC applies, see
+// https://desec.readthedocs.io/en/latest/rate-limits.html
+type ThrottleClass string
+
+const (
+	ThrottleDnsApiRead         ThrottleClass = "dns_api_read"
+	ThrottleDnsApiWriteDomains ThrottleClass = "dns_api_write_domains"
+	ThrottleDnsApiWriteRRSets  ThrottleClass = "dns_api_write_rrsets"
+	ThrottleDynDns             ThrottleClass = "dyndns"
+)
+
+type Limit struct {
+	Count  int
+	Period time.Duration
+}
+
+// RateLimits are the limits of each throttle class as documented by deSEC.
+// Classes without any limits are not throttled client side.
+var RateLimits = map[ThrottleClass][]Limit{
+	ThrottleDnsApiRead:         {{10, time.Second}, {50, time.Minute}},
+	ThrottleDnsApiWriteDomains: {{10, time.Second}, {300, time.Minute}, {1000, time.Hour}},
+	ThrottleDnsApiWriteRRSets:  {{2, time.Second}, {15, time.Minute}, {30, time.Hour}, {300, 24 * time.Hour}},
+	ThrottleDynDns:             {{1, time.Minute}},
+}
+
+// ThrottledError is returned if a request was, or would have been, rejected
+// by deSEC due to rate limiting.
+type ThrottledError struct {
+	Class      ThrottleClass
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("throttled by deSEC (%s), retry after %s", e.Class, e.RetryAfter)
+}
+
+// throttle is a token bucket per limit of a throttle class, plus the time
+// deSEC told us to back off until.
+type throttle struct {
+	class ThrottleClass
+
+	mu           sync.Mutex
+	limiters     []*rate.Limiter
+	blockedUntil time.Time
+}
+
+// throttles holds the throttle of each endpoint, i.e. per host and class, as
+// well as per domain for classes deSEC applies per domain.
+var throttles sync.Map
+
+func getThrottle(class ThrottleClass, key string) *throttle {
+	if existing, ok := throttles.Load(string(class) + "|" + key); ok {
+		return existing.(*throttle)
+	}
+
+	t := &throttle{class: class}
+	for _, limit := range RateLimits[class] {
+		t.limiters = append(t.limiters, rate.NewLimiter(rate.Every(limit.Period/time.Duration(limit.Count)), limit.Count))
+	}
+	existing, _ := throttles.LoadOrStore(string(class)+"|"+key, t)
+	return existing.(*throttle)
+}
+
+// reserve takes a token from every bucket, or none at all if any of them is
+// exhausted, in which case a ThrottledError is returned.
+func (t *throttle) reserve() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(t.blockedUntil) {
+		return &ThrottledError{Class: t.class, RetryAfter: t.blockedUntil.Sub(now)}
+	}
+
+	reservations := make([]*rate.Reservation, 0, len(t.limiters))
+	var delay time.Duration
+	for _, limiter := range t.limiters {
+		reservation := limiter.ReserveN(now, 1)
+		reservations = append(reservations, reservation)
+		delay = max(delay, reservation.DelayFrom(now))
+	}
+	if delay > 0 {
+		for _, reservation := range reservations {
+			reservation.CancelAt(now)
+		}
+		return &ThrottledError{Class: t.class, RetryAfter: delay}
+	}
+	return nil
+}
+
+// throttled blocks the endpoint as requested by a 429 response.
+func (t *throttle) throttled(res *http.Response) error {
+	retryAfter := parseRetryAfter(res.Header.Get("Retry-After"), time.Now())
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.blockedUntil = time.Now().Add(retryAfter)
+
+	return &ThrottledError{Class: t.class, RetryAfter: retryAfter}
+}
+
+// parseRetryAfter parses the Retry-After header, which is either a number of
+// seconds or a HTTP date. Falls back to a minute if missing or malformed.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0)
+	}
+	return time.Minute
+}
@@ -0,0 +1,89 @@
+package desec
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThrottle(t *testing.T) {
+	t.Run("TestRetryAfter", func(t *testing.T) {
+		// Given
+		calls := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls = calls + 1
+			w.Header().Add("Retry-After", "30")
+			w.WriteHeader(429)
+		}))
+		defer server.Close()
+		var client = createClient(t, server)
+		// When
+		_, err := client.GetDomains(context.TODO())
+		// Then
+		throttled := new(ThrottledError)
+		assert.True(t, errors.As(err, &throttled))
+		assert.Equal(t, ThrottleDnsApiRead, throttled.Class)
+		assert.Equal(t, 30*time.Second, throttled.RetryAfter)
+		assert.Equal(t, 1, calls)
+
+		// When
+		_, err = client.GetRRSets(context.TODO())
+		// Then
+		assert.True(t, errors.As(err, &throttled))
+		assert.InDelta(t, 30*time.Second, throttled.RetryAfter, float64(time.Second))
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("TestTokenBucket", func(t *testing.T) {
+		// Given
+		calls := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls = calls + 1
+			_, err := w.Write([]byte("good"))
+			assert.NoError(t, err)
+		}))
+		defer server.Close()
+		var client = createClient(t, server)
+		// When
+		assert.NoError(t, client.UpdateIp(context.TODO(), []string{"1.2.3.4"}, []string{}))
+		err := client.UpdateIp(context.TODO(), []string{"1.2.3.4"}, []string{})
+		// Then
+		throttled := new(ThrottledError)
+		assert.True(t, errors.As(err, &throttled))
+		assert.Equal(t, ThrottleDynDns, throttled.Class)
+		assert.InDelta(t, time.Minute, throttled.RetryAfter, float64(time.Second))
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("TestBucketsPerDomain", func(t *testing.T) {
+		// Given
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, err := w.Write([]byte("good"))
+			assert.NoError(t, err)
+		}))
+		defer server.Close()
+		var client = createClient(t, server)
+		var otherClient = createClient(t, server)
+		otherClient.Domain = "some-other-domain.dedyn.io"
+		// When
+		assert.NoError(t, client.UpdateIp(context.TODO(), []string{"1.2.3.4"}, []string{}))
+		err := otherClient.UpdateIp(context.TODO(), []string{"1.2.3.4"}, []string{})
+		// Then
+		assert.NoError(t, err)
+	})
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2023, 6, 3, 8, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, 120*time.Second, parseRetryAfter("120", now))
+	assert.Equal(t, 90*time.Second, parseRetryAfter("Sat, 03 Jun 2023 08:01:30 GMT", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("Sat, 03 Jun 2023 07:59:00 GMT", now))
+	assert.Equal(t, time.Minute, parseRetryAfter("", now))
+	assert.Equal(t, time.Minute, parseRetryAfter("soon", now))
+}
@@ -4,16 +4,21 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"slices"
 	"testing"
 	"time"
 
 	v1 "github.com/j-be/desec-dns-operator/api/v1"
+	"github.com/j-be/desec-dns-operator/controllers/desec"
 	"github.com/j-be/desec-dns-operator/controllers/util"
 	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/events"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
@@ -58,9 +63,41 @@ func TestDesecDnsReconciler(t *testing.T) {
 			assert.Equal(t, metav1.ConditionTrue, condition.Status)
 			assert.Equal(t, "Updated", condition.Reason)
 			assert.Equal(t, "Updated to: [1.2.3.4]", condition.Message)
+			condition = meta.FindStatusCondition(desec.Status.Conditions, "IPv6")
+			assert.NotNil(t, condition)
+			assert.Equal(t, metav1.ConditionFalse, condition.Status)
+			assert.Equal(t, "NoAddresses", condition.Reason)
 		}
 	})
 
+	t.Run("Dual stack", func(t *testing.T) {
+		// Given
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "1.2.3.4", r.URL.Query().Get("myip"))
+			assert.Equal(t, "2001:db8::1", r.URL.Query().Get("myipv6"))
+			_, err := w.Write([]byte("good"))
+			assert.NoError(t, err)
+		}))
+		defer server.Close()
+		reconciler := createDesecDnsReconciler(t, server.URL, []string{"1.2.3.4", "2001:db8::1"})
+		// When
+		_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: util.NamespacedName})
+		// Then
+		assert.NoError(t, err)
+		desec := new(v1.DesecDns)
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, desec))
+		condition := meta.FindStatusCondition(desec.Status.Conditions, "IPv4")
+		assert.NotNil(t, condition)
+		assert.Equal(t, metav1.ConditionTrue, condition.Status)
+		assert.Equal(t, "Published", condition.Reason)
+		assert.Equal(t, "A record set to: [1.2.3.4]", condition.Message)
+		condition = meta.FindStatusCondition(desec.Status.Conditions, "IPv6")
+		assert.NotNil(t, condition)
+		assert.Equal(t, metav1.ConditionTrue, condition.Status)
+		assert.Equal(t, "Published", condition.Reason)
+		assert.Equal(t, "AAAA record set to: [2001:db8::1]", condition.Message)
+	})
+
 	t.Run("No IPs, no call", func(t *testing.T) {
 		// Given
 		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { assert.Fail(t, "Should not have been called") }))
@@ -79,7 +116,7 @@ func TestDesecDnsReconciler(t *testing.T) {
 
 	t.Run("Error is registered", func(t *testing.T) {
 		// Given
-		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(404) }))
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(500) }))
 		defer server.Close()
 		reconciler := createDesecDnsReconciler(t, server.URL, []string{"1.2.3.4"})
 		desec := new(v1.DesecDns)
@@ -89,7 +126,7 @@ func TestDesecDnsReconciler(t *testing.T) {
 			// When
 			_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: util.NamespacedName})
 			// Then
-			assert.EqualError(t, err, "got status code 404")
+			assert.EqualError(t, err, "got status 500 while trying to GET /")
 
 			assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, desec))
 			if len(statusGeneration) == 0 {
@@ -101,10 +138,410 @@ func TestDesecDnsReconciler(t *testing.T) {
 			assert.NotNil(t, condition)
 			assert.Equal(t, metav1.ConditionFalse, condition.Status)
 			assert.Equal(t, "Error", condition.Reason)
-			assert.Equal(t, "got status code 404", condition.Message)
+			assert.Equal(t, "got status 500 while trying to GET /", condition.Message)
 		}
 	})
 
+	t.Run("Unknown domain is registered", func(t *testing.T) {
+		// Given
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(404)
+			_, err := w.Write([]byte("nohost"))
+			assert.NoError(t, err)
+		}))
+		defer server.Close()
+		reconciler := createDesecDnsReconciler(t, server.URL, []string{"1.2.3.4"})
+		// When
+		result, err := reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: util.NamespacedName})
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, 5*time.Minute, result.RequeueAfter)
+		desec := new(v1.DesecDns)
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, desec))
+		condition := meta.FindStatusCondition(desec.Status.Conditions, "IpUpdate")
+		assert.NotNil(t, condition)
+		assert.Equal(t, metav1.ConditionFalse, condition.Status)
+		assert.Equal(t, "NotFound", condition.Reason)
+		assert.Equal(t, "got status 404 while trying to GET /: nohost", condition.Message)
+	})
+
+	t.Run("Throttling is registered", func(t *testing.T) {
+		// Given
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Retry-After", "42")
+			w.WriteHeader(429)
+		}))
+		defer server.Close()
+		reconciler := createDesecDnsReconciler(t, server.URL, []string{"1.2.3.4"})
+		// When
+		result, err := reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: util.NamespacedName})
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, 42*time.Second, result.RequeueAfter)
+		desec := new(v1.DesecDns)
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, desec))
+		condition := meta.FindStatusCondition(desec.Status.Conditions, "IpUpdate")
+		assert.NotNil(t, condition)
+		assert.Equal(t, metav1.ConditionFalse, condition.Status)
+		assert.Equal(t, "Throttled", condition.Reason)
+		assert.Equal(t, "Throttled by deSEC (dyndns)", condition.Message)
+	})
+
+	t.Run("Account is used", func(t *testing.T) {
+		// Given
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "Token account token", r.Header.Get("Authorization"))
+			_, err := w.Write([]byte("good"))
+			assert.NoError(t, err)
+		}))
+		defer server.Close()
+		reconciler := createDesecDnsReconciler(t, "http://localhost:1", []string{"1.2.3.4"})
+		createAccount(t, reconciler.Client, server.URL, "account token")
+		dnsCr := new(v1.DesecDns)
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		dnsCr.Spec.Account = accountRequest.Name
+		assert.NoError(t, reconciler.Update(context.TODO(), dnsCr))
+		// When
+		_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: util.NamespacedName})
+		// Then
+		assert.NoError(t, err)
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		condition := meta.FindStatusCondition(dnsCr.Status.Conditions, "IpUpdate")
+		assert.NotNil(t, condition)
+		assert.Equal(t, metav1.ConditionTrue, condition.Status)
+		assert.Equal(t, []reconcile.Request{{NamespacedName: util.NamespacedName}}, reconciler.usingAccount(context.TODO(), getAccountOf(t, reconciler.Client)))
+	})
+
+	t.Run("Registry", func(t *testing.T) {
+		// Given
+		mock := &desecMock{rrsets: []desec.RRSet{
+			{Domain: "some-domain.dedyn.io", Subname: "", Type: "AAAA", Records: []string{"2001:db8::2"}, TTL: 3600},
+		}}
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := createDesecDnsReconciler(t, server.URL, []string{"1.2.3.4"})
+		reconciler.OwnerID = "my-cluster"
+		// When
+		_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: util.NamespacedName})
+		// Then the owner is recorded in the same request, and AAAA of others are left alone
+		assert.NoError(t, err)
+		assert.Equal(t, 1, mock.bulkRequests)
+		assert.Equal(t, []string{"1.2.3.4"}, findRRSet(mock.rrsets, "", "A").Records)
+		assert.Equal(t, []string{`"heritage=desec-dns-operator,owner=my-cluster,resource=desecdns/some-domain.dedyn.io,version=1"`}, findRRSet(mock.rrsets, "_desec-owner.a", "TXT").Records)
+		assert.Equal(t, []string{"2001:db8::2"}, findRRSet(mock.rrsets, "", "AAAA").Records)
+		dnsCr := new(v1.DesecDns)
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		condition := meta.FindStatusCondition(dnsCr.Status.Conditions, "IpUpdate")
+		assert.NotNil(t, condition)
+		assert.Equal(t, "Updated", condition.Reason)
+
+		// When the A record is taken by somebody else
+		mock.rrsets = slices.DeleteFunc(mock.rrsets, func(rrset desec.RRSet) bool { return rrset.Type == "TXT" })
+		mock.rrsets = append(mock.rrsets, desec.RRSet{Domain: "some-domain.dedyn.io", Subname: "_desec-owner.a", Type: "TXT", Records: []string{`"heritage=desec-dns-operator,owner=other,resource=desecdns/some-domain.dedyn.io,version=1"`}, TTL: 3600})
+		dnsCr.Spec.IPs = []string{"2.3.4.5"}
+		assert.NoError(t, reconciler.Update(context.TODO(), dnsCr))
+		result, err := reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: util.NamespacedName})
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, 5*time.Minute, result.RequeueAfter)
+		assert.Equal(t, 1, mock.bulkRequests)
+		assert.Equal(t, []string{"1.2.3.4"}, findRRSet(mock.rrsets, "", "A").Records)
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		condition = meta.FindStatusCondition(dnsCr.Status.Conditions, "IpUpdate")
+		assert.NotNil(t, condition)
+		assert.Equal(t, metav1.ConditionFalse, condition.Status)
+		assert.Equal(t, "NotOwned", condition.Reason)
+		assert.Equal(t, "A owned by other (desecdns/some-domain.dedyn.io)", condition.Message)
+	})
+
+	t.Run("Observe reports drift", func(t *testing.T) {
+		// Given
+		mock := &desecMock{rrsets: []desec.RRSet{{Domain: "some-domain.dedyn.io", Type: "A", Records: []string{"5.6.7.8"}}}}
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := createDesecDnsReconciler(t, server.URL, []string{"1.2.3.4"})
+		dnsCr := new(v1.DesecDns)
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		dnsCr.Spec.AdoptionPolicy = v1.AdoptionPolicyObserve
+		assert.NoError(t, reconciler.Update(context.TODO(), dnsCr))
+		// When
+		result, err := reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: util.NamespacedName})
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, 5*time.Minute, result.RequeueAfter)
+		assert.Zero(t, mock.bulkRequests)
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		condition := meta.FindStatusCondition(dnsCr.Status.Conditions, "IpUpdate")
+		if assert.NotNil(t, condition) {
+			assert.Equal(t, metav1.ConditionFalse, condition.Status)
+			assert.Equal(t, "Drift", condition.Reason)
+			assert.Equal(t, "Would update A from [5.6.7.8] to [1.2.3.4]", condition.Message)
+		}
+
+		// When in sync
+		mock.rrsets[0].Records = []string{"1.2.3.4"}
+		_, err = reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: util.NamespacedName})
+		// Then
+		assert.NoError(t, err)
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		assert.True(t, isConditionReason(dnsCr.Status.Conditions, "IpUpdate", "InSync"))
+	})
+
+	t.Run("Dry-run", func(t *testing.T) {
+		// Given
+		mock := &desecMock{
+			domains: []desec.Domain{{Name: "some-domain.dedyn.io"}},
+			rrsets:  []desec.RRSet{{Domain: "some-domain.dedyn.io", Type: "A", Records: []string{"5.6.7.8"}}},
+		}
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := createDesecDnsReconciler(t, server.URL, []string{"1.2.3.4"})
+		reconciler.DryRun = true
+		recorder := events.NewFakeRecorder(10)
+		reconciler.Recorder = recorder
+		dnsCr := new(v1.DesecDns)
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		dnsCr.Spec.DeletionPolicy = v1.DeletionPolicyDelete
+		assert.NoError(t, reconciler.Update(context.TODO(), dnsCr))
+		dnsCr.Status.CreatedDomain = true
+		assert.NoError(t, reconciler.Status().Update(context.TODO(), dnsCr))
+		// When
+		reconcileIngress(t, &reconciler, reconcile.Request{NamespacedName: util.NamespacedName})
+		// Then
+		assert.Equal(t, []string{"5.6.7.8"}, mock.rrsets[0].Records)
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		assert.Equal(t, []string{"update the IPs to [1.2.3.4]"}, dnsCr.Status.PlannedChanges)
+		assert.Equal(t, "Normal DryRun Would update the IPs to [1.2.3.4]", <-recorder.Events)
+
+		// When deleted, after the domain is not declared anymore
+		assert.NoError(t, os.Remove(reconciler.ConfigDir+"/config/domain"))
+		assert.NoError(t, reconciler.Delete(context.TODO(), dnsCr))
+		result, err := reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: util.NamespacedName})
+		// Then the domain is kept, and so is the finalizer
+		assert.NoError(t, err)
+		assert.Equal(t, 5*time.Minute, result.RequeueAfter)
+		assert.Len(t, mock.domains, 1)
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		assert.Equal(t, []string{dnsFinalizer}, dnsCr.Finalizers)
+		assert.Equal(t, []string{"delete the domain"}, dnsCr.Status.PlannedChanges)
+		assert.Equal(t, "Normal DryRun Would delete the domain", <-recorder.Events)
+	})
+
+	t.Run("Dry-run of the domain", func(t *testing.T) {
+		// Given
+		mock := &desecMock{domains: []desec.Domain{{Name: "some-domain.dedyn.io"}}}
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := createDesecDnsReconciler(t, server.URL, []string{"1.2.3.4"})
+		reconciler.OwnerID = "some-cluster"
+		desecDomain := &v1.DesecDomain{ObjectMeta: metav1.ObjectMeta{
+			Name:        util.NamespacedName.Name,
+			Namespace:   util.NamespacedName.Namespace,
+			Annotations: map[string]string{dryRunAnnotation: "true"},
+		}}
+		assert.NoError(t, reconciler.Create(context.TODO(), desecDomain))
+		// When
+		_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: util.NamespacedName})
+		// Then
+		assert.NoError(t, err)
+		assert.Empty(t, mock.rrsets)
+		dnsCr := new(v1.DesecDns)
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		assert.Equal(t, []string{"update the IPs to [1.2.3.4]"}, dnsCr.Status.PlannedChanges)
+
+		// When dry-run is turned off for the domain
+		desecDomain.Annotations = nil
+		assert.NoError(t, reconciler.Update(context.TODO(), desecDomain))
+		_, err = reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: util.NamespacedName})
+		// Then
+		assert.NoError(t, err)
+		if a := findRRSet(mock.rrsets, "", "A"); assert.NotNil(t, a) {
+			assert.Equal(t, []string{"1.2.3.4"}, a.Records)
+		}
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		assert.Empty(t, dnsCr.Status.PlannedChanges)
+
+		// When dry-run is turned on again
+		mock.rrsets = nil
+		desecDomain.Annotations = map[string]string{dryRunAnnotation: "true"}
+		assert.NoError(t, reconciler.Update(context.TODO(), desecDomain))
+		_, err = reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: util.NamespacedName})
+		// Then
+		assert.NoError(t, err)
+		assert.Empty(t, mock.rrsets)
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		assert.Equal(t, []string{"update the IPs to [1.2.3.4]"}, dnsCr.Status.PlannedChanges)
+	})
+
+	t.Run("Deletion policy", func(t *testing.T) {
+		setup := func(t *testing.T, policy v1.DeletionPolicy, createdDomain bool) (*desecMock, *httptest.Server, DesecDnsReconciler) {
+			mock := &desecMock{
+				domains: []desec.Domain{{Name: "some-domain.dedyn.io"}},
+				rrsets: []desec.RRSet{
+					{Domain: "some-domain.dedyn.io", Type: "A", Records: []string{"1.2.3.4"}},
+					{Domain: "some-domain.dedyn.io", Type: "MX", Records: []string{"10 mail.some-domain.dedyn.io."}},
+					{Domain: "some-domain.dedyn.io", Subname: "www", Type: "CNAME", Records: []string{"some-domain.dedyn.io."}},
+					{Domain: "some-domain.dedyn.io", Subname: "_desec-owner.cname.www", Type: "TXT", Records: []string{`"heritage=desec-dns-operator,owner=some-cluster,resource=ingress/some-namespace/some-ingress,version=1"`}},
+					{Domain: "some-domain.dedyn.io", Subname: "blog", Type: "CNAME", Records: []string{"elsewhere.example.com."}},
+				},
+			}
+			server := createDesecServer(t, mock)
+			reconciler := createDesecDnsReconciler(t, server.URL, []string{"1.2.3.4"})
+			// The domain is not declared anymore
+			assert.NoError(t, os.Remove(reconciler.ConfigDir+"/config/domain"))
+			dnsCr := new(v1.DesecDns)
+			assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+			dnsCr.Spec.DeletionPolicy = policy
+			assert.NoError(t, reconciler.Update(context.TODO(), dnsCr))
+			dnsCr.Status = util.InitializeDesecDnsStatus()
+			dnsCr.Status.CreatedDomain = createdDomain
+			util.UpdateDesecDnsStatus(&dnsCr.Status, "www", metav1.ConditionTrue, "Created", "")
+			util.UpdateDesecDnsStatus(&dnsCr.Status, "blog", metav1.ConditionFalse, "NotOwned", "CNAME not owned by the operator")
+			assert.NoError(t, reconciler.Status().Update(context.TODO(), dnsCr))
+			return mock, server, reconciler
+		}
+		deleteDesecDns := func(t *testing.T, reconciler DesecDnsReconciler) {
+			dnsCr := new(v1.DesecDns)
+			assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+			assert.NoError(t, reconciler.Delete(context.TODO(), dnsCr))
+			result, err := reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: util.NamespacedName})
+			assert.NoError(t, err)
+			assert.True(t, result.IsZero())
+			assert.EqualError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr), `desecdnses.desec.owly.dedyn.io "some-domain.dedyn.io" not found`)
+		}
+
+		t.Run("Retain", func(t *testing.T) {
+			// Given
+			mock, server, reconciler := setup(t, v1.DeletionPolicyRetain, true)
+			defer server.Close()
+			// When
+			deleteDesecDns(t, reconciler)
+			// Then
+			assert.Len(t, mock.domains, 1)
+			assert.Len(t, mock.rrsets, 5)
+		})
+
+		t.Run("Delete managed RRSets", func(t *testing.T) {
+			// Given
+			mock, server, reconciler := setup(t, v1.DeletionPolicyDelete, false)
+			defer server.Close()
+			reconciler.OwnerID = "some-cluster"
+			result, err := reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: util.NamespacedName})
+			assert.NoError(t, err)
+			assert.Equal(t, 100*time.Millisecond, result.RequeueAfter)
+			dnsCr := new(v1.DesecDns)
+			assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+			assert.Equal(t, []string{dnsFinalizer}, dnsCr.Finalizers)
+			// Taking over the A records
+			dnsCr.Spec.AdoptionPolicy = v1.AdoptionPolicyAdopt
+			assert.NoError(t, reconciler.Update(context.TODO(), dnsCr))
+			_, err = reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: util.NamespacedName})
+			assert.NoError(t, err)
+			assert.NotNil(t, findRRSet(mock.rrsets, "_desec-owner.a", "TXT"))
+			bulkRequests := mock.bulkRequests
+			// When
+			deleteDesecDns(t, reconciler)
+			// Then
+			assert.Len(t, mock.domains, 1)
+			assert.Equal(t, bulkRequests+1, mock.bulkRequests)
+			names := []string{}
+			for _, rrset := range mock.rrsets {
+				names = append(names, nameOf(rrset.Subname, rrset.Type))
+			}
+			assert.ElementsMatch(t, []string{"@/MX", "blog/CNAME"}, names)
+		})
+
+		t.Run("Apex owned by somebody else", func(t *testing.T) {
+			// Given
+			mock, server, reconciler := setup(t, v1.DeletionPolicyDelete, false)
+			defer server.Close()
+			reconciler.OwnerID = "some-cluster"
+			mock.rrsets = append(mock.rrsets, desec.RRSet{Domain: "some-domain.dedyn.io", Subname: "_desec-owner.a", Type: "TXT", Records: []string{`"heritage=desec-dns-operator,owner=other-cluster,resource=desecdns/other/some-domain.dedyn.io,version=1"`}})
+			result, err := reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: util.NamespacedName})
+			assert.NoError(t, err)
+			assert.Equal(t, 100*time.Millisecond, result.RequeueAfter)
+			_, err = reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: util.NamespacedName})
+			assert.NoError(t, err)
+			dnsCr := new(v1.DesecDns)
+			assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+			assert.True(t, isConditionReason(dnsCr.Status.Conditions, "IpUpdate", "NotOwned"))
+			// When
+			deleteDesecDns(t, reconciler)
+			// Then
+			names := []string{}
+			for _, rrset := range mock.rrsets {
+				names = append(names, nameOf(rrset.Subname, rrset.Type))
+			}
+			assert.ElementsMatch(t, []string{"@/A", "_desec-owner.a/TXT", "@/MX", "blog/CNAME"}, names)
+		})
+
+		t.Run("Delete created domain", func(t *testing.T) {
+			// Given
+			mock, server, reconciler := setup(t, v1.DeletionPolicyDelete, true)
+			defer server.Close()
+			_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: util.NamespacedName})
+			assert.NoError(t, err)
+			// When
+			deleteDesecDns(t, reconciler)
+			// Then
+			assert.Empty(t, mock.domains)
+			assert.Empty(t, mock.rrsets)
+			assert.Zero(t, mock.bulkRequests)
+		})
+
+		t.Run("Domain still declared", func(t *testing.T) {
+			// Given
+			mock, server, reconciler := setup(t, v1.DeletionPolicyDelete, true)
+			defer server.Close()
+			_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: util.NamespacedName})
+			assert.NoError(t, err)
+			desecDomain := &v1.DesecDomain{ObjectMeta: metav1.ObjectMeta{Name: "some-domain.dedyn.io", Namespace: "desec-dns-operator"}}
+			assert.NoError(t, reconciler.Create(context.TODO(), desecDomain))
+			dnsCr := new(v1.DesecDns)
+			assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+			assert.NoError(t, reconciler.Delete(context.TODO(), dnsCr))
+			// When
+			result, err := reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: util.NamespacedName})
+			// Then the DesecDns is released, retaining everything
+			assert.NoError(t, err)
+			assert.True(t, result.IsZero())
+			assert.Len(t, mock.domains, 1)
+			assert.Len(t, mock.rrsets, 5)
+			assert.EqualError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr), `desecdnses.desec.owly.dedyn.io "some-domain.dedyn.io" not found`)
+		})
+
+		t.Run("Error is registered", func(t *testing.T) {
+			// Given
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "DELETE", r.Method)
+				w.WriteHeader(500)
+				_, err := w.Write([]byte(`{"detail": "Internal Server Error"}`))
+				assert.NoError(t, err)
+			}))
+			defer server.Close()
+			_, _, reconciler := setup(t, v1.DeletionPolicyDelete, true)
+			reconciler.ConfigDir = util.CreateConfigDir(t, server.URL)
+			assert.NoError(t, os.Remove(reconciler.ConfigDir+"/config/domain"))
+			_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: util.NamespacedName})
+			assert.NoError(t, err)
+			dnsCr := new(v1.DesecDns)
+			assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+			assert.NoError(t, reconciler.Delete(context.TODO(), dnsCr))
+			// When
+			_, err = reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: util.NamespacedName})
+			// Then
+			assert.Error(t, err)
+			assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+			assert.Equal(t, []string{dnsFinalizer}, dnsCr.Finalizers)
+			condition := meta.FindStatusCondition(dnsCr.Status.Conditions, util.DeletionConditionType)
+			if assert.NotNil(t, condition) {
+				assert.Equal(t, metav1.ConditionFalse, condition.Status)
+				assert.Equal(t, "Error", condition.Reason)
+			}
+			assert.NotContains(t, util.GetCnameSubnames(dnsCr.Status), util.DeletionConditionType)
+		})
+	})
+
 	t.Run("Not doing anything if not found", func(t *testing.T) {
 		// Given
 		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(404) }))
@@ -121,7 +558,15 @@ func TestDesecDnsReconciler(t *testing.T) {
 	})
 }
 
+// disableRateLimits disables client side rate limiting for the duration of the test.
+func disableRateLimits(t *testing.T) {
+	rateLimits := desec.RateLimits
+	desec.RateLimits = map[desec.ThrottleClass][]desec.Limit{}
+	t.Cleanup(func() { desec.RateLimits = rateLimits })
+}
+
 func createDesecDnsReconciler(t *testing.T, serverUrl string, ips []string) DesecDnsReconciler {
+	disableRateLimits(t)
 	objects := []client.Object{
 		&v1.DesecDns{
 			Spec:       v1.DesecDnsSpec{IPs: ips},
@@ -131,6 +576,7 @@ func createDesecDnsReconciler(t *testing.T, serverUrl string, ips []string) Dese
 
 	mockScheme := runtime.NewScheme()
 	assert.NoError(t, v1.AddToScheme(mockScheme))
+	assert.NoError(t, corev1.AddToScheme(mockScheme))
 
 	fakeClient := fake.NewClientBuilder().
 		WithScheme(mockScheme).
@@ -0,0 +1,124 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	v1 "github.com/j-be/desec-dns-operator/api/v1"
+	"github.com/j-be/desec-dns-operator/controllers/desec"
+	"github.com/j-be/desec-dns-operator/controllers/util"
+)
+
+// DesecAccountReconciler reconciles a DesecAccount object
+type DesecAccountReconciler struct {
+	client.Client
+	Scheme    *runtime.Scheme
+	ConfigDir string
+	// Options for the deSEC clients created while reconciling
+	ClientOptions []desec.Option
+}
+
+//+kubebuilder:rbac:groups=desec.owly.dedyn.io,resources=desecaccounts,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=desec.owly.dedyn.io,resources=desecaccounts/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=desec.owly.dedyn.io,resources=desecaccounts/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+
+// Reconcile checks whether deSEC accepts the token of a DesecAccount.
+func (r *DesecAccountReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, err error) {
+	defer func() { result, err = requeueIfThrottled(ctx, result, err) }()
+	log := log.FromContext(ctx)
+
+	log.Info("Starting", "req", req)
+
+	// Fetch CR
+	account := new(v1.DesecAccount)
+	if err := r.Get(ctx, req.NamespacedName, account); err != nil {
+		if apierrors.IsNotFound(err) {
+			log.Info("CR not found, not doing anything", "req", req)
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	// Read the token, changes of the Secret are watched
+	token, err := getToken(ctx, r.Client, account.Spec.TokenSecretRef)
+	if err != nil {
+		return ctrl.Result{}, r.updateStatus(ctx, account, metav1.ConditionFalse, "SecretInvalid", err.Error())
+	}
+
+	// Check the token
+	desecClient := desec.NewClientFor("", desec.Account{
+		Token:        token,
+		MgmtHost:     account.Spec.MgmtHost,
+		UpdateIpHost: account.Spec.UpdateIpHost,
+	}, r.ClientOptions...)
+	_, err = desecClient.GetDomains(ctx)
+	if desec.IsUnauthorized(err) {
+		return ctrl.Result{}, r.updateStatus(ctx, account, metav1.ConditionFalse, "Unauthorized", err.Error())
+	}
+	if err != nil {
+		log.Error(err, "Failed to fetch domains")
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, r.updateStatus(ctx, account, metav1.ConditionTrue, "Authenticated", "")
+}
+
+func (r *DesecAccountReconciler) updateStatus(ctx context.Context, account *v1.DesecAccount, conditionStatus metav1.ConditionStatus, reason string, message string) error {
+	if !util.UpdateCondition(&account.Status.Conditions, "Ready", conditionStatus, reason, message) {
+		return nil
+	}
+	return r.Status().Update(ctx, account)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *DesecAccountReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.ConfigDir = "./mnt"
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1.DesecAccount{}).
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.referencingSecret)).
+		Complete(r)
+}
+
+// referencingSecret requests all accounts referencing the Secret to be
+// reconciled.
+func (r *DesecAccountReconciler) referencingSecret(ctx context.Context, secret client.Object) []reconcile.Request {
+	accounts := v1.DesecAccountList{}
+	if err := r.List(ctx, &accounts); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list accounts")
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, account := range accounts.Items {
+		ref := account.Spec.TokenSecretRef
+		if ref.Name == secret.GetName() && ref.Namespace == secret.GetNamespace() {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&account)})
+		}
+	}
+	return requests
+}
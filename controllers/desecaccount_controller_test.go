@@ -0,0 +1,165 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	v1 "github.com/j-be/desec-dns-operator/api/v1"
+	"github.com/j-be/desec-dns-operator/controllers/util"
+)
+
+var accountRequest = reconcile.Request{NamespacedName: types.NamespacedName{Name: "some-account"}}
+
+func TestDesecAccountReconciler(t *testing.T) {
+	t.Run("Basic", func(t *testing.T) {
+		// Given
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/api/v1/domains/", r.URL.Path)
+			assert.Equal(t, "Token account token", r.Header.Get("Authorization"))
+			_, err := w.Write([]byte("[]"))
+			assert.NoError(t, err)
+		}))
+		defer server.Close()
+		reconciler := createDesecAccountReconciler(t)
+		createAccount(t, reconciler.Client, server.URL, "account token")
+		// When
+		result, err := reconciler.Reconcile(context.TODO(), accountRequest)
+		// Then
+		assert.NoError(t, err)
+		assert.True(t, result.IsZero())
+		condition := meta.FindStatusCondition(getAccountOf(t, reconciler.Client).Status.Conditions, "Ready")
+		assert.NotNil(t, condition)
+		assert.Equal(t, metav1.ConditionTrue, condition.Status)
+		assert.Equal(t, "Authenticated", condition.Reason)
+	})
+
+	t.Run("Invalid secret", func(t *testing.T) {
+		// Given
+		reconciler := createDesecAccountReconciler(t)
+		createAccount(t, reconciler.Client, "http://localhost", "")
+		// When
+		result, err := reconciler.Reconcile(context.TODO(), accountRequest)
+		// Then
+		assert.NoError(t, err)
+		assert.True(t, result.IsZero())
+		condition := meta.FindStatusCondition(getAccountOf(t, reconciler.Client).Status.Conditions, "Ready")
+		assert.NotNil(t, condition)
+		assert.Equal(t, metav1.ConditionFalse, condition.Status)
+		assert.Equal(t, "SecretInvalid", condition.Reason)
+		assert.Equal(t, `secret desec-dns-operator/some-account-token has no key "token"`, condition.Message)
+	})
+
+	t.Run("Unlabeled secret", func(t *testing.T) {
+		// Given
+		reconciler := createDesecAccountReconciler(t)
+		createAccount(t, reconciler.Client, "http://localhost", "account token")
+		secret := new(corev1.Secret)
+		assert.NoError(t, reconciler.Get(context.TODO(), types.NamespacedName{Name: "some-account-token", Namespace: "desec-dns-operator"}, secret))
+		secret.Labels = nil
+		assert.NoError(t, reconciler.Update(context.TODO(), secret))
+		// When
+		result, err := reconciler.Reconcile(context.TODO(), accountRequest)
+		// Then
+		assert.NoError(t, err)
+		assert.True(t, result.IsZero())
+		condition := meta.FindStatusCondition(getAccountOf(t, reconciler.Client).Status.Conditions, "Ready")
+		assert.NotNil(t, condition)
+		assert.Equal(t, metav1.ConditionFalse, condition.Status)
+		assert.Equal(t, "SecretInvalid", condition.Reason)
+		assert.Equal(t, "secret desec-dns-operator/some-account-token is not labeled desec.owly.dedyn.io/token-secret=true", condition.Message)
+	})
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		// Given
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(401)
+			_, err := w.Write([]byte(`{"detail": "Invalid token."}`))
+			assert.NoError(t, err)
+		}))
+		defer server.Close()
+		reconciler := createDesecAccountReconciler(t)
+		createAccount(t, reconciler.Client, server.URL, "revoked token")
+		// When
+		result, err := reconciler.Reconcile(context.TODO(), accountRequest)
+		// Then
+		assert.NoError(t, err)
+		assert.True(t, result.IsZero())
+		condition := meta.FindStatusCondition(getAccountOf(t, reconciler.Client).Status.Conditions, "Ready")
+		assert.NotNil(t, condition)
+		assert.Equal(t, metav1.ConditionFalse, condition.Status)
+		assert.Equal(t, "Unauthorized", condition.Reason)
+		assert.Equal(t, "got status 401 while trying to GET /api/v1/domains/: Invalid token.", condition.Message)
+	})
+
+	t.Run("Secrets are mapped to accounts", func(t *testing.T) {
+		// Given
+		reconciler := createDesecAccountReconciler(t)
+		createAccount(t, reconciler.Client, "http://localhost", "account token")
+		// When
+		requests := reconciler.referencingSecret(context.TODO(), &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "some-account-token", Namespace: "desec-dns-operator"}})
+		// Then
+		assert.Equal(t, []reconcile.Request{accountRequest}, requests)
+		assert.Empty(t, reconciler.referencingSecret(context.TODO(), &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "some-account-token", Namespace: "default"}}))
+	})
+}
+
+func createDesecAccountReconciler(t *testing.T) DesecAccountReconciler {
+	disableRateLimits(t)
+	mockScheme := runtime.NewScheme()
+	assert.NoError(t, v1.AddToScheme(mockScheme))
+	assert.NoError(t, corev1.AddToScheme(mockScheme))
+
+	fakeClient := fake.NewClientBuilder().
+		WithScheme(mockScheme).
+		WithStatusSubresource(new(v1.DesecAccount)).
+		Build()
+
+	return DesecAccountReconciler{
+		Client:    fakeClient,
+		Scheme:    mockScheme,
+		ConfigDir: util.CreateConfigDir(t, "http://localhost"),
+	}
+}
+
+// createAccount creates the DesecAccount some-account for the server, and the
+// Secret holding its token. An empty token leaves the Secret empty.
+func createAccount(t *testing.T, c client.Client, serverUrl string, token string) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "some-account-token",
+			Namespace: "desec-dns-operator",
+			Labels:    map[string]string{TokenSecretLabel: "true"},
+		},
+		Data: map[string][]byte{},
+	}
+	if token != "" {
+		secret.Data["token"] = []byte(token)
+	}
+	assert.NoError(t, c.Create(context.TODO(), secret))
+	assert.NoError(t, c.Create(context.TODO(), &v1.DesecAccount{
+		ObjectMeta: metav1.ObjectMeta{Name: accountRequest.Name},
+		Spec: v1.DesecAccountSpec{
+			TokenSecretRef: v1.SecretKeyReference{Name: secret.Name, Namespace: secret.Namespace},
+			MgmtHost:       serverUrl,
+			UpdateIpHost:   serverUrl,
+		},
+	}))
+}
+
+func getAccountOf(t *testing.T, c client.Client) *v1.DesecAccount {
+	account := new(v1.DesecAccount)
+	assert.NoError(t, c.Get(context.TODO(), accountRequest.NamespacedName, account))
+	return account
+}
@@ -18,44 +18,61 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 
-	"k8s.io/apimachinery/pkg/api/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/events"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	v1 "github.com/j-be/desec-dns-operator/api/v1"
+	"github.com/j-be/desec-dns-operator/controllers/config"
 	"github.com/j-be/desec-dns-operator/controllers/desec"
 	"github.com/j-be/desec-dns-operator/controllers/util"
 )
 
+const dnsFinalizer = "desec.owly.dedyn.io/domain"
+
 // DesecDnsReconciler reconciles a DesecDns object
 type DesecDnsReconciler struct {
 	client.Client
 	Scheme    *runtime.Scheme
 	ConfigDir string
+	// Options for the deSEC clients created while reconciling
+	ClientOptions []desec.Option
+	// OwnerID enables the registry, see IngressReconciler. The IPs are set
+	// via the RRSets of the domain then, as dynDNS cannot record the owner.
+	OwnerID string
+	// Recorder for events regarding DesecDns, none are emitted if nil
+	Recorder events.EventRecorder
+	// DryRun only plans the changes to deSEC of all DesecDns, instead of
+	// applying them
+	DryRun bool
 }
 
 //+kubebuilder:rbac:groups=desec.owly.dedyn.io,resources=desecdnsdnses,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=desec.owly.dedyn.io,resources=desecdnsdnses/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=desec.owly.dedyn.io,resources=desecdnsdnses/finalizers,verbs=update
+//+kubebuilder:rbac:groups=desec.owly.dedyn.io,resources=desecaccounts,verbs=get;list;watch
+//+kubebuilder:rbac:groups=desec.owly.dedyn.io,resources=desecdomains,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
 
-// Reconcile is part of the main kubernetes reconciliation loop which aims to
-// move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the DesecDns object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
-//
-// For more details, check Reconcile and its Result here:
-// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.14.1/pkg/reconcile
-func (r *DesecDnsReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+// Reconcile keeps the A and AAAA records of the domain described by a DesecDns
+// pointed at the public IPs of the cluster.
+func (r *DesecDnsReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, err error) {
+	defer func() { result, err = requeueIfThrottled(ctx, result, err) }()
 	log := log.FromContext(ctx)
 
 	log.Info("Starting", "req", req)
@@ -63,13 +80,28 @@ func (r *DesecDnsReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 	// Fetch CR
 	dnsCr := v1.DesecDns{}
 	if err := r.Get(ctx, req.NamespacedName, &dnsCr); err != nil {
-		if errors.IsNotFound(err) {
+		if apierrors.IsNotFound(err) {
 			log.Info("CR not found, not doing anything", "req", req)
 			return ctrl.Result{}, nil
 		}
 		return ctrl.Result{Requeue: true, RequeueAfter: 100 * time.Millisecond}, err
 	}
 
+	// Clean up deSEC before releasing the CR, if asked to
+	if !dnsCr.DeletionTimestamp.IsZero() {
+		return r.finalize(ctx, &dnsCr)
+	}
+	finalizerChanged := false
+	if dnsCr.Spec.DeletionPolicy == v1.DeletionPolicyDelete {
+		finalizerChanged = controllerutil.AddFinalizer(&dnsCr, dnsFinalizer)
+	} else {
+		finalizerChanged = controllerutil.RemoveFinalizer(&dnsCr, dnsFinalizer)
+	}
+	if finalizerChanged {
+		err := r.Update(ctx, &dnsCr)
+		return ctrl.Result{Requeue: true, RequeueAfter: 100 * time.Millisecond}, err
+	}
+
 	// Get and check IPs
 	ips := dnsCr.Spec.IPs
 	if len(ips) == 0 {
@@ -78,22 +110,73 @@ func (r *DesecDnsReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 	}
 
 	// Create deSEC client
-	desecClient, err := desec.NewClient(req.Name, r.ConfigDir)
+	desecClient, err := newDesecClient(ctx, r.Client, r.ConfigDir, dnsCr.Spec.Account, dnsCr.Namespace, req.Name, r.ClientOptions...)
 	if err != nil {
 		log.Error(err, "Cannot create client")
 		return ctrl.Result{}, err
 	}
+	domain, err := r.getManagedDomain(ctx, dnsCr.Name)
+	if err != nil {
+		log.Error(err, "Failed to list domains")
+		return ctrl.Result{}, err
+	}
+
+	ipv4s, ipv6s := util.SplitIps(ips)
+	if dnsCr.Spec.AdoptionPolicy == v1.AdoptionPolicyObserve {
+		return r.reportIpDrift(ctx, desecClient, &dnsCr, map[string][]string{"A": ipv4s, "AAAA": ipv6s})
+	}
+	if r.isDryRun(&dnsCr, domain) {
+		return r.planIpUpdate(ctx, desecClient, &dnsCr, ips, map[string][]string{"A": ipv4s, "AAAA": ipv6s})
+	}
+
+	// Record the A and AAAA records replaced by the first update
+	statusUpdate := false
+	if dnsCr.Spec.AdoptionPolicy == v1.AdoptionPolicyAdopt && !isConditionReason(dnsCr.Status.Conditions, "IpUpdate", "Updated") {
+		for rrType, ips := range map[string][]string{"A": ipv4s, "AAAA": ipv6s} {
+			existing, err := desecClient.GetRRSet(ctx, "", rrType)
+			if err != nil && !desec.IsNotFound(err) {
+				return ctrl.Result{}, err
+			}
+			if existing != nil && !slices.Equal(slices.Sorted(slices.Values(existing.Records)), slices.Sorted(slices.Values(ips))) {
+				statusUpdate = addAdopted(&dnsCr.Status, "", rrType) || statusUpdate
+			}
+		}
+	}
 
 	// Update IPs
 	log.Info("Updating IPs")
-	statusUpdate := false
-	if err = desecClient.UpdateIp(ips); err != nil {
-		statusUpdate = util.UpdateDesecDnsStatus(&dnsCr.Status, "IpUpdate", metav1.ConditionFalse, "Error", err.Error())
+	conflicts := []string{}
+	if r.OwnerID != "" {
+		conflicts, err = r.setIPs(ctx, desecClient, &dnsCr, map[string][]string{"A": ipv4s, "AAAA": ipv6s})
 	} else {
+		err = desecClient.UpdateIp(ctx, ipv4s, ipv6s)
+	}
+	throttled := new(desec.ThrottledError)
+	switch {
+	case err == nil && len(conflicts) > 0:
+		// Retrying right away won't help, the RRSets of others stay
+		statusUpdate = util.UpdateDesecDnsStatus(&dnsCr.Status, "IpUpdate", metav1.ConditionFalse, "NotOwned", strings.Join(conflicts, ", ")) || statusUpdate
+	case err == nil:
 		message := fmt.Sprintf("Updated to: [%s]", strings.Join(ips, ", "))
-		statusUpdate = util.UpdateDesecDnsStatus(&dnsCr.Status, "IpUpdate", metav1.ConditionTrue, "Updated", message)
+		statusUpdate = util.UpdateDesecDnsStatus(&dnsCr.Status, "IpUpdate", metav1.ConditionTrue, "Updated", message) || statusUpdate
+		statusUpdate = updateIpFamilyStatus(&dnsCr.Status, "IPv4", "A", ipv4s) || statusUpdate
+		statusUpdate = updateIpFamilyStatus(&dnsCr.Status, "IPv6", "AAAA", ipv6s) || statusUpdate
+	case errors.As(err, &throttled):
+		message := fmt.Sprintf("Throttled by deSEC (%s)", throttled.Class)
+		statusUpdate = util.UpdateDesecDnsStatus(&dnsCr.Status, "IpUpdate", metav1.ConditionFalse, "Throttled", message) || statusUpdate
+	case desec.IsNotFound(err):
+		// Retrying right away won't help, the domain has to be created first
+		statusUpdate = util.UpdateDesecDnsStatus(&dnsCr.Status, "IpUpdate", metav1.ConditionFalse, "NotFound", err.Error()) || statusUpdate
+		err = nil
+	case desec.IsUnauthorized(err):
+		// Retrying right away won't help, the token has to be fixed first
+		statusUpdate = util.UpdateDesecDnsStatus(&dnsCr.Status, "IpUpdate", metav1.ConditionFalse, "Unauthorized", err.Error()) || statusUpdate
+		err = nil
+	default:
+		statusUpdate = util.UpdateDesecDnsStatus(&dnsCr.Status, "IpUpdate", metav1.ConditionFalse, "Error", err.Error()) || statusUpdate
 	}
 
+	statusUpdate = plan(r.Recorder, &dnsCr, &dnsCr.Status.PlannedChanges, isIPChange, nil) || statusUpdate
 	if statusUpdate {
 		if err := r.Client.Status().Update(ctx, &dnsCr); err != nil {
 			return ctrl.Result{}, err
@@ -102,11 +185,363 @@ func (r *DesecDnsReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 	return ctrl.Result{Requeue: true, RequeueAfter: 5 * time.Minute}, err
 }
 
+// setIPs sets the A and AAAA RRSets of the domain along with the TXT records
+// of the registry in a single request. Nothing is set if any of the RRSets is
+// owned by somebody else, the conflicts are returned instead.
+func (r *DesecDnsReconciler) setIPs(ctx context.Context, desecClient desec.Client, dnsCr *v1.DesecDns, ips map[string][]string) ([]string, error) {
+	rrsets, err := desecClient.GetRRSetsBySubname(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, rrType := range []string{"A", "AAAA"} {
+		txt, err := desecClient.GetRRSet(ctx, registrySubname("", rrType), "TXT")
+		if err != nil {
+			return nil, err
+		}
+		if txt != nil {
+			rrsets = append(rrsets, *txt)
+		}
+	}
+	reg := registry{ownerID: r.OwnerID, rrsets: rrsets}
+	// Records set before the registry was enabled are owned as well
+	tracked := isConditionReason(dnsCr.Status.Conditions, "IpUpdate", "Updated") || dnsCr.Spec.AdoptionPolicy == v1.AdoptionPolicyAdopt
+
+	conflicts := []string{}
+	changes := []desec.RRSet{}
+	for _, rrType := range []string{"A", "AAAA"} {
+		existing := findRRSet(rrsets, "", rrType)
+		records := slices.Sorted(slices.Values(ips[rrType]))
+		if len(records) == 0 {
+			// Remove the records of the family, unless they are of others
+			if existing != nil && reg.owns("", rrType, tracked) {
+				changes = append(changes, desec.RRSet{Type: rrType, Records: []string{}, TTL: existing.TTL})
+				if txt, ok := reg.release("", rrType); ok {
+					changes = append(changes, txt)
+				}
+			}
+			continue
+		}
+		if conflict := reg.conflict("", rrType, tracked); conflict != "" {
+			conflicts = append(conflicts, conflict)
+			continue
+		}
+		ttl := int64(3600)
+		if existing != nil {
+			ttl = existing.TTL
+		}
+		if existing == nil || !slices.Equal(slices.Sorted(slices.Values(existing.Records)), records) {
+			changes = append(changes, desec.RRSet{Type: rrType, Records: records, TTL: ttl})
+		}
+		if txt, ok := reg.claim("", rrType, "desecdns/"+dnsCr.Name, ttl); ok {
+			changes = append(changes, txt)
+		}
+	}
+	if len(conflicts) > 0 {
+		return conflicts, nil
+	}
+	_, err = desecClient.BulkUpsertRRSets(ctx, changes)
+	return nil, err
+}
+
+// finalize removes what the operator published from deSEC, the entire domain
+// if the operator created it, and releases the CR.
+func (r *DesecDnsReconciler) finalize(ctx context.Context, dnsCr *v1.DesecDns) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+	if !controllerutil.ContainsFinalizer(dnsCr, dnsFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	// Nothing was changed while observing
+	if dnsCr.Spec.DeletionPolicy == v1.DeletionPolicyDelete && dnsCr.Spec.AdoptionPolicy != v1.AdoptionPolicyObserve {
+		domain, err := r.getManagedDomain(ctx, dnsCr.Name)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if domain != nil {
+			// Whatever is removed would be recreated right away, so everything is
+			// retained, and the DesecDns is recreated along with the records
+			log.Info("Domain still declared, retaining it", "domain", dnsCr.Name)
+			if r.Recorder != nil {
+				r.Recorder.Eventf(dnsCr, nil, corev1.EventTypeWarning, "StillManaged", "Delete", "Retaining %s, as it is still declared by a DesecDomain or the configuration", dnsCr.Name)
+			}
+			controllerutil.RemoveFinalizer(dnsCr, dnsFinalizer)
+			return ctrl.Result{}, r.Update(ctx, dnsCr)
+		}
+		desecClient, err := newDesecClient(ctx, r.Client, r.ConfigDir, dnsCr.Spec.Account, dnsCr.Namespace, dnsCr.Name, r.ClientOptions...)
+		if err != nil {
+			log.Error(err, "Cannot create client")
+			return ctrl.Result{}, err
+		}
+		if r.isDryRun(dnsCr, domain) {
+			return r.planDeletion(ctx, desecClient, dnsCr)
+		}
+		if dnsCr.Status.CreatedDomain {
+			log.Info("Deleting domain", "domain", desecClient.Domain)
+			if err := r.updateDeletionStatus(ctx, dnsCr, "DeletingDomain", "Deleting the domain"); err != nil {
+				return ctrl.Result{}, err
+			}
+			err = desecClient.DeleteDomain(ctx)
+		} else {
+			err = r.removeRRSets(ctx, desecClient, dnsCr)
+		}
+		if err != nil && !desec.IsNotFound(err) {
+			return r.reportDeletionError(ctx, dnsCr, err)
+		}
+	}
+
+	controllerutil.RemoveFinalizer(dnsCr, dnsFinalizer)
+	return ctrl.Result{}, r.Update(ctx, dnsCr)
+}
+
+// getManagedDomain returns the domain as declared by a DesecDomain or the
+// configuration, nil if it is not declared anymore.
+func (r *DesecDnsReconciler) getManagedDomain(ctx context.Context, name string) (*managedDomain, error) {
+	desecConfig, err := config.NewConfigFor(r.ConfigDir)
+	if err != nil {
+		return nil, err
+	}
+	managedDomains, err := getManagedDomains(ctx, r.Client, desecConfig)
+	if err != nil {
+		return nil, err
+	}
+	index := slices.IndexFunc(managedDomains, func(managed managedDomain) bool { return managed.name == name })
+	if index < 0 {
+		return nil, nil
+	}
+	return &managedDomains[index], nil
+}
+
+// removeRRSets removes the A and AAAA records of the domain, as well as all
+// RRSets published for sources, like CNAMEs, from deSEC at once.
+func (r *DesecDnsReconciler) removeRRSets(ctx context.Context, desecClient desec.Client, dnsCr *v1.DesecDns) error {
+	log := log.FromContext(ctx)
+	obsolete, err := r.getManagedRRSets(ctx, desecClient, dnsCr)
+	if err != nil || len(obsolete) == 0 {
+		return err
+	}
+	names := []string{}
+	for _, rrset := range obsolete {
+		names = append(names, nameOf(rrset.Subname, rrset.Type))
+	}
+	log.Info("Removing RRSets", "domain", desecClient.Domain, "rrsets", names)
+	if err := r.updateDeletionStatus(ctx, dnsCr, "RemovingRRSets", "Removing "+strings.Join(names, ", ")); err != nil {
+		return err
+	}
+	return desecClient.BulkDeleteRRSets(ctx, obsolete)
+}
+
+// planDeletion publishes the removal from deSEC as planned, instead of
+// removing anything. The DesecDns is kept until not in dry-run anymore.
+func (r *DesecDnsReconciler) planDeletion(ctx context.Context, desecClient desec.Client, dnsCr *v1.DesecDns) (ctrl.Result, error) {
+	planned := []string{"delete the domain"}
+	if !dnsCr.Status.CreatedDomain {
+		obsolete, err := r.getManagedRRSets(ctx, desecClient, dnsCr)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		planned = []string{}
+		for _, rrset := range obsolete {
+			planned = append(planned, "remove "+nameOf(rrset.Subname, rrset.Type))
+		}
+	}
+	if plan(r.Recorder, dnsCr, &dnsCr.Status.PlannedChanges, isAnyChange, planned) {
+		if err := r.Status().Update(ctx, dnsCr); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+}
+
+// getManagedRRSets returns the A and AAAA records of the domain, as well as
+// all RRSets published for sources, sorted by name. Only RRSets owned
+// according to the registry are returned, along with their TXT records.
+func (r *DesecDnsReconciler) getManagedRRSets(ctx context.Context, desecClient desec.Client, dnsCr *v1.DesecDns) ([]desec.RRSet, error) {
+	rrsets, err := desecClient.GetRRSets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Whether each RRSet was set by the operator, or taken over
+	tracked := map[string]bool{}
+	ipsTracked := isConditionReason(dnsCr.Status.Conditions, "IpUpdate", "Updated") || dnsCr.Spec.AdoptionPolicy == v1.AdoptionPolicyAdopt
+	for _, rrType := range []string{"A", "AAAA"} {
+		tracked[nameOf("", rrType)] = ipsTracked
+	}
+	for _, subname := range util.GetCnameSubnames(dnsCr.Status) {
+		if isPublished(dnsCr.Status, subname) {
+			for _, rrType := range subnameTypes {
+				tracked[nameOf(subname, rrType)] = true
+			}
+		}
+	}
+	for _, subname := range dnsCr.Status.SRVSubnames {
+		tracked[nameOf(subname, "SRV")] = true
+	}
+
+	reg := registry{ownerID: r.OwnerID, rrsets: rrsets}
+	obsolete := []desec.RRSet{}
+	for _, rrset := range rrsets {
+		name := nameOf(rrset.Subname, rrset.Type)
+		wasTracked, ok := tracked[name]
+		if !ok {
+			continue
+		}
+		if reg.owns(rrset.Subname, rrset.Type, wasTracked || slices.Contains(dnsCr.Status.Adopted, name)) {
+			obsolete = append(obsolete, rrset)
+		}
+		// Along with the TXT record of the registry, if ours
+		if _, ok := reg.release(rrset.Subname, rrset.Type); ok {
+			obsolete = append(obsolete, *findRRSet(rrsets, registrySubname(rrset.Subname, rrset.Type), "TXT"))
+		}
+	}
+	slices.SortFunc(obsolete, func(a, b desec.RRSet) int {
+		return strings.Compare(nameOf(a.Subname, a.Type), nameOf(b.Subname, b.Type))
+	})
+	return obsolete, nil
+}
+
+// updateDeletionStatus reports the progress of removing the DesecDns from
+// deSEC.
+func (r *DesecDnsReconciler) updateDeletionStatus(ctx context.Context, dnsCr *v1.DesecDns, reason string, message string) error {
+	if util.UpdateDesecDnsStatus(&dnsCr.Status, util.DeletionConditionType, metav1.ConditionFalse, reason, message) {
+		return r.Status().Update(ctx, dnsCr)
+	}
+	return nil
+}
+
+// reportDeletionError reports errors of deSEC while removing the DesecDns.
+// Errors which won't go away by retrying right away are not returned.
+func (r *DesecDnsReconciler) reportDeletionError(ctx context.Context, dnsCr *v1.DesecDns, err error) (ctrl.Result, error) {
+	if desec.IsThrottled(err) {
+		// Handled by requeueIfThrottled
+		return ctrl.Result{}, err
+	}
+	if desec.IsUnauthorized(err) {
+		// Retrying right away won't help, the token has to be fixed first
+		if statusErr := r.updateDeletionStatus(ctx, dnsCr, "Unauthorized", err.Error()); statusErr != nil {
+			return ctrl.Result{}, statusErr
+		}
+		return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+	}
+	if statusErr := r.updateDeletionStatus(ctx, dnsCr, "Error", err.Error()); statusErr != nil {
+		return ctrl.Result{}, statusErr
+	}
+	return ctrl.Result{}, err
+}
+
+// reportIpDrift compares the A and AAAA records of the domain to the IPs by
+// type, instead of updating them.
+func (r *DesecDnsReconciler) reportIpDrift(ctx context.Context, desecClient desec.Client, dnsCr *v1.DesecDns, ips map[string][]string) (ctrl.Result, error) {
+	drift, err := getIpDrift(ctx, desecClient, ips)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	statusUpdate := false
+	if len(drift) > 0 {
+		message := "Would update " + strings.Join(drift, ", ")
+		statusUpdate = util.UpdateDesecDnsStatus(&dnsCr.Status, "IpUpdate", metav1.ConditionFalse, "Drift", message)
+	} else {
+		statusUpdate = util.UpdateDesecDnsStatus(&dnsCr.Status, "IpUpdate", metav1.ConditionTrue, "InSync", "")
+	}
+	if statusUpdate {
+		if err := r.Status().Update(ctx, dnsCr); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+}
+
+// planIpUpdate publishes the update of the IPs as planned, if they differ,
+// instead of updating them.
+func (r *DesecDnsReconciler) planIpUpdate(ctx context.Context, desecClient desec.Client, dnsCr *v1.DesecDns, ips []string, ipsByType map[string][]string) (ctrl.Result, error) {
+	drift, err := getIpDrift(ctx, desecClient, ipsByType)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	planned := []string{}
+	if len(drift) > 0 {
+		planned = append(planned, fmt.Sprintf("%s to [%s]", ipChangePrefix, strings.Join(ips, ", ")))
+	}
+	if plan(r.Recorder, dnsCr, &dnsCr.Status.PlannedChanges, isIPChange, planned) {
+		if err := r.Status().Update(ctx, dnsCr); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+}
+
+// getIpDrift compares the A and AAAA records of the domain to the IPs by
+// type, and returns the differences, e.g. A from [1.2.3.4] to [5.6.7.8].
+func getIpDrift(ctx context.Context, desecClient desec.Client, ips map[string][]string) ([]string, error) {
+	drift := []string{}
+	for _, rrType := range []string{"A", "AAAA"} {
+		existing, err := desecClient.GetRRSet(ctx, "", rrType)
+		if err != nil {
+			return nil, err
+		}
+		records := []string{}
+		if existing != nil {
+			records = slices.Sorted(slices.Values(existing.Records))
+		}
+		if !slices.Equal(records, slices.Sorted(slices.Values(ips[rrType]))) {
+			drift = append(drift, fmt.Sprintf("%s from [%s] to [%s]", rrType, strings.Join(records, ", "), strings.Join(ips[rrType], ", ")))
+		}
+	}
+	return drift, nil
+}
+
+// isDryRun reports whether the changes to deSEC are only planned, either as
+// asked for by the DesecDns itself or by the DesecDomain of the domain.
+func (r *DesecDnsReconciler) isDryRun(dnsCr *v1.DesecDns, domain *managedDomain) bool {
+	return r.DryRun || isAnnotatedDryRun(dnsCr) || (domain != nil && domain.dryRun)
+}
+
+// updateIpFamilyStatus reports the published records of an IP family.
+func updateIpFamilyStatus(status *v1.DesecDnsStatus, conditionType string, rrType string, ips []string) bool {
+	if len(ips) == 0 {
+		message := fmt.Sprintf("No %s addresses, %s record removed", conditionType, rrType)
+		return util.UpdateDesecDnsStatus(status, conditionType, metav1.ConditionFalse, "NoAddresses", message)
+	}
+	message := fmt.Sprintf("%s record set to: [%s]", rrType, strings.Join(ips, ", "))
+	return util.UpdateDesecDnsStatus(status, conditionType, metav1.ConditionTrue, "Published", message)
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *DesecDnsReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	r.ConfigDir = "./mnt"
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&v1.DesecDns{}).
-		WithEventFilter(predicate.GenerationChangedPredicate{}).
+		Watches(&v1.DesecAccount{}, handler.EnqueueRequestsFromMapFunc(r.usingAccount)).
+		Watches(&v1.DesecDomain{}, handler.EnqueueRequestsFromMapFunc(r.ofDomain)).
+		// Pick up the dry-run annotations right away
+		WithEventFilter(predicate.Or(predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{})).
 		Complete(r)
 }
+
+// ofDomain requests the DesecDns of the DesecDomain to be reconciled, e.g. as
+// it asks for a dry-run.
+func (r *DesecDnsReconciler) ofDomain(ctx context.Context, desecDomain client.Object) []reconcile.Request {
+	desecConfig, err := config.NewConfigFor(r.ConfigDir)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Failed to read the configuration")
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: desecConfig.NamespacedNameFor(desecDomain.GetName())}}
+}
+
+// usingAccount requests all DesecDns using the account to be reconciled.
+func (r *DesecDnsReconciler) usingAccount(ctx context.Context, account client.Object) []reconcile.Request {
+	dnsCrs := v1.DesecDnsList{}
+	if err := r.List(ctx, &dnsCrs); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list DesecDns")
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, dnsCr := range dnsCrs.Items {
+		if dnsCr.Spec.Account == account.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&dnsCr)})
+		}
+	}
+	return requests
+}
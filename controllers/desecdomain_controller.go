@@ -0,0 +1,179 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/events"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	v1 "github.com/j-be/desec-dns-operator/api/v1"
+	"github.com/j-be/desec-dns-operator/controllers/desec"
+	"github.com/j-be/desec-dns-operator/controllers/util"
+)
+
+// DesecDomainReconciler reconciles a DesecDomain object
+type DesecDomainReconciler struct {
+	client.Client
+	Scheme    *runtime.Scheme
+	ConfigDir string
+	// Options for the deSEC clients created while reconciling
+	ClientOptions []desec.Option
+	// Recorder for events regarding DesecDomains, none are emitted if nil
+	Recorder events.EventRecorder
+	// DryRun only plans the creation of all domains, instead of creating them
+	DryRun bool
+}
+
+//+kubebuilder:rbac:groups=desec.owly.dedyn.io,resources=desecdomains,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=desec.owly.dedyn.io,resources=desecdomains/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=desec.owly.dedyn.io,resources=desecdomains/finalizers,verbs=update
+
+// Reconcile makes sure the domain described by a DesecDomain exists on deSEC,
+// if its creation policy allows creating it.
+func (r *DesecDomainReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, err error) {
+	defer func() { result, err = requeueIfThrottled(ctx, result, err) }()
+	log := log.FromContext(ctx)
+
+	log.Info("Starting", "req", req)
+
+	// Fetch CR
+	desecDomain := new(v1.DesecDomain)
+	if err := r.Get(ctx, req.NamespacedName, desecDomain); err != nil {
+		if apierrors.IsNotFound(err) {
+			log.Info("CR not found, not doing anything", "req", req)
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	// Create deSEC client
+	desecClient, err := newDesecClient(ctx, r.Client, r.ConfigDir, desecDomain.Spec.Account, desecDomain.Namespace, desecDomain.Name, r.ClientOptions...)
+	if err != nil {
+		log.Error(err, "Cannot create client")
+		return ctrl.Result{}, err
+	}
+
+	// Make sure domain exists
+	domains, err := desecClient.GetDomains(ctx)
+	if err != nil {
+		log.Error(err, "Failed to fetch domains")
+		return ctrl.Result{}, err
+	}
+	index := slices.IndexFunc(domains, func(domain desec.Domain) bool { return domain.Name == desecClient.Domain })
+	var domain desec.Domain
+	switch {
+	case index >= 0 && desecDomain.Spec.AdoptionPolicy == v1.AdoptionPolicyCreate && !desecDomain.Status.CreatedDomain:
+		message := "The domain existed before and adoptionPolicy is Create"
+		return r.updateStatus(ctx, desecDomain, metav1.ConditionFalse, "AlreadyExists", message, desec.Domain{}, nil)
+	case index >= 0:
+		domain = domains[index]
+	case desecDomain.Spec.CreationPolicy == v1.CreationPolicyNever:
+		message := "The domain does not exist and creationPolicy is Never"
+		return r.updateStatus(ctx, desecDomain, metav1.ConditionFalse, "NotFound", message, desec.Domain{}, nil)
+	case desecDomain.Spec.AdoptionPolicy == v1.AdoptionPolicyObserve:
+		message := "The domain does not exist and adoptionPolicy is Observe"
+		return r.updateStatus(ctx, desecDomain, metav1.ConditionFalse, "NotFound", message, desec.Domain{}, nil)
+	case r.DryRun || isAnnotatedDryRun(desecDomain):
+		planned := []string{"create the domain"}
+		return r.updateStatus(ctx, desecDomain, metav1.ConditionFalse, "DryRun", "The domain does not exist yet", desec.Domain{}, planned)
+	default:
+		// Remember the domain is ours before creating it, so it is still
+		// recognised as such if reporting its creation fails
+		desecDomain.Status.CreatedDomain = true
+		util.UpdateCondition(&desecDomain.Status.Conditions, "Ready", metav1.ConditionFalse, "Creating", "")
+		if err := r.Status().Update(ctx, desecDomain); err != nil {
+			return ctrl.Result{}, err
+		}
+		log.Info("Creating domain", "domain", desecClient.Domain)
+		domain, err = createDomain(ctx, desecClient)
+		if desec.IsConflict(err) || desec.IsInvalid(err) {
+			// Retrying won't help, e.g. the domain is owned by somebody else
+			log.Error(err, "Cannot create domain")
+			desecDomain.Status.CreatedDomain = false
+			return r.updateStatus(ctx, desecDomain, metav1.ConditionFalse, "Rejected", err.Error(), desec.Domain{}, nil)
+		}
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	return r.updateStatus(ctx, desecDomain, metav1.ConditionTrue, "Created", "", domain, nil)
+}
+
+// createDomain creates the domain on deSEC. The DesecDns of the domain may
+// create it at the same time, so a conflict is accepted if the domain showed
+// up in the account in the meantime.
+func createDomain(ctx context.Context, desecClient desec.Client) (desec.Domain, error) {
+	domain, err := desecClient.CreateDomain(ctx)
+	if !desec.IsConflict(err) {
+		return domain, err
+	}
+	domains, fetchErr := desecClient.GetDomains(ctx)
+	if fetchErr != nil {
+		return desec.Domain{}, fetchErr
+	}
+	index := slices.IndexFunc(domains, func(domain desec.Domain) bool { return domain.Name == desecClient.Domain })
+	if index < 0 {
+		return desec.Domain{}, err
+	}
+	log.FromContext(ctx).Info("Domain was created concurrently", "domain", desecClient.Domain)
+	return domains[index], nil
+}
+
+// updateStatus reports the state of the domain along with the planned changes,
+// and requeues to notice if it changes on deSEC.
+func (r *DesecDomainReconciler) updateStatus(
+	ctx context.Context,
+	desecDomain *v1.DesecDomain,
+	conditionStatus metav1.ConditionStatus,
+	reason string,
+	message string,
+	domain desec.Domain,
+	planned []string,
+) (ctrl.Result, error) {
+	statusUpdate := desecDomain.Status.MinimumTTL != domain.Minimum_TTL || desecDomain.Status.Created != domain.Created
+	desecDomain.Status.MinimumTTL = domain.Minimum_TTL
+	desecDomain.Status.Created = domain.Created
+	statusUpdate = util.UpdateCondition(&desecDomain.Status.Conditions, "Ready", conditionStatus, reason, message) || statusUpdate
+	statusUpdate = plan(r.Recorder, desecDomain, &desecDomain.Status.PlannedChanges, isAnyChange, planned) || statusUpdate
+	if statusUpdate {
+		if err := r.Status().Update(ctx, desecDomain); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *DesecDomainReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.ConfigDir = "./mnt"
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1.DesecDomain{}).
+		// Pick up the dry-run annotation right away
+		WithEventFilter(predicate.Or(predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{})).
+		Complete(r)
+}
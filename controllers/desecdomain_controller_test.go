@@ -0,0 +1,275 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/events"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	v1 "github.com/j-be/desec-dns-operator/api/v1"
+	"github.com/j-be/desec-dns-operator/controllers/desec"
+	"github.com/j-be/desec-dns-operator/controllers/util"
+)
+
+var domainRequest = reconcile.Request{NamespacedName: types.NamespacedName{Name: "some-domain.dedyn.io", Namespace: "desec-dns-operator"}}
+
+func TestDesecDomainReconciler(t *testing.T) {
+	t.Run("Basic", func(t *testing.T) {
+		// Given
+		mock := new(desecMock)
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := createDesecDomainReconciler(t, server.URL, v1.CreationPolicyIfNotPresent)
+		// When
+		result, err := reconciler.Reconcile(context.TODO(), domainRequest)
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, 5*time.Minute, result.RequeueAfter)
+		assert.Len(t, mock.domains, 1)
+		assert.Equal(t, "some-domain.dedyn.io", mock.domains[0].Name)
+		desecDomain := getDesecDomain(t, reconciler)
+		assert.Equal(t, int64(3600), desecDomain.Status.MinimumTTL)
+		assert.Equal(t, "2023-01-01T00:00:00Z", desecDomain.Status.Created)
+		assert.True(t, desecDomain.Status.CreatedDomain)
+		condition := meta.FindStatusCondition(desecDomain.Status.Conditions, "Ready")
+		assert.NotNil(t, condition)
+		assert.Equal(t, metav1.ConditionTrue, condition.Status)
+		assert.Equal(t, "Created", condition.Reason)
+
+		// When
+		_, err = reconciler.Reconcile(context.TODO(), domainRequest)
+		// Then
+		assert.NoError(t, err)
+		assert.Len(t, mock.domains, 1)
+	})
+
+	t.Run("Never create", func(t *testing.T) {
+		// Given
+		mock := new(desecMock)
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := createDesecDomainReconciler(t, server.URL, v1.CreationPolicyNever)
+		// When
+		result, err := reconciler.Reconcile(context.TODO(), domainRequest)
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, 5*time.Minute, result.RequeueAfter)
+		assert.Empty(t, mock.domains)
+		condition := meta.FindStatusCondition(getDesecDomain(t, reconciler).Status.Conditions, "Ready")
+		assert.NotNil(t, condition)
+		assert.Equal(t, metav1.ConditionFalse, condition.Status)
+		assert.Equal(t, "NotFound", condition.Reason)
+
+		// When created by somebody else
+		mock.domains = []desec.Domain{{Name: "some-domain.dedyn.io", Minimum_TTL: 60}}
+		_, err = reconciler.Reconcile(context.TODO(), domainRequest)
+		// Then
+		assert.NoError(t, err)
+		desecDomain := getDesecDomain(t, reconciler)
+		assert.Equal(t, int64(60), desecDomain.Status.MinimumTTL)
+		condition = meta.FindStatusCondition(desecDomain.Status.Conditions, "Ready")
+		assert.NotNil(t, condition)
+		assert.Equal(t, metav1.ConditionTrue, condition.Status)
+	})
+
+	t.Run("Domain owned by somebody else", func(t *testing.T) {
+		// Given
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "POST" {
+				w.WriteHeader(409)
+				_, err := w.Write([]byte(`{"name": ["This domain name conflicts with an existing domain."]}`))
+				assert.NoError(t, err)
+				return
+			}
+			_, err := w.Write([]byte("[]"))
+			assert.NoError(t, err)
+		}))
+		defer server.Close()
+		reconciler := createDesecDomainReconciler(t, server.URL, v1.CreationPolicyIfNotPresent)
+		// When
+		result, err := reconciler.Reconcile(context.TODO(), domainRequest)
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, 5*time.Minute, result.RequeueAfter)
+		condition := meta.FindStatusCondition(getDesecDomain(t, reconciler).Status.Conditions, "Ready")
+		assert.NotNil(t, condition)
+		assert.Equal(t, metav1.ConditionFalse, condition.Status)
+		assert.Equal(t, "Rejected", condition.Reason)
+		assert.Equal(t, "got status 409 while trying to POST /api/v1/domains/: name: This domain name conflicts with an existing domain.", condition.Message)
+	})
+
+	t.Run("Domain created concurrently", func(t *testing.T) {
+		// Given
+		mock := &desecMock{racing: true}
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := createDesecDomainReconciler(t, server.URL, v1.CreationPolicyIfNotPresent)
+		// When
+		_, err := reconciler.Reconcile(context.TODO(), domainRequest)
+		// Then
+		assert.NoError(t, err)
+		desecDomain := getDesecDomain(t, reconciler)
+		assert.True(t, isConditionReason(desecDomain.Status.Conditions, "Ready", "Created"))
+		assert.True(t, desecDomain.Status.CreatedDomain)
+	})
+
+	t.Run("Reporting creation fails", func(t *testing.T) {
+		// Given
+		mock := new(desecMock)
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := createDesecDomainReconciler(t, server.URL, v1.CreationPolicyIfNotPresent)
+		desecDomain := getDesecDomain(t, reconciler)
+		desecDomain.Spec.AdoptionPolicy = v1.AdoptionPolicyCreate
+		assert.NoError(t, reconciler.Update(context.TODO(), desecDomain))
+		fakeClient := reconciler.Client
+		reconciler.Client = interceptor.NewClient(fakeClient.(client.WithWatch), interceptor.Funcs{
+			SubResourceUpdate: func(ctx context.Context, c client.Client, subResource string, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+				if len(mock.domains) > 0 {
+					return errors.New("connection refused")
+				}
+				return c.SubResource(subResource).Update(ctx, obj, opts...)
+			},
+		})
+		// When
+		_, err := reconciler.Reconcile(context.TODO(), domainRequest)
+		// Then
+		assert.Error(t, err)
+		assert.Len(t, mock.domains, 1)
+		assert.True(t, getDesecDomain(t, reconciler).Status.CreatedDomain)
+
+		// When
+		reconciler.Client = fakeClient
+		_, err = reconciler.Reconcile(context.TODO(), domainRequest)
+		// Then
+		assert.NoError(t, err)
+		desecDomain = getDesecDomain(t, reconciler)
+		assert.True(t, isConditionReason(desecDomain.Status.Conditions, "Ready", "Created"))
+		assert.True(t, desecDomain.Status.CreatedDomain)
+	})
+
+	t.Run("Adoption policies", func(t *testing.T) {
+		for _, tc := range []struct {
+			policy v1.AdoptionPolicy
+			exists bool
+			status metav1.ConditionStatus
+			reason string
+		}{
+			{v1.AdoptionPolicyCreate, false, metav1.ConditionTrue, "Created"},
+			{v1.AdoptionPolicyCreate, true, metav1.ConditionFalse, "AlreadyExists"},
+			{v1.AdoptionPolicyAdopt, true, metav1.ConditionTrue, "Created"},
+			{v1.AdoptionPolicyObserve, false, metav1.ConditionFalse, "NotFound"},
+			{v1.AdoptionPolicyObserve, true, metav1.ConditionTrue, "Created"},
+		} {
+			// Given
+			mock := new(desecMock)
+			if tc.exists {
+				// Created after the DesecDomain, yet not by the operator
+				mock.domains = []desec.Domain{{AuditInfo: desec.AuditInfo{Created: "2025-01-01T00:00:00Z"}, Name: "some-domain.dedyn.io", Minimum_TTL: 60}}
+			}
+			server := createDesecServer(t, mock)
+			reconciler := createDesecDomainReconciler(t, server.URL, v1.CreationPolicyIfNotPresent)
+			desecDomain := getDesecDomain(t, reconciler)
+			desecDomain.Spec.AdoptionPolicy = tc.policy
+			desecDomain.CreationTimestamp = metav1.NewTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+			assert.NoError(t, reconciler.Update(context.TODO(), desecDomain))
+			// When
+			result, err := reconciler.Reconcile(context.TODO(), domainRequest)
+			// Then
+			assert.NoError(t, err)
+			assert.Equal(t, 5*time.Minute, result.RequeueAfter)
+			condition := meta.FindStatusCondition(getDesecDomain(t, reconciler).Status.Conditions, "Ready")
+			assert.NotNil(t, condition)
+			assert.Equal(t, tc.status, condition.Status, tc.policy)
+			assert.Equal(t, tc.reason, condition.Reason, tc.policy)
+			assert.Len(t, mock.domains, map[bool]int{true: 1, false: 0}[tc.exists || tc.reason == "Created"])
+			server.Close()
+		}
+	})
+
+	t.Run("Dry-run", func(t *testing.T) {
+		// Given
+		mock := new(desecMock)
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := createDesecDomainReconciler(t, server.URL, v1.CreationPolicyIfNotPresent)
+		reconciler.DryRun = true
+		recorder := events.NewFakeRecorder(10)
+		reconciler.Recorder = recorder
+		// When
+		result, err := reconciler.Reconcile(context.TODO(), domainRequest)
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, 5*time.Minute, result.RequeueAfter)
+		assert.Empty(t, mock.domains)
+		desecDomain := getDesecDomain(t, reconciler)
+		condition := meta.FindStatusCondition(desecDomain.Status.Conditions, "Ready")
+		if assert.NotNil(t, condition) {
+			assert.Equal(t, metav1.ConditionFalse, condition.Status)
+			assert.Equal(t, "DryRun", condition.Reason)
+		}
+		assert.Equal(t, []string{"create the domain"}, desecDomain.Status.PlannedChanges)
+		assert.Equal(t, "Normal DryRun Would create the domain", <-recorder.Events)
+
+		// When dry-run is turned off
+		reconciler.DryRun = false
+		_, err = reconciler.Reconcile(context.TODO(), domainRequest)
+		// Then
+		assert.NoError(t, err)
+		assert.Len(t, mock.domains, 1)
+		assert.Empty(t, getDesecDomain(t, reconciler).Status.PlannedChanges)
+	})
+
+	t.Run("Not found", func(t *testing.T) {
+		// Given
+		reconciler := createDesecDomainReconciler(t, "http://localhost", v1.CreationPolicyIfNotPresent)
+		request := reconcile.Request{NamespacedName: types.NamespacedName{Name: "IDoNotExist", Namespace: domainRequest.Namespace}}
+		// When
+		result, err := reconciler.Reconcile(context.TODO(), request)
+		// Then
+		assert.NoError(t, err)
+		assert.True(t, result.IsZero())
+	})
+}
+
+func createDesecDomainReconciler(t *testing.T, serverUrl string, creationPolicy v1.CreationPolicy) DesecDomainReconciler {
+	disableRateLimits(t)
+	desecDomain := &v1.DesecDomain{
+		ObjectMeta: metav1.ObjectMeta{Name: domainRequest.Name, Namespace: domainRequest.Namespace},
+		Spec:       v1.DesecDomainSpec{CreationPolicy: creationPolicy, TTL: 3600},
+	}
+
+	mockScheme := runtime.NewScheme()
+	assert.NoError(t, v1.AddToScheme(mockScheme))
+
+	fakeClient := fake.NewClientBuilder().
+		WithScheme(mockScheme).
+		WithObjects(desecDomain).
+		WithStatusSubresource(desecDomain).
+		Build()
+
+	return DesecDomainReconciler{
+		Client:    fakeClient,
+		Scheme:    mockScheme,
+		ConfigDir: util.CreateConfigDir(t, serverUrl),
+	}
+}
+
+func getDesecDomain(t *testing.T, reconciler DesecDomainReconciler) *v1.DesecDomain {
+	desecDomain := new(v1.DesecDomain)
+	assert.NoError(t, reconciler.Get(context.TODO(), domainRequest.NamespacedName, desecDomain))
+	return desecDomain
+}
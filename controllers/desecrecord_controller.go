@@ -0,0 +1,402 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/events"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	v1 "github.com/j-be/desec-dns-operator/api/v1"
+	"github.com/j-be/desec-dns-operator/controllers/desec"
+	"github.com/j-be/desec-dns-operator/controllers/util"
+)
+
+const recordFinalizer = "desec.owly.dedyn.io/rrset"
+
+// DesecRecordReconciler reconciles a DesecRecord object
+type DesecRecordReconciler struct {
+	client.Client
+	Scheme    *runtime.Scheme
+	ConfigDir string
+	// Options for the deSEC clients created while reconciling
+	ClientOptions []desec.Option
+	// OwnerID enables the registry, see IngressReconciler
+	OwnerID string
+	// Recorder for events regarding DesecRecords, none are emitted if nil
+	Recorder events.EventRecorder
+	// DryRun only plans the changes to deSEC of all DesecRecords, instead of
+	// applying them
+	DryRun bool
+}
+
+//+kubebuilder:rbac:groups=desec.owly.dedyn.io,resources=desecrecords,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=desec.owly.dedyn.io,resources=desecrecords/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=desec.owly.dedyn.io,resources=desecrecords/finalizers,verbs=update
+
+// Reconcile makes sure the RRSet described by a DesecRecord exists on deSEC
+// as specified, and removes it once the DesecRecord is deleted.
+func (r *DesecRecordReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, err error) {
+	defer func() { result, err = requeueIfThrottled(ctx, result, err) }()
+	log := log.FromContext(ctx)
+
+	log.Info("Starting", "req", req)
+
+	// Fetch CR
+	record := new(v1.DesecRecord)
+	if err := r.Get(ctx, req.NamespacedName, record); err != nil {
+		if apierrors.IsNotFound(err) {
+			log.Info("CR not found, not doing anything", "req", req)
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	// Remove the RRSet before releasing the CR
+	if !record.DeletionTimestamp.IsZero() {
+		return r.finalize(ctx, record)
+	}
+
+	// Create deSEC client
+	desecClient, err := newDesecClient(ctx, r.Client, r.ConfigDir, record.Spec.Account, record.Namespace, record.Spec.Domain, r.ClientOptions...)
+	if isForbidden(err) {
+		// Retrying won't help until the account allows the namespace
+		if util.UpdateCondition(&record.Status.Conditions, "Ready", metav1.ConditionFalse, "Forbidden", err.Error()) {
+			if err := r.Status().Update(ctx, record); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+	}
+	if err != nil {
+		log.Error(err, "Cannot create client")
+		return ctrl.Result{}, err
+	}
+
+	if controllerutil.AddFinalizer(record, recordFinalizer) {
+		err := r.Update(ctx, record)
+		return ctrl.Result{Requeue: true, RequeueAfter: 100 * time.Millisecond}, err
+	}
+
+	// Don't retry a spec deSEC already rejected
+	observed := record.Status.ObservedGeneration == record.Generation
+	if observed && isConditionReason(record.Status.Conditions, "Ready", "Invalid") {
+		return ctrl.Result{}, nil
+	}
+
+	reg, existing, err := r.getRegistry(ctx, desecClient, record)
+	if err != nil {
+		return r.reportError(ctx, record, err)
+	}
+
+	ttl := record.Spec.TTL
+	if ttl == 0 {
+		ttl = 3600
+	}
+	if record.Spec.AdoptionPolicy == v1.AdoptionPolicyObserve {
+		return r.reportDrift(ctx, record, existing, ttl)
+	}
+
+	// Leave the RRSet alone if owned by somebody else, RRSets without owner
+	// are taken over once synced, or if adopting
+	ours := r.isOurs(record, reg)
+	tracked := ours || record.Spec.AdoptionPolicy == v1.AdoptionPolicyAdopt
+	if conflict := reg.conflict(record.Spec.Subname, record.Spec.Type, tracked); conflict != "" {
+		if util.UpdateCondition(&record.Status.Conditions, "Ready", metav1.ConditionFalse, "NotOwned", conflict) {
+			if r.Recorder != nil {
+				r.Recorder.Eventf(record, nil, corev1.EventTypeWarning, "NotOwned", "Publish", "Not setting %s: %s", nameOf(record.Spec.Subname, record.Spec.Type), conflict)
+			}
+			if err := r.Status().Update(ctx, record); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+	}
+
+	// An existing RRSet the operator did not set is adopted, unless the
+	// adoption policy is Create
+	statusUpdate := false
+	if existing != nil && !ours {
+		if record.Spec.AdoptionPolicy == v1.AdoptionPolicyCreate {
+			message := fmt.Sprintf("The %s RRSet existed before and adoptionPolicy is Create", record.Spec.Type)
+			if util.UpdateCondition(&record.Status.Conditions, "Ready", metav1.ConditionFalse, "AlreadyExists", message) {
+				if err := r.Status().Update(ctx, record); err != nil {
+					return ctrl.Result{}, err
+				}
+			}
+			return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+		}
+		if !r.isDryRun(record) {
+			log.Info("Adopting RRSet", "subname", record.Spec.Subname, "type", record.Spec.Type, "domain", record.Spec.Domain)
+			record.Status.Adopted = true
+			statusUpdate = true
+		}
+	}
+	if r.isDryRun(record) {
+		return r.reportPlan(ctx, record, existing, ttl)
+	}
+	// deSEC may normalize the records, so compare to what it returned when
+	// applying the current spec. The owner is recorded in the same request.
+	txt, txtChanged := reg.claim(record.Spec.Subname, record.Spec.Type, "desecrecord/"+record.Namespace+"/"+record.Name, ttl)
+	if existing == nil || !observed || existing.TTL != ttl || !slices.Equal(existing.Records, record.Status.Records) || txtChanged {
+		log.Info("Setting RRSet", "subname", record.Spec.Subname, "type", record.Spec.Type, "domain", record.Spec.Domain)
+		changes := []desec.RRSet{{
+			Subname: record.Spec.Subname,
+			Type:    record.Spec.Type,
+			Records: record.Spec.Records,
+			TTL:     ttl,
+		}}
+		if txtChanged {
+			changes = append(changes, txt)
+		}
+		upserted, err := desecClient.BulkUpsertRRSets(ctx, changes)
+		if err != nil {
+			return r.reportError(ctx, record, err)
+		}
+		if existing == nil {
+			// Remember the RRSet is ours, so adoptionPolicy Create keeps
+			// accepting it
+			record.Status.CreatedRRSet = true
+			statusUpdate = true
+		}
+		if existing = findRRSet(upserted, record.Spec.Subname, record.Spec.Type); existing == nil {
+			return ctrl.Result{}, fmt.Errorf("deSEC did not return the RRSet %s", nameOf(record.Spec.Subname, record.Spec.Type))
+		}
+		record.Status.ObservedGeneration = record.Generation
+		record.Status.Records = existing.Records
+	}
+
+	// Reflect the RRSet in the status
+	statusUpdate = statusUpdate || record.Status.Created != existing.Created || record.Status.Touched != existing.Touched
+	record.Status.Created = existing.Created
+	record.Status.Touched = existing.Touched
+	message := fmt.Sprintf("%s set to: %v", record.Spec.Type, existing.Records)
+	statusUpdate = util.UpdateCondition(&record.Status.Conditions, "Ready", metav1.ConditionTrue, "Synced", message) || statusUpdate
+	statusUpdate = plan(r.Recorder, record, &record.Status.PlannedChanges, isAnyChange, nil) || statusUpdate
+	if statusUpdate {
+		if err := r.Status().Update(ctx, record); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// Check for changes done outside the cluster every now and then
+	return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+}
+
+// finalize removes the RRSet of the DesecRecord, if managed, and releases it.
+// If the client cannot be created, e.g. as the account is gone already, the
+// RRSet is left behind, as retrying would keep the DesecRecord forever.
+func (r *DesecRecordReconciler) finalize(ctx context.Context, record *v1.DesecRecord) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(record, recordFinalizer) {
+		return ctrl.Result{}, nil
+	}
+	// Leave RRSets alone, which were never managed
+	managed := record.Spec.AdoptionPolicy != v1.AdoptionPolicyObserve &&
+		!isConditionReason(record.Status.Conditions, "Ready", "AlreadyExists") &&
+		!isConditionReason(record.Status.Conditions, "Ready", "NotOwned")
+	if managed && r.isDryRun(record) {
+		// Keep the DesecRecord until not in dry-run anymore
+		planned := []string{"remove " + nameOf(record.Spec.Subname, record.Spec.Type)}
+		if plan(r.Recorder, record, &record.Status.PlannedChanges, isAnyChange, planned) {
+			if err := r.Status().Update(ctx, record); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+	}
+	if managed {
+		desecClient, err := newDesecClient(ctx, r.Client, r.ConfigDir, record.Spec.Account, record.Namespace, record.Spec.Domain, r.ClientOptions...)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "Cannot create client, leaving RRSet behind")
+			if r.Recorder != nil {
+				r.Recorder.Eventf(record, nil, corev1.EventTypeWarning, "ClientFailed", "Delete", "Leaving %s behind: %s", nameOf(record.Spec.Subname, record.Spec.Type), err)
+			}
+		} else if err := r.removeRRSet(ctx, desecClient, record); err != nil && !desec.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+	}
+	controllerutil.RemoveFinalizer(record, recordFinalizer)
+	return ctrl.Result{}, r.Update(ctx, record)
+}
+
+// getRegistry returns the RRSet of the DesecRecord, if it exists, along with
+// the registry telling its owner.
+func (r *DesecRecordReconciler) getRegistry(ctx context.Context, desecClient desec.Client, record *v1.DesecRecord) (registry, *desec.RRSet, error) {
+	reg := registry{ownerID: r.OwnerID}
+	existing, err := desecClient.GetRRSet(ctx, record.Spec.Subname, record.Spec.Type)
+	if err != nil {
+		return registry{}, nil, err
+	}
+	if existing != nil {
+		reg.rrsets = append(reg.rrsets, *existing)
+	}
+	if r.OwnerID != "" {
+		txt, err := desecClient.GetRRSet(ctx, registrySubname(record.Spec.Subname, record.Spec.Type), "TXT")
+		if err != nil {
+			return registry{}, nil, err
+		}
+		if txt != nil {
+			reg.rrsets = append(reg.rrsets, *txt)
+		}
+	}
+	return reg, existing, nil
+}
+
+// isOurs reports whether the operator set the RRSet before, recorded either in
+// the status or, should its update have been lost, in the registry.
+func (r *DesecRecordReconciler) isOurs(record *v1.DesecRecord, reg registry) bool {
+	if record.Status.CreatedRRSet || record.Status.Adopted {
+		return true
+	}
+	o, ok := reg.ownerOf(record.Spec.Subname, record.Spec.Type)
+	return ok && r.OwnerID != "" && o.id == r.OwnerID
+}
+
+// removeRRSet removes the RRSet of the DesecRecord, along with its owner,
+// unless it is owned by somebody else.
+func (r *DesecRecordReconciler) removeRRSet(ctx context.Context, desecClient desec.Client, record *v1.DesecRecord) error {
+	log := log.FromContext(ctx).WithValues("subname", record.Spec.Subname, "type", record.Spec.Type, "domain", record.Spec.Domain)
+	if r.OwnerID == "" {
+		log.Info("Removing RRSet")
+		return desecClient.DeleteRRSet(ctx, record.Spec.Subname, record.Spec.Type)
+	}
+	reg, existing, err := r.getRegistry(ctx, desecClient, record)
+	if err != nil {
+		return err
+	}
+	tracked := r.isOurs(record, reg) || record.Spec.AdoptionPolicy == v1.AdoptionPolicyAdopt
+	if existing != nil && !reg.owns(record.Spec.Subname, record.Spec.Type, tracked) {
+		log.Info("Leaving RRSet of somebody else", "conflict", reg.conflict(record.Spec.Subname, record.Spec.Type, tracked))
+		return nil
+	}
+	changes := []desec.RRSet{{Subname: record.Spec.Subname, Type: record.Spec.Type}}
+	if txt, ok := reg.release(record.Spec.Subname, record.Spec.Type); ok {
+		changes = append(changes, txt)
+	}
+	log.Info("Removing RRSet")
+	return desecClient.BulkDeleteRRSets(ctx, changes)
+}
+
+// reportDrift compares the RRSet to the spec, instead of setting it.
+func (r *DesecRecordReconciler) reportDrift(ctx context.Context, record *v1.DesecRecord, existing *desec.RRSet, ttl int64) (ctrl.Result, error) {
+	records := slices.Sorted(slices.Values(record.Spec.Records))
+	statusUpdate := false
+	switch {
+	case existing == nil:
+		message := fmt.Sprintf("Would create %s with TTL %d: %v", record.Spec.Type, ttl, records)
+		statusUpdate = util.UpdateCondition(&record.Status.Conditions, "Ready", metav1.ConditionFalse, "Drift", message)
+	case existing.TTL != ttl || !slices.Equal(slices.Sorted(slices.Values(existing.Records)), records):
+		message := fmt.Sprintf("Would set %s with TTL %d: %v", record.Spec.Type, ttl, records)
+		statusUpdate = util.UpdateCondition(&record.Status.Conditions, "Ready", metav1.ConditionFalse, "Drift", message)
+	default:
+		message := fmt.Sprintf("%s is set to: %v", record.Spec.Type, existing.Records)
+		statusUpdate = util.UpdateCondition(&record.Status.Conditions, "Ready", metav1.ConditionTrue, "InSync", message)
+	}
+	if existing != nil {
+		statusUpdate = statusUpdate || record.Status.Created != existing.Created || record.Status.Touched != existing.Touched
+		record.Status.Created = existing.Created
+		record.Status.Touched = existing.Touched
+	}
+	if statusUpdate {
+		if err := r.Status().Update(ctx, record); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+}
+
+// reportPlan publishes setting the RRSet as planned, if it differs from the
+// spec, instead of setting it.
+func (r *DesecRecordReconciler) reportPlan(ctx context.Context, record *v1.DesecRecord, existing *desec.RRSet, ttl int64) (ctrl.Result, error) {
+	planned := []string{}
+	if existing == nil || existing.TTL != ttl || !slices.Equal(slices.Sorted(slices.Values(existing.Records)), slices.Sorted(slices.Values(record.Spec.Records))) {
+		planned = append(planned, describeChange(desec.RRSet{Subname: record.Spec.Subname, Type: record.Spec.Type, Records: record.Spec.Records, TTL: ttl}))
+	}
+	if plan(r.Recorder, record, &record.Status.PlannedChanges, isAnyChange, planned) {
+		if err := r.Status().Update(ctx, record); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+}
+
+// isDryRun reports whether the changes to deSEC are only planned.
+func (r *DesecRecordReconciler) isDryRun(record *v1.DesecRecord) bool {
+	return r.DryRun || isAnnotatedDryRun(record)
+}
+
+// reportError reports errors of deSEC in the Ready condition. Errors which
+// won't go away by retrying right away are not returned.
+func (r *DesecRecordReconciler) reportError(ctx context.Context, record *v1.DesecRecord, err error) (ctrl.Result, error) {
+	if desec.IsThrottled(err) {
+		// Handled by requeueIfThrottled
+		return ctrl.Result{}, err
+	}
+
+	message := err.Error()
+	result := ctrl.Result{RequeueAfter: 5 * time.Minute}
+	reason := "Error"
+	switch {
+	case desec.IsInvalid(err):
+		// Retrying won't help until the spec is fixed
+		reason = "Invalid"
+		result = ctrl.Result{}
+		record.Status.ObservedGeneration = record.Generation
+		record.Status.Records = nil
+		err = nil
+	case desec.IsNotFound(err):
+		// Retrying right away won't help, the domain has to be created first
+		reason = "DomainNotFound"
+		err = nil
+	case desec.IsUnauthorized(err):
+		// Retrying right away won't help, the token has to be fixed first
+		reason = "Unauthorized"
+		err = nil
+	}
+
+	util.UpdateCondition(&record.Status.Conditions, "Ready", metav1.ConditionFalse, reason, message)
+	if err := r.Status().Update(ctx, record); err != nil {
+		return ctrl.Result{}, err
+	}
+	return result, err
+}
+
+// isConditionReason returns true if the condition is set with the reason.
+func isConditionReason(conditions []metav1.Condition, conditionType string, reason string) bool {
+	condition := meta.FindStatusCondition(conditions, conditionType)
+	return condition != nil && condition.Reason == reason
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *DesecRecordReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.ConfigDir = "./mnt"
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1.DesecRecord{}).
+		Complete(r)
+}
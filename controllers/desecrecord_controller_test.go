@@ -0,0 +1,447 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/events"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	v1 "github.com/j-be/desec-dns-operator/api/v1"
+	"github.com/j-be/desec-dns-operator/controllers/desec"
+	"github.com/j-be/desec-dns-operator/controllers/util"
+)
+
+var recordRequest = reconcile.Request{NamespacedName: types.NamespacedName{Name: "some-record", Namespace: "some-namespace"}}
+
+func TestDesecRecordReconciler(t *testing.T) {
+	t.Run("Basic", func(t *testing.T) {
+		// Given
+		mock := new(desecMock)
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := createDesecRecordReconciler(t, server.URL)
+		// Add finalizer
+		{
+			result, err := reconciler.Reconcile(context.TODO(), recordRequest)
+			assert.NoError(t, err)
+			assert.Equal(t, 100*time.Millisecond, result.RequeueAfter)
+			record := getRecord(t, reconciler)
+			assert.Equal(t, []string{recordFinalizer}, record.Finalizers)
+			assert.Empty(t, mock.rrsets)
+		}
+		// Create RRSet
+		{
+			result, err := reconciler.Reconcile(context.TODO(), recordRequest)
+			assert.NoError(t, err)
+			assert.Equal(t, 5*time.Minute, result.RequeueAfter)
+			assert.Len(t, mock.rrsets, 1)
+			assert.Equal(t, "", mock.rrsets[0].Subname)
+			assert.Equal(t, "MX", mock.rrsets[0].Type)
+			assert.Equal(t, int64(3600), mock.rrsets[0].TTL)
+			assert.Equal(t, []string{"10 mail.some-domain.dedyn.io."}, mock.rrsets[0].Records)
+			record := getRecord(t, reconciler)
+			assert.Equal(t, "2023-01-01T00:00:00Z", record.Status.Created)
+			assert.Equal(t, "2023-01-01T00:00:01Z", record.Status.Touched)
+			condition := meta.FindStatusCondition(record.Status.Conditions, "Ready")
+			assert.NotNil(t, condition)
+			assert.Equal(t, metav1.ConditionTrue, condition.Status)
+			assert.Equal(t, "Synced", condition.Reason)
+			assert.Equal(t, "MX set to: [10 mail.some-domain.dedyn.io.]", condition.Message)
+		}
+		// Do nothing
+		{
+			result, err := reconciler.Reconcile(context.TODO(), recordRequest)
+			assert.NoError(t, err)
+			assert.Equal(t, 5*time.Minute, result.RequeueAfter)
+			assert.Equal(t, 1, mock.bulkRequests)
+		}
+		// Revert changes done outside the cluster
+		{
+			mock.rrsets[0].Records = []string{"20 other.example.com."}
+			_, err := reconciler.Reconcile(context.TODO(), recordRequest)
+			assert.NoError(t, err)
+			assert.Equal(t, 2, mock.bulkRequests)
+			assert.Equal(t, []string{"10 mail.some-domain.dedyn.io."}, mock.rrsets[0].Records)
+			record := getRecord(t, reconciler)
+			assert.Equal(t, "2023-01-01T00:00:00Z", record.Status.Created)
+			assert.Equal(t, "2023-01-01T00:00:02Z", record.Status.Touched)
+		}
+		// Apply changes of the spec
+		{
+			record := getRecord(t, reconciler)
+			record.Spec.Records = []string{"10 mail.some-domain.dedyn.io.", "20 backup.some-domain.dedyn.io."}
+			record.Generation = record.Generation + 1
+			assert.NoError(t, reconciler.Update(context.TODO(), record))
+			_, err := reconciler.Reconcile(context.TODO(), recordRequest)
+			assert.NoError(t, err)
+			assert.Equal(t, 3, mock.bulkRequests)
+			assert.Equal(t, []string{"10 mail.some-domain.dedyn.io.", "20 backup.some-domain.dedyn.io."}, mock.rrsets[0].Records)
+		}
+	})
+
+	t.Run("Cleanup", func(t *testing.T) {
+		// Given
+		mock := &desecMock{rrsets: []desec.RRSet{{Domain: "some-domain.dedyn.io", Subname: "www", Type: "CNAME", Records: []string{"some-domain.dedyn.io."}}}}
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := createDesecRecordReconciler(t, server.URL)
+		for i := 0; i < 2; i = i + 1 {
+			_, err := reconciler.Reconcile(context.TODO(), recordRequest)
+			assert.NoError(t, err)
+		}
+		assert.Len(t, mock.rrsets, 2)
+		// When
+		assert.NoError(t, reconciler.Delete(context.TODO(), getRecord(t, reconciler)))
+		result, err := reconciler.Reconcile(context.TODO(), recordRequest)
+		// Then
+		assert.NoError(t, err)
+		assert.True(t, result.IsZero())
+		assert.Len(t, mock.rrsets, 1)
+		assert.Equal(t, "www", mock.rrsets[0].Subname)
+		assert.EqualError(t, reconciler.Get(context.TODO(), recordRequest.NamespacedName, new(v1.DesecRecord)), `desecrecords.desec.owly.dedyn.io "some-record" not found`)
+	})
+
+	t.Run("Registry", func(t *testing.T) {
+		// Given
+		mock := new(desecMock)
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := createDesecRecordReconciler(t, server.URL)
+		reconciler.OwnerID = "my-cluster"
+		// When
+		for i := 0; i < 2; i = i + 1 {
+			_, err := reconciler.Reconcile(context.TODO(), recordRequest)
+			assert.NoError(t, err)
+		}
+		// Then the owner is recorded in the same request
+		assert.Equal(t, 1, mock.bulkRequests)
+		txt := findRRSet(mock.rrsets, "_desec-owner.mx", "TXT")
+		if assert.NotNil(t, txt) {
+			assert.Equal(t, []string{`"heritage=desec-dns-operator,owner=my-cluster,resource=desecrecord/some-namespace/some-record,version=1"`}, txt.Records)
+		}
+		assert.True(t, getRecord(t, reconciler).Status.CreatedRRSet)
+
+		// When the status update got lost, the registry still tells the RRSet is ours
+		record := getRecord(t, reconciler)
+		record.Status.CreatedRRSet = false
+		assert.NoError(t, reconciler.Status().Update(context.TODO(), record))
+		record.Spec.AdoptionPolicy = v1.AdoptionPolicyCreate
+		assert.NoError(t, reconciler.Update(context.TODO(), record))
+		_, err := reconciler.Reconcile(context.TODO(), recordRequest)
+		// Then
+		assert.NoError(t, err)
+		assert.True(t, isConditionReason(getRecord(t, reconciler).Status.Conditions, "Ready", "Synced"))
+
+		// When
+		assert.NoError(t, reconciler.Delete(context.TODO(), getRecord(t, reconciler)))
+		_, err = reconciler.Reconcile(context.TODO(), recordRequest)
+		// Then
+		assert.NoError(t, err)
+		assert.Empty(t, mock.rrsets)
+	})
+
+	t.Run("RRSet owned by somebody else", func(t *testing.T) {
+		// Given
+		mock := &desecMock{rrsets: []desec.RRSet{
+			{Domain: "some-domain.dedyn.io", Subname: "", Type: "MX", Records: []string{"20 other.example.com."}, TTL: 3600},
+			{Domain: "some-domain.dedyn.io", Subname: "_desec-owner.mx", Type: "TXT", Records: []string{`"heritage=desec-dns-operator,owner=other,resource=desecrecord/default/mx,version=1"`}, TTL: 3600},
+		}}
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := createDesecRecordReconciler(t, server.URL)
+		reconciler.OwnerID = "my-cluster"
+		recorder := events.NewFakeRecorder(10)
+		reconciler.Recorder = recorder
+		// When
+		for i := 0; i < 2; i = i + 1 {
+			_, err := reconciler.Reconcile(context.TODO(), recordRequest)
+			assert.NoError(t, err)
+		}
+		// Then
+		assert.Equal(t, 0, mock.bulkRequests)
+		assert.Equal(t, []string{"20 other.example.com."}, findRRSet(mock.rrsets, "", "MX").Records)
+		condition := meta.FindStatusCondition(getRecord(t, reconciler).Status.Conditions, "Ready")
+		assert.NotNil(t, condition)
+		assert.Equal(t, metav1.ConditionFalse, condition.Status)
+		assert.Equal(t, "NotOwned", condition.Reason)
+		assert.Equal(t, "MX owned by other (desecrecord/default/mx)", condition.Message)
+		assert.Equal(t, "Warning NotOwned Not setting @/MX: MX owned by other (desecrecord/default/mx)", <-recorder.Events)
+
+		// When
+		assert.NoError(t, reconciler.Delete(context.TODO(), getRecord(t, reconciler)))
+		_, err := reconciler.Reconcile(context.TODO(), recordRequest)
+		// Then
+		assert.NoError(t, err)
+		assert.Len(t, mock.rrsets, 2)
+		assert.Equal(t, 0, mock.bulkRequests)
+	})
+
+	t.Run("RRSet without owner", func(t *testing.T) {
+		// Reconciled up to adding the finalizer, and up to reporting NotOwned
+		for _, reconciles := range []int{1, 2} {
+			// Given
+			mock := &desecMock{rrsets: []desec.RRSet{
+				{Domain: "some-domain.dedyn.io", Subname: "", Type: "MX", Records: []string{"20 other.example.com."}, TTL: 3600},
+			}}
+			server := createDesecServer(t, mock)
+			reconciler := createDesecRecordReconciler(t, server.URL)
+			reconciler.OwnerID = "my-cluster"
+			for i := 0; i < reconciles; i = i + 1 {
+				_, err := reconciler.Reconcile(context.TODO(), recordRequest)
+				assert.NoError(t, err)
+			}
+			if reconciles == 2 {
+				assert.True(t, isConditionReason(getRecord(t, reconciler).Status.Conditions, "Ready", "NotOwned"))
+			}
+			// When
+			assert.NoError(t, reconciler.Delete(context.TODO(), getRecord(t, reconciler)))
+			_, err := reconciler.Reconcile(context.TODO(), recordRequest)
+			// Then
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"20 other.example.com."}, findRRSet(mock.rrsets, "", "MX").Records, reconciles)
+			assert.Equal(t, 0, mock.bulkRequests, reconciles)
+			assert.True(t, apierrors.IsNotFound(reconciler.Get(context.TODO(), recordRequest.NamespacedName, new(v1.DesecRecord))))
+			server.Close()
+		}
+	})
+
+	t.Run("Account not allowing the namespace", func(t *testing.T) {
+		// Given
+		mock := new(desecMock)
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := createDesecRecordReconciler(t, server.URL)
+		recorder := events.NewFakeRecorder(10)
+		reconciler.Recorder = recorder
+		createAccount(t, reconciler.Client, server.URL, "account token")
+		record := getRecord(t, reconciler)
+		record.Spec.Account = accountRequest.Name
+		assert.NoError(t, reconciler.Update(context.TODO(), record))
+		// When
+		result, err := reconciler.Reconcile(context.TODO(), recordRequest)
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, 5*time.Minute, result.RequeueAfter)
+		assert.Empty(t, mock.rrsets)
+		condition := meta.FindStatusCondition(getRecord(t, reconciler).Status.Conditions, "Ready")
+		if assert.NotNil(t, condition) {
+			assert.Equal(t, metav1.ConditionFalse, condition.Status)
+			assert.Equal(t, "Forbidden", condition.Reason)
+			assert.Equal(t, "DesecAccount some-account does not list namespace some-namespace in namespaces", condition.Message)
+		}
+
+		// When it does
+		account := getAccountOf(t, reconciler.Client)
+		account.Spec.Namespaces = []string{"some-namespace"}
+		assert.NoError(t, reconciler.Update(context.TODO(), account))
+		for i := 0; i < 2; i = i + 1 {
+			_, err = reconciler.Reconcile(context.TODO(), recordRequest)
+			assert.NoError(t, err)
+		}
+		// Then
+		assert.NotNil(t, findRRSet(mock.rrsets, "", "MX"))
+		assert.True(t, isConditionReason(getRecord(t, reconciler).Status.Conditions, "Ready", "Synced"))
+
+		// When the account is deleted before the record
+		assert.NoError(t, reconciler.Delete(context.TODO(), getAccountOf(t, reconciler.Client)))
+		assert.NoError(t, reconciler.Delete(context.TODO(), getRecord(t, reconciler)))
+		_, err = reconciler.Reconcile(context.TODO(), recordRequest)
+		// Then the RRSet is left behind, but the record is released
+		assert.NoError(t, err)
+		assert.NotNil(t, findRRSet(mock.rrsets, "", "MX"))
+		assert.True(t, apierrors.IsNotFound(reconciler.Get(context.TODO(), recordRequest.NamespacedName, new(v1.DesecRecord))))
+		assert.Contains(t, <-recorder.Events, "Warning ClientFailed Leaving @/MX behind: ")
+	})
+
+	t.Run("Invalid records", func(t *testing.T) {
+		// Given
+		mock := &desecMock{invalid: map[string]desec.FieldErrors{"": {"records": []any{[]any{"Invalid MX record."}}}}}
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := createDesecRecordReconciler(t, server.URL)
+		for i := 0; i < 2; i = i + 1 {
+			_, err := reconciler.Reconcile(context.TODO(), recordRequest)
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, 1, mock.bulkRequests)
+		// When
+		result, err := reconciler.Reconcile(context.TODO(), recordRequest)
+		// Then
+		assert.NoError(t, err)
+		assert.True(t, result.IsZero())
+		assert.Equal(t, 1, mock.bulkRequests)
+		condition := meta.FindStatusCondition(getRecord(t, reconciler).Status.Conditions, "Ready")
+		assert.NotNil(t, condition)
+		assert.Equal(t, metav1.ConditionFalse, condition.Status)
+		assert.Equal(t, "Invalid", condition.Reason)
+		assert.Equal(t, "got status 400 while trying to PATCH /api/v1/domains/some-domain.dedyn.io/rrsets/: #0: records: Invalid MX record.", condition.Message)
+	})
+
+	t.Run("Unknown domain", func(t *testing.T) {
+		// Given
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(404)
+			_, err := w.Write([]byte(`{"detail": "Not found."}`))
+			assert.NoError(t, err)
+		}))
+		defer server.Close()
+		reconciler := createDesecRecordReconciler(t, server.URL)
+		_, err := reconciler.Reconcile(context.TODO(), recordRequest)
+		assert.NoError(t, err)
+		// When
+		result, err := reconciler.Reconcile(context.TODO(), recordRequest)
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, 5*time.Minute, result.RequeueAfter)
+		condition := meta.FindStatusCondition(getRecord(t, reconciler).Status.Conditions, "Ready")
+		assert.NotNil(t, condition)
+		assert.Equal(t, metav1.ConditionFalse, condition.Status)
+		assert.Equal(t, "DomainNotFound", condition.Reason)
+	})
+
+	t.Run("Adoption policies", func(t *testing.T) {
+		for _, tc := range []struct {
+			policy  v1.AdoptionPolicy
+			records []string
+			status  metav1.ConditionStatus
+			reason  string
+			adopted bool
+		}{
+			{v1.AdoptionPolicyCreate, []string{"20 other.example.com."}, metav1.ConditionFalse, "AlreadyExists", false},
+			{v1.AdoptionPolicyAdopt, []string{"20 other.example.com."}, metav1.ConditionTrue, "Synced", true},
+			{v1.AdoptionPolicyObserve, []string{"20 other.example.com."}, metav1.ConditionFalse, "Drift", false},
+			{v1.AdoptionPolicyObserve, []string{"10 mail.some-domain.dedyn.io."}, metav1.ConditionTrue, "InSync", false},
+		} {
+			// Given, created after the CR, yet not by the operator
+			existing := desec.RRSet{AuditInfo: desec.AuditInfo{Created: "2025-01-01T00:00:00Z"}, Domain: "some-domain.dedyn.io", Type: "MX", TTL: 3600, Records: tc.records}
+			mock := &desecMock{rrsets: []desec.RRSet{existing}}
+			server := createDesecServer(t, mock)
+			reconciler := createDesecRecordReconciler(t, server.URL)
+			record := getRecord(t, reconciler)
+			record.Spec.AdoptionPolicy = tc.policy
+			record.CreationTimestamp = metav1.NewTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+			assert.NoError(t, reconciler.Update(context.TODO(), record))
+			// When
+			for i := 0; i < 2; i = i + 1 {
+				result, err := reconciler.Reconcile(context.TODO(), recordRequest)
+				assert.NoError(t, err)
+				assert.NotZero(t, result.RequeueAfter)
+			}
+			// Then
+			record = getRecord(t, reconciler)
+			condition := meta.FindStatusCondition(record.Status.Conditions, "Ready")
+			assert.NotNil(t, condition)
+			assert.Equal(t, tc.status, condition.Status, tc.policy)
+			assert.Equal(t, tc.reason, condition.Reason, tc.policy)
+			assert.Equal(t, tc.adopted, record.Status.Adopted, tc.policy)
+			assert.Equal(t, map[bool]int{true: 1, false: 0}[tc.adopted], mock.bulkRequests, tc.policy)
+
+			// When deleted, only adopted RRSets are removed
+			assert.NoError(t, reconciler.Delete(context.TODO(), record))
+			_, err := reconciler.Reconcile(context.TODO(), recordRequest)
+			// Then
+			assert.NoError(t, err)
+			assert.Equal(t, map[bool]int{true: 0, false: 1}[tc.adopted], len(mock.rrsets), tc.policy)
+			server.Close()
+		}
+	})
+
+	t.Run("Dry-run", func(t *testing.T) {
+		// Given
+		mock := new(desecMock)
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := createDesecRecordReconciler(t, server.URL)
+		recorder := events.NewFakeRecorder(10)
+		reconciler.Recorder = recorder
+		record := getRecord(t, reconciler)
+		record.Annotations = map[string]string{dryRunAnnotation: "true"}
+		assert.NoError(t, reconciler.Update(context.TODO(), record))
+		// When
+		reconcileIngress(t, &reconciler, recordRequest)
+		// Then
+		assert.Empty(t, mock.rrsets)
+		record = getRecord(t, reconciler)
+		assert.Equal(t, []string{"set @/MX to [10 mail.some-domain.dedyn.io.]"}, record.Status.PlannedChanges)
+		assert.Equal(t, "Normal DryRun Would set @/MX to [10 mail.some-domain.dedyn.io.]", <-recorder.Events)
+
+		// When dry-run is turned off
+		delete(record.Annotations, dryRunAnnotation)
+		assert.NoError(t, reconciler.Update(context.TODO(), record))
+		reconcileIngress(t, &reconciler, recordRequest)
+		// Then
+		assert.Len(t, mock.rrsets, 1)
+		record = getRecord(t, reconciler)
+		assert.Empty(t, record.Status.PlannedChanges)
+
+		// When deleted in dry-run
+		record.Annotations = map[string]string{dryRunAnnotation: "true"}
+		assert.NoError(t, reconciler.Update(context.TODO(), record))
+		assert.NoError(t, reconciler.Delete(context.TODO(), record))
+		result, err := reconciler.Reconcile(context.TODO(), recordRequest)
+		// Then the RRSet is kept, and so is the finalizer
+		assert.NoError(t, err)
+		assert.Equal(t, 5*time.Minute, result.RequeueAfter)
+		assert.Len(t, mock.rrsets, 1)
+		record = getRecord(t, reconciler)
+		assert.Equal(t, []string{recordFinalizer}, record.Finalizers)
+		assert.Equal(t, []string{"remove @/MX"}, record.Status.PlannedChanges)
+	})
+
+	t.Run("Not found", func(t *testing.T) {
+		// Given
+		reconciler := createDesecRecordReconciler(t, "http://localhost")
+		request := reconcile.Request{NamespacedName: types.NamespacedName{Name: "IDoNotExist", Namespace: recordRequest.Namespace}}
+		// When
+		result, err := reconciler.Reconcile(context.TODO(), request)
+		// Then
+		assert.NoError(t, err)
+		assert.True(t, result.IsZero())
+	})
+}
+
+func createDesecRecordReconciler(t *testing.T, serverUrl string) DesecRecordReconciler {
+	disableRateLimits(t)
+	record := &v1.DesecRecord{
+		ObjectMeta: metav1.ObjectMeta{Name: recordRequest.Name, Namespace: recordRequest.Namespace, Generation: 1},
+		Spec: v1.DesecRecordSpec{
+			Domain:  "some-domain.dedyn.io",
+			Type:    "MX",
+			Records: []string{"10 mail.some-domain.dedyn.io."},
+		},
+	}
+
+	mockScheme := runtime.NewScheme()
+	assert.NoError(t, v1.AddToScheme(mockScheme))
+	assert.NoError(t, corev1.AddToScheme(mockScheme))
+
+	fakeClient := fake.NewClientBuilder().
+		WithScheme(mockScheme).
+		WithObjects(record).
+		WithStatusSubresource(record).
+		Build()
+
+	return DesecRecordReconciler{
+		Client:    fakeClient,
+		Scheme:    mockScheme,
+		ConfigDir: util.CreateConfigDir(t, serverUrl),
+	}
+}
+
+func getRecord(t *testing.T, reconciler DesecRecordReconciler) *v1.DesecRecord {
+	record := new(v1.DesecRecord)
+	assert.NoError(t, reconciler.Get(context.TODO(), recordRequest.NamespacedName, record))
+	return record
+}
@@ -0,0 +1,483 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/events"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	v1 "github.com/j-be/desec-dns-operator/api/v1"
+	"github.com/j-be/desec-dns-operator/controllers/config"
+	"github.com/j-be/desec-dns-operator/controllers/desec"
+	"github.com/j-be/desec-dns-operator/controllers/util"
+)
+
+const tokenFinalizer = "desec.owly.dedyn.io/token"
+
+// tokenIDAnnotation holds the ID of the token stored in a Secret, to find it
+// even if storing the ID in the status of the DesecToken failed.
+const tokenIDAnnotation = "desec.owly.dedyn.io/token-id"
+
+// DesecTokenReconciler reconciles a DesecToken object
+type DesecTokenReconciler struct {
+	client.Client
+	Scheme    *runtime.Scheme
+	ConfigDir string
+	// Options for the deSEC clients created while reconciling
+	ClientOptions []desec.Option
+	// APIReader reads the Secrets of DesecTokens, as only the labeled ones
+	// are cached, defaults to the client
+	APIReader client.Reader
+	// Recorder for events regarding DesecTokens, none are emitted if nil
+	Recorder events.EventRecorder
+	// DryRun only plans creating, rotating and deleting the tokens of all
+	// DesecTokens, instead of doing so
+	DryRun bool
+}
+
+//+kubebuilder:rbac:groups=desec.owly.dedyn.io,resources=desectokens,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=desec.owly.dedyn.io,resources=desectokens/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=desec.owly.dedyn.io,resources=desectokens/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch
+
+// Reconcile makes sure the Secret of a DesecToken holds a valid token as
+// specified, replaces it before it expires, and deletes it on deSEC once the
+// DesecToken is deleted.
+func (r *DesecTokenReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, err error) {
+	defer func() { result, err = requeueIfThrottled(ctx, result, err) }()
+	log := log.FromContext(ctx)
+
+	log.Info("Starting", "req", req)
+
+	// Fetch CR
+	token := new(v1.DesecToken)
+	if err := r.Get(ctx, req.NamespacedName, token); err != nil {
+		if apierrors.IsNotFound(err) {
+			log.Info("CR not found, not doing anything", "req", req)
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	// Fetch the Secret, which may hold a token not recorded in the status yet,
+	// from the API, as Secrets of others are not cached
+	reader := r.APIReader
+	if reader == nil {
+		reader = r.Client
+	}
+	secret := new(corev1.Secret)
+	if err := reader.Get(ctx, types.NamespacedName{Name: token.Spec.SecretName, Namespace: token.Namespace}, secret); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		secret = nil
+	}
+	owned := secret != nil && metav1.IsControlledBy(secret, token)
+	storedID := ""
+	if owned {
+		storedID = secret.Annotations[tokenIDAnnotation]
+	}
+
+	// Delete the token before releasing the CR, the Secret is garbage collected
+	if !token.DeletionTimestamp.IsZero() {
+		return r.finalize(ctx, token, storedID)
+	}
+
+	// Create deSEC client
+	desecClient, err := newDesecClient(ctx, r.Client, r.ConfigDir, token.Spec.Account, token.Namespace, "", r.ClientOptions...)
+	if isForbidden(err) {
+		// Retrying won't help until the account allows the namespace
+		if util.UpdateCondition(&token.Status.Conditions, "Ready", metav1.ConditionFalse, "Forbidden", err.Error()) {
+			if err := r.Status().Update(ctx, token); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+	}
+	if err != nil {
+		log.Error(err, "Cannot create client")
+		return ctrl.Result{}, err
+	}
+
+	if controllerutil.AddFinalizer(token, tokenFinalizer) {
+		err := r.Update(ctx, token)
+		return ctrl.Result{Requeue: true, RequeueAfter: 100 * time.Millisecond}, err
+	}
+
+	// Don't retry a spec deSEC already rejected
+	if token.Status.ObservedGeneration == token.Generation && isConditionReason(token.Status.Conditions, "Ready", "Invalid") {
+		return ctrl.Result{}, nil
+	}
+
+	// A token with privileges beyond writing RRSets of given domains is about
+	// as powerful as the one of the account, so only namespaces allowed by the
+	// account may create one
+	message, err := r.getForbiddenReason(ctx, token)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if message != "" {
+		if util.UpdateCondition(&token.Status.Conditions, "Ready", metav1.ConditionFalse, "Forbidden", message) {
+			if err := r.Status().Update(ctx, token); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+	}
+
+	// Never overwrite the Secret of somebody else, retrying won't help until
+	// it is gone
+	if secret != nil && !owned {
+		message := fmt.Sprintf("Secret %s exists and is not owned by the DesecToken", token.Spec.SecretName)
+		if util.UpdateCondition(&token.Status.Conditions, "Ready", metav1.ConditionFalse, "SecretConflict", message) {
+			return ctrl.Result{}, r.Status().Update(ctx, token)
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// The status of a token stored before failed to update, use it. This
+	// deletes the previous token, so it waits until not in dry-run anymore.
+	if storedID != "" && storedID != token.Status.TokenID && !r.isDryRun(token) {
+		log.Info("Recovering stored token", "id", storedID)
+		return r.updateStatus(ctx, desecClient, token, storedID, "Recovered")
+	}
+
+	reason, err := r.getRotationReason(ctx, desecClient, token, secret)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if reason == "" {
+		if plan(r.Recorder, token, &token.Status.PlannedChanges, isAnyChange, nil) {
+			if err := r.Status().Update(ctx, token); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{RequeueAfter: nextCheck(token)}, nil
+	}
+	if r.isDryRun(token) {
+		if token.Status.TokenID == "" {
+			statusUpdate := util.UpdateCondition(&token.Status.Conditions, "Ready", metav1.ConditionFalse, "DryRun", "No token created yet")
+			return r.planChanges(ctx, token, statusUpdate, []string{"create token"})
+		}
+		return r.planChanges(ctx, token, false, []string{"rotate token"})
+	}
+
+	log.Info("Creating token", "reason", reason)
+	created, err := r.createToken(ctx, desecClient, token)
+	if desec.IsInvalid(err) {
+		// Retrying won't help until the spec is fixed
+		token.Status.ObservedGeneration = token.Generation
+		util.UpdateCondition(&token.Status.Conditions, "Ready", metav1.ConditionFalse, "Invalid", err.Error())
+		return ctrl.Result{}, r.Status().Update(ctx, token)
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Store the token along with its ID, labeled to be cached
+	if secret == nil {
+		secret = &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: token.Spec.SecretName, Namespace: token.Namespace}}
+	}
+	if err := r.storeToken(ctx, token, secret, created); err != nil {
+		// Don't leave the unused token behind
+		if err := r.deleteToken(ctx, desecClient, created.ID); err != nil {
+			log.Error(err, "Failed to delete unused token", "id", created.ID)
+		}
+		return ctrl.Result{}, err
+	}
+
+	return r.updateStatus(ctx, desecClient, token, created.ID, reason)
+}
+
+// finalize deletes the tokens on deSEC and releases the DesecToken. If the
+// client cannot be created, e.g. as the account is gone already, the tokens
+// are left behind, as retrying would keep the DesecToken forever.
+func (r *DesecTokenReconciler) finalize(ctx context.Context, token *v1.DesecToken, storedID string) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(token, tokenFinalizer) {
+		return ctrl.Result{}, nil
+	}
+	if token.Status.TokenID != "" || storedID != "" {
+		if r.isDryRun(token) {
+			return r.planChanges(ctx, token, false, []string{"delete token"})
+		}
+		desecClient, err := newDesecClient(ctx, r.Client, r.ConfigDir, token.Spec.Account, token.Namespace, "", r.ClientOptions...)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "Cannot create client, leaving token behind")
+			if r.Recorder != nil {
+				r.Recorder.Eventf(token, nil, corev1.EventTypeWarning, "ClientFailed", "Delete", "Leaving token behind: %s", err)
+			}
+		} else {
+			for _, id := range []string{token.Status.TokenID, storedID} {
+				if err := r.deleteToken(ctx, desecClient, id); err != nil {
+					return ctrl.Result{}, err
+				}
+			}
+		}
+	}
+	controllerutil.RemoveFinalizer(token, tokenFinalizer)
+	return ctrl.Result{}, r.Update(ctx, token)
+}
+
+// storeToken writes the token along with its ID to the Secret, creating it if
+// it does not exist yet.
+func (r *DesecTokenReconciler) storeToken(ctx context.Context, token *v1.DesecToken, secret *corev1.Secret, created desec.Token) error {
+	if secret.Labels == nil {
+		secret.Labels = map[string]string{}
+	}
+	secret.Labels[TokenSecretLabel] = "true"
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	secret.Annotations[tokenIDAnnotation] = created.ID
+	secret.Data = map[string][]byte{"token": []byte(created.Token)}
+	if err := controllerutil.SetControllerReference(token, secret, r.Scheme); err != nil {
+		return err
+	}
+	if secret.ResourceVersion == "" {
+		return r.Create(ctx, secret)
+	}
+	return r.Update(ctx, secret)
+}
+
+// updateStatus records the token stored in the Secret, and deletes the
+// previous one.
+func (r *DesecTokenReconciler) updateStatus(ctx context.Context, desecClient desec.Client, token *v1.DesecToken, id string, reason string) (ctrl.Result, error) {
+	previousID := token.Status.TokenID
+	now := metav1.Now()
+	token.Status.TokenID = id
+	token.Status.ObservedGeneration = token.Generation
+	token.Status.Created = &now
+	token.Status.RotateAt = nil
+	if token.Spec.MaxAge != nil {
+		rotateAt := metav1.NewTime(now.Add(token.Spec.MaxAge.Duration - rotateBefore(token.Spec)))
+		token.Status.RotateAt = &rotateAt
+	}
+	message := fmt.Sprintf("Token %s stored in Secret %s", id, token.Spec.SecretName)
+	util.UpdateCondition(&token.Status.Conditions, "Ready", metav1.ConditionTrue, reason, message)
+	plan(r.Recorder, token, &token.Status.PlannedChanges, isAnyChange, nil)
+	if err := r.Status().Update(ctx, token); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// The previous token is not needed anymore
+	if previousID != "" {
+		if err := r.deleteToken(ctx, desecClient, previousID); err != nil {
+			log.FromContext(ctx).Error(err, "Failed to delete previous token", "id", previousID)
+		}
+	}
+
+	return ctrl.Result{RequeueAfter: nextCheck(token)}, nil
+}
+
+// planChanges publishes the changes to deSEC as planned, instead of applying
+// them. The DesecToken is kept until not in dry-run anymore.
+func (r *DesecTokenReconciler) planChanges(ctx context.Context, token *v1.DesecToken, statusUpdate bool, planned []string) (ctrl.Result, error) {
+	statusUpdate = plan(r.Recorder, token, &token.Status.PlannedChanges, isAnyChange, planned) || statusUpdate
+	if statusUpdate {
+		if err := r.Status().Update(ctx, token); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+}
+
+func (r *DesecTokenReconciler) isDryRun(token *v1.DesecToken) bool {
+	return r.DryRun || isAnnotatedDryRun(token)
+}
+
+// getForbiddenReason tells why the namespace of the token may not create it,
+// empty if it may. The mounted credentials may only be used by the namespace
+// of the operator, which holds them anyway. Privileged tokens require a
+// DesecAccount listing the namespace in tokenManagerNamespaces.
+func (r *DesecTokenReconciler) getForbiddenReason(ctx context.Context, token *v1.DesecToken) (string, error) {
+	if token.Spec.Account == "" {
+		desecConfig, err := config.NewConfigFor(r.ConfigDir)
+		if err != nil {
+			return "", err
+		}
+		if token.Namespace != desecConfig.Namespace {
+			return fmt.Sprintf("The mounted credentials may only be used in namespace %s, set account", desecConfig.Namespace), nil
+		}
+		return "", nil
+	}
+	privilege := getPrivilege(token.Spec)
+	if privilege == "" {
+		return "", nil
+	}
+	account := new(v1.DesecAccount)
+	if err := r.Get(ctx, types.NamespacedName{Name: token.Spec.Account}, account); err != nil {
+		return "", err
+	}
+	if !slices.Contains(account.Spec.TokenManagerNamespaces, token.Namespace) {
+		return fmt.Sprintf("%s requires a DesecAccount listing namespace %s in tokenManagerNamespaces", privilege, token.Namespace), nil
+	}
+	return "", nil
+}
+
+// getPrivilege returns the first privilege of the token beyond writing RRSets
+// of given domains, empty if it has none.
+func getPrivilege(spec v1.DesecTokenSpec) string {
+	switch {
+	case spec.PermManageTokens:
+		return "permManageTokens"
+	case spec.PermCreateDomain:
+		return "permCreateDomain"
+	case spec.PermDeleteDomain:
+		return "permDeleteDomain"
+	}
+	for _, policy := range spec.Policies {
+		if policy.PermWrite && policy.Domain == nil {
+			return "A policy with permWrite and no domain"
+		}
+	}
+	return ""
+}
+
+// getRotationReason returns why a new token is needed, or an empty string if
+// the current one is fine.
+func (r *DesecTokenReconciler) getRotationReason(ctx context.Context, desecClient desec.Client, token *v1.DesecToken, secret *corev1.Secret) (string, error) {
+	switch {
+	case token.Status.TokenID == "":
+		return "Created", nil
+	case token.Status.ObservedGeneration != token.Generation:
+		return "SpecChanged", nil
+	case token.Status.RotateAt != nil && !time.Now().Before(token.Status.RotateAt.Time):
+		return "Rotated", nil
+	}
+
+	if secret == nil || len(secret.Data["token"]) == 0 {
+		return "SecretMissing", nil
+	}
+
+	current, err := desecClient.GetToken(ctx, token.Status.TokenID)
+	if desec.IsNotFound(err) || (err == nil && !current.IsValid) {
+		return "Invalidated", nil
+	}
+	return "", err
+}
+
+// createToken creates a token as specified, including its policies.
+func (r *DesecTokenReconciler) createToken(ctx context.Context, desecClient desec.Client, token *v1.DesecToken) (desec.Token, error) {
+	created, err := desecClient.CreateToken(ctx, desec.Token{
+		Name:             token.Namespace + "/" + token.Name,
+		PermCreateDomain: token.Spec.PermCreateDomain,
+		PermDeleteDomain: token.Spec.PermDeleteDomain,
+		PermManageTokens: token.Spec.PermManageTokens,
+		AllowedSubnets:   token.Spec.AllowedSubnets,
+		MaxAge:           formatDuration(token.Spec.MaxAge),
+		MaxUnusedPeriod:  formatDuration(token.Spec.MaxUnusedPeriod),
+	})
+	if err != nil {
+		return desec.Token{}, err
+	}
+
+	for _, policy := range getTokenPolicies(token.Spec) {
+		if _, err := desecClient.CreateTokenPolicy(ctx, created.ID, policy); err != nil {
+			// Don't leave a token with incomplete policies behind
+			if err := r.deleteToken(ctx, desecClient, created.ID); err != nil {
+				log.FromContext(ctx).Error(err, "Failed to delete incomplete token", "id", created.ID)
+			}
+			return desec.Token{}, err
+		}
+	}
+	return created, nil
+}
+
+func (r *DesecTokenReconciler) deleteToken(ctx context.Context, desecClient desec.Client, id string) error {
+	if id == "" {
+		return nil
+	}
+	if err := desecClient.DeleteToken(ctx, id); err != nil && !desec.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// getTokenPolicies returns the policies to create, starting with the default
+// policy deSEC requires. Unless specified, the default policy denies writing.
+func getTokenPolicies(spec v1.DesecTokenSpec) []desec.TokenPolicy {
+	policies := []desec.TokenPolicy{}
+	hasDefault := false
+	for _, policy := range spec.Policies {
+		isDefault := policy.Domain == nil && policy.Subname == nil && policy.Type == nil
+		policy := desec.TokenPolicy{Domain: policy.Domain, Subname: policy.Subname, Type: policy.Type, PermWrite: policy.PermWrite}
+		if isDefault {
+			hasDefault = true
+			policies = append([]desec.TokenPolicy{policy}, policies...)
+		} else {
+			policies = append(policies, policy)
+		}
+	}
+	if !hasDefault {
+		policies = append([]desec.TokenPolicy{{}}, policies...)
+	}
+	return policies
+}
+
+// rotateBefore returns how long before it expires the token is replaced.
+func rotateBefore(spec v1.DesecTokenSpec) time.Duration {
+	before := 24 * time.Hour
+	if spec.RotateBefore != nil {
+		before = spec.RotateBefore.Duration
+	}
+	if spec.MaxAge != nil && before > spec.MaxAge.Duration/2 {
+		before = spec.MaxAge.Duration / 2
+	}
+	return before
+}
+
+// nextCheck returns when to check the token again, at least once an hour to
+// notice if it was deleted on deSEC.
+func nextCheck(token *v1.DesecToken) time.Duration {
+	next := time.Hour
+	if token.Status.RotateAt != nil {
+		next = min(next, max(time.Until(token.Status.RotateAt.Time), time.Second))
+	}
+	return next
+}
+
+// formatDuration formats the duration as seconds, as deSEC expects it.
+func formatDuration(duration *metav1.Duration) *string {
+	if duration == nil {
+		return nil
+	}
+	seconds := strconv.FormatInt(int64(duration.Duration/time.Second), 10)
+	return &seconds
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *DesecTokenReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.ConfigDir = "./mnt"
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1.DesecToken{}).
+		Owns(&corev1.Secret{}).
+		Complete(r)
+}
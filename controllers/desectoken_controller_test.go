@@ -0,0 +1,517 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/events"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	v1 "github.com/j-be/desec-dns-operator/api/v1"
+	"github.com/j-be/desec-dns-operator/controllers/desec"
+	"github.com/j-be/desec-dns-operator/controllers/util"
+)
+
+var tokenRequest = reconcile.Request{NamespacedName: types.NamespacedName{Name: "some-token", Namespace: "some-namespace"}}
+
+func TestDesecTokenReconciler(t *testing.T) {
+	t.Run("Basic", func(t *testing.T) {
+		// Given
+		mock := newTokenMock()
+		server := createTokenServer(t, mock)
+		defer server.Close()
+		reconciler := createDesecTokenReconciler(t, server.URL, v1.DesecTokenSpec{
+			SecretName: "some-secret",
+			MaxAge:     &metav1.Duration{Duration: 30 * 24 * time.Hour},
+		})
+		// When
+		result := reconcileToken(t, &reconciler)
+		// Then
+		assert.Len(t, mock.tokens, 1)
+		created := mock.tokens["token-1"]
+		assert.Equal(t, "some-namespace/some-token", created.Name)
+		assert.Equal(t, new("2592000"), created.MaxAge)
+		assert.Nil(t, created.MaxUnusedPeriod)
+		// Writing is denied unless allowed by a policy
+		assert.Equal(t, []desec.TokenPolicy{{ID: "policy-1"}}, mock.policies["token-1"])
+		assert.Equal(t, time.Hour, result.RequeueAfter)
+		assert.Equal(t, "secret-1", getTokenSecret(t, reconciler))
+		token := getDesecToken(t, reconciler)
+		assert.Equal(t, "token-1", token.Status.TokenID)
+		assert.Equal(t, 29*24*time.Hour, token.Status.RotateAt.Sub(token.Status.Created.Time))
+		condition := meta.FindStatusCondition(token.Status.Conditions, "Ready")
+		assert.NotNil(t, condition)
+		assert.Equal(t, metav1.ConditionTrue, condition.Status)
+		assert.Equal(t, "Created", condition.Reason)
+
+		// When
+		reconcileToken(t, &reconciler)
+		// Then
+		assert.Len(t, mock.tokens, 1)
+		assert.Equal(t, "secret-1", getTokenSecret(t, reconciler))
+	})
+
+	t.Run("Rotation", func(t *testing.T) {
+		// Given
+		mock := newTokenMock()
+		server := createTokenServer(t, mock)
+		defer server.Close()
+		reconciler := createDesecTokenReconciler(t, server.URL, v1.DesecTokenSpec{
+			SecretName:   "some-secret",
+			MaxAge:       &metav1.Duration{Duration: 2 * time.Hour},
+			RotateBefore: &metav1.Duration{Duration: 24 * time.Hour},
+		})
+		reconcileToken(t, &reconciler)
+		token := getDesecToken(t, reconciler)
+		// rotateBefore is capped at half of maxAge
+		assert.Equal(t, time.Hour, token.Status.RotateAt.Sub(token.Status.Created.Time))
+		// When
+		token.Status.RotateAt = &metav1.Time{Time: time.Now().Add(-time.Minute)}
+		assert.NoError(t, reconciler.Status().Update(context.TODO(), token))
+		reconcileToken(t, &reconciler)
+		// Then
+		assert.Len(t, mock.tokens, 1)
+		assert.Contains(t, mock.tokens, "token-2")
+		assert.Equal(t, "secret-2", getTokenSecret(t, reconciler))
+		condition := meta.FindStatusCondition(getDesecToken(t, reconciler).Status.Conditions, "Ready")
+		assert.NotNil(t, condition)
+		assert.Equal(t, "Rotated", condition.Reason)
+
+		// When
+		delete(mock.tokens, "token-2")
+		reconcileToken(t, &reconciler)
+		// Then
+		assert.Len(t, mock.tokens, 1)
+		assert.Equal(t, "secret-3", getTokenSecret(t, reconciler))
+		condition = meta.FindStatusCondition(getDesecToken(t, reconciler).Status.Conditions, "Ready")
+		assert.NotNil(t, condition)
+		assert.Equal(t, "Invalidated", condition.Reason)
+	})
+
+	t.Run("Policies", func(t *testing.T) {
+		// Given
+		mock := newTokenMock()
+		server := createTokenServer(t, mock)
+		defer server.Close()
+		reconciler := createDesecTokenReconciler(t, server.URL, v1.DesecTokenSpec{
+			SecretName: "some-secret",
+			Policies: []v1.DesecTokenPolicy{
+				{Domain: new("some-domain.dedyn.io"), Subname: new("_acme-challenge"), Type: new("TXT"), PermWrite: true},
+			},
+		})
+		// When
+		reconcileToken(t, &reconciler)
+		// Then
+		assert.Equal(t, []desec.TokenPolicy{
+			{ID: "policy-1"},
+			{ID: "policy-2", Domain: new("some-domain.dedyn.io"), Subname: new("_acme-challenge"), Type: new("TXT"), PermWrite: true},
+		}, mock.policies["token-1"])
+
+		// When
+		token := getDesecToken(t, reconciler)
+		token.Spec.Policies = append(token.Spec.Policies, v1.DesecTokenPolicy{PermWrite: true})
+		token.Generation = token.Generation + 1
+		assert.NoError(t, reconciler.Update(context.TODO(), token))
+		reconcileToken(t, &reconciler)
+		// Then
+		assert.Len(t, mock.tokens, 1)
+		assert.Equal(t, []desec.TokenPolicy{
+			{ID: "policy-3", PermWrite: true},
+			{ID: "policy-4", Domain: new("some-domain.dedyn.io"), Subname: new("_acme-challenge"), Type: new("TXT"), PermWrite: true},
+		}, mock.policies["token-2"])
+		condition := meta.FindStatusCondition(getDesecToken(t, reconciler).Status.Conditions, "Ready")
+		assert.NotNil(t, condition)
+		assert.Equal(t, "SpecChanged", condition.Reason)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		// Given
+		mock := newTokenMock()
+		server := createTokenServer(t, mock)
+		defer server.Close()
+		reconciler := createDesecTokenReconciler(t, server.URL, v1.DesecTokenSpec{
+			SecretName:     "some-secret",
+			AllowedSubnets: []string{"invalid"},
+		})
+		// When
+		result := reconcileToken(t, &reconciler)
+		// Then
+		assert.True(t, result.IsZero())
+		assert.Empty(t, mock.tokens)
+		condition := meta.FindStatusCondition(getDesecToken(t, reconciler).Status.Conditions, "Ready")
+		assert.NotNil(t, condition)
+		assert.Equal(t, metav1.ConditionFalse, condition.Status)
+		assert.Equal(t, "Invalid", condition.Reason)
+
+		// When
+		result = reconcileToken(t, &reconciler)
+		// Then
+		assert.True(t, result.IsZero())
+		assert.Equal(t, 1, mock.counter)
+	})
+
+	t.Run("Mounted credentials", func(t *testing.T) {
+		// Given
+		mock := newTokenMock()
+		server := createTokenServer(t, mock)
+		defer server.Close()
+		reconciler := createDesecTokenReconciler(t, server.URL, v1.DesecTokenSpec{
+			SecretName:       "some-secret",
+			PermManageTokens: true,
+		})
+		assert.NoError(t, os.WriteFile(reconciler.ConfigDir+"/config/namespace", []byte("desec-dns-operator"), fs.ModePerm))
+		// When
+		result := reconcileToken(t, &reconciler)
+		// Then only the namespace of the operator may use them
+		assert.Equal(t, 5*time.Minute, result.RequeueAfter)
+		assert.Empty(t, mock.tokens)
+		condition := meta.FindStatusCondition(getDesecToken(t, reconciler).Status.Conditions, "Ready")
+		assert.NotNil(t, condition)
+		assert.Equal(t, metav1.ConditionFalse, condition.Status)
+		assert.Equal(t, "Forbidden", condition.Reason)
+		assert.Equal(t, "The mounted credentials may only be used in namespace desec-dns-operator, set account", condition.Message)
+
+		// When in the namespace of the operator
+		assert.NoError(t, os.WriteFile(reconciler.ConfigDir+"/config/namespace", []byte(tokenRequest.Namespace), fs.ModePerm))
+		reconcileToken(t, &reconciler)
+		// Then
+		assert.Len(t, mock.tokens, 1)
+		assert.True(t, mock.tokens["token-1"].PermManageTokens)
+	})
+
+	t.Run("Privileges", func(t *testing.T) {
+		for _, tc := range []struct {
+			spec      v1.DesecTokenSpec
+			privilege string
+		}{
+			{v1.DesecTokenSpec{PermManageTokens: true}, "permManageTokens"},
+			{v1.DesecTokenSpec{PermCreateDomain: true}, "permCreateDomain"},
+			{v1.DesecTokenSpec{PermDeleteDomain: true}, "permDeleteDomain"},
+			{v1.DesecTokenSpec{Policies: []v1.DesecTokenPolicy{{PermWrite: true}}}, "A policy with permWrite and no domain"},
+			{v1.DesecTokenSpec{Policies: []v1.DesecTokenPolicy{{Type: new("TXT"), PermWrite: true}}}, "A policy with permWrite and no domain"},
+		} {
+			// Given
+			mock := newTokenMock()
+			server := createTokenServer(t, mock)
+			tc.spec.SecretName = "some-secret"
+			tc.spec.Account = "some-account"
+			reconciler := createDesecTokenReconciler(t, server.URL, tc.spec)
+			createAccount(t, reconciler.Client, server.URL, "account token")
+			// When the account does not list the namespace
+			result := reconcileToken(t, &reconciler)
+			// Then
+			assert.Equal(t, 5*time.Minute, result.RequeueAfter)
+			assert.Empty(t, mock.tokens, tc.privilege)
+			condition := meta.FindStatusCondition(getDesecToken(t, reconciler).Status.Conditions, "Ready")
+			if assert.NotNil(t, condition) {
+				assert.Equal(t, "Forbidden", condition.Reason)
+				assert.Equal(t, tc.privilege+" requires a DesecAccount listing namespace some-namespace in tokenManagerNamespaces", condition.Message)
+			}
+
+			// When it does
+			account := getAccountOf(t, reconciler.Client)
+			account.Spec.TokenManagerNamespaces = []string{"some-namespace"}
+			assert.NoError(t, reconciler.Update(context.TODO(), account))
+			reconcileToken(t, &reconciler)
+			// Then
+			assert.Len(t, mock.tokens, 1, tc.privilege)
+			assert.Equal(t, "Created", meta.FindStatusCondition(getDesecToken(t, reconciler).Status.Conditions, "Ready").Reason)
+			server.Close()
+		}
+
+		// Given a token restricted to a domain
+		mock := newTokenMock()
+		server := createTokenServer(t, mock)
+		defer server.Close()
+		reconciler := createDesecTokenReconciler(t, server.URL, v1.DesecTokenSpec{
+			SecretName: "some-secret",
+			Account:    "some-account",
+			Policies:   []v1.DesecTokenPolicy{{Domain: new("some-domain.dedyn.io"), PermWrite: true}},
+		})
+		createAccount(t, reconciler.Client, server.URL, "account token")
+		// When
+		reconcileToken(t, &reconciler)
+		// Then
+		assert.Len(t, mock.tokens, 1)
+	})
+
+	t.Run("Status update lost", func(t *testing.T) {
+		// Given
+		mock := newTokenMock()
+		server := createTokenServer(t, mock)
+		defer server.Close()
+		reconciler := createDesecTokenReconciler(t, server.URL, v1.DesecTokenSpec{SecretName: "some-secret"})
+		reconcileToken(t, &reconciler)
+		token := getDesecToken(t, reconciler)
+		token.Status = v1.DesecTokenStatus{}
+		assert.NoError(t, reconciler.Status().Update(context.TODO(), token))
+		// When
+		reconcileToken(t, &reconciler)
+		// Then
+		assert.Len(t, mock.tokens, 1)
+		assert.Equal(t, "secret-1", getTokenSecret(t, reconciler))
+		token = getDesecToken(t, reconciler)
+		assert.Equal(t, "token-1", token.Status.TokenID)
+		condition := meta.FindStatusCondition(token.Status.Conditions, "Ready")
+		assert.NotNil(t, condition)
+		assert.Equal(t, "Recovered", condition.Reason)
+
+		// When
+		assert.NoError(t, reconciler.Status().Update(context.TODO(), &v1.DesecToken{ObjectMeta: token.ObjectMeta}))
+		assert.NoError(t, reconciler.Delete(context.TODO(), getDesecToken(t, reconciler)))
+		reconcileToken(t, &reconciler)
+		// Then
+		assert.Empty(t, mock.tokens)
+	})
+
+	t.Run("Secret of somebody else", func(t *testing.T) {
+		// Given
+		mock := newTokenMock()
+		server := createTokenServer(t, mock)
+		defer server.Close()
+		reconciler := createDesecTokenReconciler(t, server.URL, v1.DesecTokenSpec{SecretName: "some-secret"})
+		assert.NoError(t, reconciler.Create(context.TODO(), &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "some-secret", Namespace: tokenRequest.Namespace},
+			Data:       map[string][]byte{"token": []byte("foreign")},
+		}))
+		// When
+		result := reconcileToken(t, &reconciler)
+		// Then
+		assert.True(t, result.IsZero())
+		assert.Equal(t, 0, mock.counter)
+		condition := meta.FindStatusCondition(getDesecToken(t, reconciler).Status.Conditions, "Ready")
+		assert.NotNil(t, condition)
+		assert.Equal(t, metav1.ConditionFalse, condition.Status)
+		assert.Equal(t, "SecretConflict", condition.Reason)
+		secret := new(corev1.Secret)
+		assert.NoError(t, reconciler.Get(context.TODO(), types.NamespacedName{Name: "some-secret", Namespace: tokenRequest.Namespace}, secret))
+		assert.Equal(t, "foreign", string(secret.Data["token"]))
+		assert.Empty(t, secret.OwnerReferences)
+
+		// When
+		result = reconcileToken(t, &reconciler)
+		// Then
+		assert.True(t, result.IsZero())
+		assert.Equal(t, 0, mock.counter)
+	})
+
+	t.Run("Dry-run", func(t *testing.T) {
+		// Given
+		mock := newTokenMock()
+		server := createTokenServer(t, mock)
+		defer server.Close()
+		reconciler := createDesecTokenReconciler(t, server.URL, v1.DesecTokenSpec{SecretName: "some-secret"})
+		reconciler.DryRun = true
+		recorder := events.NewFakeRecorder(10)
+		reconciler.Recorder = recorder
+		// When
+		result := reconcileToken(t, &reconciler)
+		// Then
+		assert.Equal(t, 5*time.Minute, result.RequeueAfter)
+		assert.Empty(t, mock.tokens)
+		token := getDesecToken(t, reconciler)
+		assert.Equal(t, []string{"create token"}, token.Status.PlannedChanges)
+		assert.True(t, isConditionReason(token.Status.Conditions, "Ready", "DryRun"))
+		assert.Equal(t, "Normal DryRun Would create token", <-recorder.Events)
+
+		// When not in dry-run anymore
+		reconciler.DryRun = false
+		reconcileToken(t, &reconciler)
+		// Then
+		assert.Len(t, mock.tokens, 1)
+		assert.Empty(t, getDesecToken(t, reconciler).Status.PlannedChanges)
+
+		// When the token is due, in dry-run
+		reconciler.DryRun = true
+		token = getDesecToken(t, reconciler)
+		token.Status.RotateAt = &metav1.Time{Time: time.Now().Add(-time.Minute)}
+		assert.NoError(t, reconciler.Status().Update(context.TODO(), token))
+		reconcileToken(t, &reconciler)
+		// Then
+		assert.Contains(t, mock.tokens, "token-1")
+		assert.Equal(t, "secret-1", getTokenSecret(t, reconciler))
+		assert.Equal(t, []string{"rotate token"}, getDesecToken(t, reconciler).Status.PlannedChanges)
+		assert.Equal(t, "Normal DryRun Would rotate token", <-recorder.Events)
+
+		// When deleted
+		assert.NoError(t, reconciler.Delete(context.TODO(), getDesecToken(t, reconciler)))
+		result = reconcileToken(t, &reconciler)
+		// Then the token is kept, and so is the finalizer
+		assert.Equal(t, 5*time.Minute, result.RequeueAfter)
+		assert.Contains(t, mock.tokens, "token-1")
+		token = getDesecToken(t, reconciler)
+		assert.Equal(t, []string{tokenFinalizer}, token.Finalizers)
+		assert.Equal(t, []string{"delete token"}, token.Status.PlannedChanges)
+		assert.Equal(t, "Normal DryRun Would delete token", <-recorder.Events)
+	})
+
+	t.Run("Cleanup", func(t *testing.T) {
+		// Given
+		mock := newTokenMock()
+		server := createTokenServer(t, mock)
+		defer server.Close()
+		reconciler := createDesecTokenReconciler(t, server.URL, v1.DesecTokenSpec{SecretName: "some-secret"})
+		reconcileToken(t, &reconciler)
+		assert.Len(t, mock.tokens, 1)
+		// When
+		assert.NoError(t, reconciler.Delete(context.TODO(), getDesecToken(t, reconciler)))
+		reconcileToken(t, &reconciler)
+		// Then
+		assert.Empty(t, mock.tokens)
+		assert.EqualError(t, reconciler.Get(context.TODO(), tokenRequest.NamespacedName, new(v1.DesecToken)), `desectokens.desec.owly.dedyn.io "some-token" not found`)
+	})
+
+	t.Run("Account deleted first", func(t *testing.T) {
+		// Given
+		mock := newTokenMock()
+		server := createTokenServer(t, mock)
+		defer server.Close()
+		reconciler := createDesecTokenReconciler(t, server.URL, v1.DesecTokenSpec{SecretName: "some-secret", Account: "some-account"})
+		recorder := events.NewFakeRecorder(10)
+		reconciler.Recorder = recorder
+		createAccount(t, reconciler.Client, server.URL, "account token")
+		reconcileToken(t, &reconciler)
+		assert.Len(t, mock.tokens, 1)
+		// When
+		assert.NoError(t, reconciler.Delete(context.TODO(), getAccountOf(t, reconciler.Client)))
+		assert.NoError(t, reconciler.Delete(context.TODO(), getDesecToken(t, reconciler)))
+		reconcileToken(t, &reconciler)
+		// Then the token is left behind, but the DesecToken is released
+		assert.Len(t, mock.tokens, 1)
+		assert.EqualError(t, reconciler.Get(context.TODO(), tokenRequest.NamespacedName, new(v1.DesecToken)), `desectokens.desec.owly.dedyn.io "some-token" not found`)
+		assert.Contains(t, <-recorder.Events, "Warning ClientFailed Leaving token behind: ")
+	})
+}
+
+// tokenMock is a minimal in-memory version of deSEC's token management.
+type tokenMock struct {
+	tokens        map[string]desec.Token
+	policies      map[string][]desec.TokenPolicy
+	counter       int
+	policyCounter int
+}
+
+func newTokenMock() *tokenMock {
+	return &tokenMock{tokens: map[string]desec.Token{}, policies: map[string][]desec.TokenPolicy{}}
+}
+
+func createTokenServer(t *testing.T, mock *tokenMock) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Paths look like /api/v1/auth/tokens/{id}/policies/rrsets/
+		id, policyPath, isPolicy := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/v1/auth/tokens/"), "/policies/rrsets/")
+		id = strings.TrimSuffix(id, "/")
+		respond := func(status int, value any) {
+			body, err := json.Marshal(value)
+			assert.NoError(t, err)
+			w.WriteHeader(status)
+			_, err = w.Write(body)
+			assert.NoError(t, err)
+		}
+		switch {
+		case id == "" && r.Method == "POST":
+			mock.counter = mock.counter + 1
+			body, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			token := desec.Token{}
+			assert.NoError(t, json.Unmarshal(body, &token))
+			if len(token.AllowedSubnets) > 0 && token.AllowedSubnets[0] == "invalid" {
+				respond(400, map[string][]string{"allowed_subnets": {"Invalid subnet."}})
+				return
+			}
+			token.ID = fmt.Sprintf("token-%d", mock.counter)
+			token.IsValid = true
+			mock.tokens[token.ID] = token
+			token.Token = fmt.Sprintf("secret-%d", mock.counter)
+			respond(201, token)
+		case isPolicy && policyPath == "" && r.Method == "POST":
+			body, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			policy := desec.TokenPolicy{}
+			assert.NoError(t, json.Unmarshal(body, &policy))
+			mock.policyCounter = mock.policyCounter + 1
+			policy.ID = fmt.Sprintf("policy-%d", mock.policyCounter)
+			mock.policies[id] = append(mock.policies[id], policy)
+			respond(201, policy)
+		case !isPolicy && r.Method == "GET":
+			token, ok := mock.tokens[id]
+			if !ok {
+				respond(404, map[string]string{"detail": "Not found."})
+				return
+			}
+			respond(200, token)
+		case !isPolicy && r.Method == "DELETE":
+			delete(mock.tokens, id)
+			w.WriteHeader(204)
+		default:
+			t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+		}
+	}))
+}
+
+func createDesecTokenReconciler(t *testing.T, serverUrl string, spec v1.DesecTokenSpec) DesecTokenReconciler {
+	disableRateLimits(t)
+	mockScheme := runtime.NewScheme()
+	assert.NoError(t, v1.AddToScheme(mockScheme))
+	assert.NoError(t, corev1.AddToScheme(mockScheme))
+
+	fakeClient := fake.NewClientBuilder().
+		WithScheme(mockScheme).
+		WithStatusSubresource(new(v1.DesecToken)).
+		WithObjects(&v1.DesecToken{
+			ObjectMeta: metav1.ObjectMeta{Name: tokenRequest.Name, Namespace: tokenRequest.Namespace, Generation: 1},
+			Spec:       spec,
+		}).
+		Build()
+
+	// The mounted credentials may be used by the namespace of the operator
+	configDir := util.CreateConfigDir(t, serverUrl)
+	assert.NoError(t, os.WriteFile(configDir+"/config/namespace", []byte(tokenRequest.Namespace), fs.ModePerm))
+
+	return DesecTokenReconciler{
+		Client:    fakeClient,
+		Scheme:    mockScheme,
+		ConfigDir: configDir,
+	}
+}
+
+// reconcileToken reconciles until no further step is requested, and returns
+// the final result.
+func reconcileToken(t *testing.T, reconciler *DesecTokenReconciler) reconcile.Result {
+	for {
+		result, err := reconciler.Reconcile(context.TODO(), tokenRequest)
+		assert.NoError(t, err)
+		if err != nil || result.RequeueAfter != 100*time.Millisecond {
+			return result
+		}
+	}
+}
+
+func getDesecToken(t *testing.T, reconciler DesecTokenReconciler) *v1.DesecToken {
+	token := new(v1.DesecToken)
+	assert.NoError(t, reconciler.Get(context.TODO(), tokenRequest.NamespacedName, token))
+	return token
+}
+
+func getTokenSecret(t *testing.T, reconciler DesecTokenReconciler) string {
+	secret := new(corev1.Secret)
+	assert.NoError(t, reconciler.Get(context.TODO(), types.NamespacedName{Name: "some-secret", Namespace: tokenRequest.Namespace}, secret))
+	assert.Len(t, secret.OwnerReferences, 1)
+	assert.Equal(t, "true", secret.Labels[TokenSecretLabel])
+	return string(secret.Data["token"])
+}
@@ -0,0 +1,548 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/events"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	v1 "github.com/j-be/desec-dns-operator/api/v1"
+	"github.com/j-be/desec-dns-operator/controllers/config"
+	"github.com/j-be/desec-dns-operator/controllers/desec"
+	"github.com/j-be/desec-dns-operator/controllers/util"
+)
+
+const dnsEndpointFinalizer = "desec.owly.dedyn.io/endpoints"
+
+// endpointStatusAnnotation holds the sync status of each endpoint of a
+// DNSEndpoint, as its status has no room for it.
+const endpointStatusAnnotation = "desec.owly.dedyn.io/endpoint-status"
+
+// dnsEndpointGVK is the kind of external-dns' DNSEndpoint. It is handled as
+// unstructured, to not depend on external-dns.
+var dnsEndpointGVK = schema.GroupVersionKind{Group: "externaldns.k8s.io", Version: "v1alpha1", Kind: "DNSEndpoint"}
+
+// dnsEndpointSpec is the part of the spec of a DNSEndpoint used to create
+// RRSets.
+type dnsEndpointSpec struct {
+	Endpoints []dnsEndpoint `json:"endpoints"`
+}
+
+// dnsEndpoint is a single endpoint of a DNSEndpoint.
+type dnsEndpoint struct {
+	DNSName    string   `json:"dnsName"`
+	Targets    []string `json:"targets"`
+	RecordType string   `json:"recordType"`
+	RecordTTL  int64    `json:"recordTTL"`
+}
+
+// endpointStatus is the sync status of an endpoint. Domain is only set if the
+// endpoint is within a managed domain, i.e. an RRSet was set for it.
+type endpointStatus struct {
+	DNSName    string `json:"dnsName"`
+	RecordType string `json:"recordType"`
+	Domain     string `json:"domain,omitempty"`
+	Subname    string `json:"subname,omitempty"`
+	Status     string `json:"status"`
+	Message    string `json:"message,omitempty"`
+	// Owned is kept for an RRSet the operator set before, while setting it
+	// again fails
+	Owned bool `json:"owned,omitempty"`
+}
+
+// isOwned reports whether the operator set the RRSet of the endpoint. A failed
+// endpoint did not, unless its RRSet was synced before.
+func (s endpointStatus) isOwned() bool {
+	return s.Domain != "" && (s.Status == "Synced" || s.Owned)
+}
+
+// matches reports whether the status is for the same RRSet as the other one.
+func (s endpointStatus) matches(other endpointStatus) bool {
+	return s.Domain == other.Domain && s.Subname == other.Subname && s.RecordType == other.RecordType
+}
+
+// DNSEndpointReconciler reconciles a DNSEndpoint object of external-dns
+type DNSEndpointReconciler struct {
+	client.Client
+	Scheme    *runtime.Scheme
+	ConfigDir string
+	// Options for the deSEC clients created while reconciling
+	ClientOptions []desec.Option
+	// OwnerID enables the registry, see IngressReconciler
+	OwnerID string
+	// Recorder for events regarding DNSEndpoints, none are emitted if nil
+	Recorder events.EventRecorder
+	// DryRun only plans the changes to deSEC of all DNSEndpoints, instead of
+	// applying them
+	DryRun bool
+}
+
+//+kubebuilder:rbac:groups=externaldns.k8s.io,resources=dnsendpoints,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=externaldns.k8s.io,resources=dnsendpoints/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=externaldns.k8s.io,resources=dnsendpoints/finalizers,verbs=update
+//+kubebuilder:rbac:groups=desec.owly.dedyn.io,resources=desecdomains,verbs=get;list;watch
+//+kubebuilder:rbac:groups=desec.owly.dedyn.io,resources=desecaccounts,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+
+// Reconcile sets an RRSet for each endpoint of a DNSEndpoint within a managed
+// domain, and removes them once they are dropped or the DNSEndpoint is
+// deleted.
+func (r *DNSEndpointReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, err error) {
+	defer func() { result, err = requeueIfThrottled(ctx, result, err) }()
+	log := log.FromContext(ctx)
+
+	log.Info("Starting", "req", req)
+
+	desecConfig, err := config.NewConfigFor(r.ConfigDir)
+	if err != nil {
+		log.Error(err, "Failed to read the configuration")
+		return ctrl.Result{}, err
+	}
+	managedDomains, err := getManagedDomains(ctx, r.Client, desecConfig)
+	if err != nil {
+		log.Error(err, "Failed to list domains")
+		return ctrl.Result{}, err
+	}
+
+	// Fetch DNSEndpoint
+	endpoint := newDNSEndpoint()
+	if err := r.Get(ctx, req.NamespacedName, endpoint); err != nil {
+		if apierrors.IsNotFound(err) {
+			log.Info("DNSEndpoint not found, not doing anything", "req", req)
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	previous := []endpointStatus{}
+	if annotation, ok := endpoint.GetAnnotations()[endpointStatusAnnotation]; ok {
+		if err := json.Unmarshal([]byte(annotation), &previous); err != nil {
+			log.Error(err, "Ignoring invalid endpoint status")
+		}
+	}
+	syncer := rrsetSyncer{
+		reconciler:     r,
+		managedDomains: managedDomains,
+		namespace:      desecConfig.Namespace,
+		resource:       "dnsendpoint/" + endpoint.GetNamespace() + "/" + endpoint.GetName(),
+		dryRun:         r.DryRun || isAnnotatedDryRun(endpoint),
+	}
+
+	// Remove the RRSets before releasing the DNSEndpoint
+	if !endpoint.GetDeletionTimestamp().IsZero() {
+		if !controllerutil.ContainsFinalizer(endpoint, dnsEndpointFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		if err := syncer.removeStale(ctx, previous, nil); err != nil {
+			return ctrl.Result{}, err
+		}
+		if syncer.dryRun {
+			// Keep the DNSEndpoint until not in dry-run anymore
+			return ctrl.Result{RequeueAfter: 5 * time.Minute}, r.updatePlan(ctx, endpoint, syncer.planned)
+		}
+		controllerutil.RemoveFinalizer(endpoint, dnsEndpointFinalizer)
+		return ctrl.Result{}, r.Update(ctx, endpoint)
+	}
+	if controllerutil.AddFinalizer(endpoint, dnsEndpointFinalizer) {
+		err := r.Update(ctx, endpoint)
+		return ctrl.Result{Requeue: true, RequeueAfter: 100 * time.Millisecond}, err
+	}
+
+	spec := dnsEndpointSpec{}
+	if specMap, ok := endpoint.Object["spec"].(map[string]any); ok {
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(specMap, &spec); err != nil {
+			log.Error(err, "Failed to parse DNSEndpoint")
+			return ctrl.Result{}, err
+		}
+	}
+
+	// Set an RRSet for each endpoint
+	observedGeneration, _, _ := unstructured.NestedInt64(endpoint.Object, "status", "observedGeneration")
+	domainNames := domainsFor(endpoint, managedDomains)
+	statuses := []endpointStatus{}
+	for _, ep := range mergeEndpoints(spec.Endpoints) {
+		status := endpointStatus{DNSName: ep.DNSName, RecordType: ep.RecordType}
+		host := strings.TrimRight(ep.DNSName, ".")
+		status.Domain = util.MatchDomain(host, domainNames)
+		if status.Domain == "" {
+			status.Status = "Unmanaged"
+			status.Message = "Not within any managed domain"
+			statuses = append(statuses, status)
+			continue
+		}
+		status.Subname = strings.TrimSuffix(strings.TrimSuffix(host, status.Domain), ".")
+
+		rrset := desec.RRSet{
+			Subname: status.Subname,
+			Type:    ep.RecordType,
+			Records: toRecords(ep.RecordType, ep.Targets),
+			TTL:     ep.RecordTTL,
+		}
+		// Don't retry an endpoint deSEC already rejected, until the spec
+		// changes
+		if index := slices.IndexFunc(previous, status.matches); index >= 0 && previous[index].Status == "Invalid" && observedGeneration == endpoint.GetGeneration() {
+			statuses = append(statuses, previous[index])
+			continue
+		}
+		tracked := slices.ContainsFunc(previous, func(previous endpointStatus) bool {
+			return previous.isOwned() && previous.matches(status)
+		})
+		status.Status, status.Message, err = syncer.set(ctx, status.Domain, rrset, tracked)
+		if err != nil {
+			if desec.IsThrottled(err) {
+				return ctrl.Result{}, err
+			}
+			status.Status = errorReason(err)
+			status.Message = err.Error()
+			status.Owned = tracked
+		}
+		statuses = append(statuses, status)
+	}
+
+	// Remove RRSets of endpoints, which are gone
+	if err := syncer.removeStale(ctx, previous, statuses); err != nil {
+		return ctrl.Result{}, err
+	}
+	// The status is left alone while planning, as it tells which RRSets to
+	// remove later on
+	if err := r.updatePlan(ctx, endpoint, syncer.planned); err != nil {
+		return ctrl.Result{}, err
+	}
+	if syncer.dryRun {
+		return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+	}
+
+	// Write the status back
+	if !slices.Equal(previous, statuses) {
+		annotation, err := json.Marshal(statuses)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		annotations := endpoint.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[endpointStatusAnnotation] = string(annotation)
+		endpoint.SetAnnotations(annotations)
+		if err := r.Update(ctx, endpoint); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	if observedGeneration != endpoint.GetGeneration() {
+		status, _ := endpoint.Object["status"].(map[string]any)
+		if status == nil {
+			status = map[string]any{}
+		}
+		status["observedGeneration"] = endpoint.GetGeneration()
+		endpoint.Object["status"] = status
+		if err := r.Status().Update(ctx, endpoint); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// Check for changes done outside the cluster every now and then
+	return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+}
+
+// updatePlan writes the planned changes to the annotation of the DNSEndpoint,
+// and publishes new ones as events.
+func (r *DNSEndpointReconciler) updatePlan(ctx context.Context, endpoint *unstructured.Unstructured, planned []string) error {
+	plannedChanges := []string{}
+	if annotation, ok := endpoint.GetAnnotations()[plannedChangesAnnotation]; ok {
+		if err := json.Unmarshal([]byte(annotation), &plannedChanges); err != nil {
+			log.FromContext(ctx).Error(err, "Ignoring invalid planned changes")
+		}
+	}
+	if !plan(r.Recorder, endpoint, &plannedChanges, isAnyChange, planned) {
+		return nil
+	}
+
+	annotations := endpoint.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	delete(annotations, plannedChangesAnnotation)
+	if len(plannedChanges) > 0 {
+		annotation, err := json.Marshal(plannedChanges)
+		if err != nil {
+			return err
+		}
+		annotations[plannedChangesAnnotation] = string(annotation)
+	}
+	endpoint.SetAnnotations(annotations)
+	return r.Update(ctx, endpoint)
+}
+
+// rrsetSyncer sets RRSets in managed domains, fetching the RRSets of each
+// domain only once. In dry-run, the changes are only planned.
+type rrsetSyncer struct {
+	reconciler     *DNSEndpointReconciler
+	managedDomains []managedDomain
+	// namespace the clients are created for, the one of the operator, as the
+	// domains are only routed to if their account allows the DNSEndpoint
+	namespace string
+	// resource is recorded as the owner of the RRSets by the registry
+	resource string
+	clients  map[string]desec.Client
+	rrsets   map[string][]desec.RRSet
+	dryRun   bool
+	planned  []string
+}
+
+func (s *rrsetSyncer) client(ctx context.Context, domain string) (desec.Client, error) {
+	if desecClient, ok := s.clients[domain]; ok {
+		return desecClient, nil
+	}
+	account := ""
+	if index := slices.IndexFunc(s.managedDomains, func(managed managedDomain) bool { return managed.name == domain }); index >= 0 {
+		account = s.managedDomains[index].account
+	}
+	desecClient, err := newDesecClient(ctx, s.reconciler.Client, s.reconciler.ConfigDir, account, s.namespace, domain, s.reconciler.ClientOptions...)
+	if err != nil {
+		return desec.Client{}, err
+	}
+	if s.clients == nil {
+		s.clients = map[string]desec.Client{}
+	}
+	s.clients[domain] = desecClient
+	return desecClient, nil
+}
+
+// policy returns the adoption policy of the domain.
+func (s *rrsetSyncer) policy(domain string) v1.AdoptionPolicy {
+	if index := slices.IndexFunc(s.managedDomains, func(managed managedDomain) bool { return managed.name == domain }); index >= 0 {
+		return s.managedDomains[index].adoptionPolicy
+	}
+	return ""
+}
+
+// load returns the client and the RRSets of the domain.
+func (s *rrsetSyncer) load(ctx context.Context, domain string) (desec.Client, []desec.RRSet, error) {
+	desecClient, err := s.client(ctx, domain)
+	if err != nil {
+		return desec.Client{}, nil, err
+	}
+	if _, ok := s.rrsets[domain]; !ok {
+		rrsets, err := desecClient.GetRRSets(ctx)
+		if err != nil {
+			return desec.Client{}, nil, err
+		}
+		if s.rrsets == nil {
+			s.rrsets = map[string][]desec.RRSet{}
+		}
+		s.rrsets[domain] = rrsets
+	}
+	return desecClient, s.rrsets[domain], nil
+}
+
+// set sets the RRSet in the domain, unless it is already set as given, and
+// returns the status and message of its endpoint. An RRSet of somebody else
+// according to the registry is left alone, as is an RRSet not tracked as set
+// by the operator before if the adoption policy is Create, and any RRSet if it
+// is Observe.
+func (s *rrsetSyncer) set(ctx context.Context, domain string, rrset desec.RRSet, tracked bool) (string, string, error) {
+	desecClient, rrsets, err := s.load(ctx, domain)
+	if err != nil {
+		return "", "", err
+	}
+	reg := registry{ownerID: s.reconciler.OwnerID, rrsets: rrsets}
+
+	if rrset.TTL == 0 {
+		rrset.TTL = 3600
+		if index := slices.IndexFunc(s.managedDomains, func(managed managedDomain) bool { return managed.name == domain }); index >= 0 && s.managedDomains[index].ttl > 0 {
+			rrset.TTL = s.managedDomains[index].ttl
+		}
+	}
+	existing := findRRSet(rrsets, rrset.Subname, rrset.Type)
+	inSync := existing != nil && existing.TTL == rrset.TTL &&
+		slices.Equal(slices.Sorted(slices.Values(existing.Records)), slices.Sorted(slices.Values(rrset.Records)))
+	synced := fmt.Sprintf("%s set to: %v", rrset.Type, rrset.Records)
+	txt, txtChanged := reg.claim(rrset.Subname, rrset.Type, s.resource, rrset.TTL)
+
+	policy := s.policy(domain)
+	conflict := reg.conflict(rrset.Subname, rrset.Type, tracked || policy == v1.AdoptionPolicyAdopt)
+	switch {
+	case policy == v1.AdoptionPolicyObserve && inSync:
+		return "InSync", "", nil
+	case policy == v1.AdoptionPolicyObserve:
+		return "Drift", "Would " + describeChange(rrset), nil
+	case conflict != "":
+		return "NotOwned", conflict, nil
+	case policy == v1.AdoptionPolicyCreate && existing != nil && !tracked:
+		return "AlreadyExists", fmt.Sprintf("The %s RRSet existed before and adoptionPolicy is Create", rrset.Type), nil
+	case inSync && !txtChanged:
+		return "Synced", synced, nil
+	}
+
+	if s.dryRun {
+		s.planned = append(s.planned, describeChange(rrset)+" in "+domain)
+		return "Synced", synced, nil
+	}
+	// The owner is recorded in the same request
+	changes := []desec.RRSet{rrset}
+	if txtChanged {
+		changes = append(changes, txt)
+	}
+	log.FromContext(ctx).Info("Setting RRSet", "subname", rrset.Subname, "type", rrset.Type, "domain", domain)
+	if _, err := desecClient.BulkUpsertRRSets(ctx, changes); err != nil {
+		return "", "", err
+	}
+	return "Synced", synced, nil
+}
+
+// removeStale removes the RRSets set for the previous endpoints, which are not
+// set for the current ones anymore. Nothing is removed from an observed
+// domain.
+func (s *rrsetSyncer) removeStale(ctx context.Context, previous []endpointStatus, current []endpointStatus) error {
+	for _, status := range previous {
+		if !status.isOwned() || s.policy(status.Domain) == v1.AdoptionPolicyObserve || slices.ContainsFunc(current, status.matches) {
+			continue
+		}
+		if s.dryRun {
+			s.planned = append(s.planned, "remove "+nameOf(status.Subname, status.RecordType)+" in "+status.Domain)
+			continue
+		}
+		log := log.FromContext(ctx).WithValues("subname", status.Subname, "type", status.RecordType, "domain", status.Domain)
+		desecClient, rrsets, err := s.load(ctx, status.Domain)
+		if err != nil {
+			return err
+		}
+		reg := registry{ownerID: s.reconciler.OwnerID, rrsets: rrsets}
+		if findRRSet(rrsets, status.Subname, status.RecordType) != nil && !reg.owns(status.Subname, status.RecordType, true) {
+			log.Info("Leaving RRSet of somebody else", "conflict", reg.conflict(status.Subname, status.RecordType, true))
+			continue
+		}
+		// Along with its owner
+		changes := []desec.RRSet{{Subname: status.Subname, Type: status.RecordType}}
+		if txt, ok := reg.release(status.Subname, status.RecordType); ok {
+			changes = append(changes, txt)
+		}
+		log.Info("Removing RRSet")
+		if err := desecClient.BulkDeleteRRSets(ctx, changes); err != nil && !desec.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeEndpoints merges the targets of endpoints of the same name and type,
+// as they make up a single RRSet. The first TTL given is used.
+func mergeEndpoints(endpoints []dnsEndpoint) []dnsEndpoint {
+	merged := []dnsEndpoint{}
+	for _, ep := range endpoints {
+		index := slices.IndexFunc(merged, func(other dnsEndpoint) bool {
+			return strings.TrimRight(other.DNSName, ".") == strings.TrimRight(ep.DNSName, ".") && other.RecordType == ep.RecordType
+		})
+		if index < 0 {
+			ep.Targets = slices.Clone(ep.Targets)
+			merged = append(merged, ep)
+			continue
+		}
+		for _, target := range ep.Targets {
+			if !slices.Contains(merged[index].Targets, target) {
+				merged[index].Targets = append(merged[index].Targets, target)
+			}
+		}
+		merged[index].RecordTTL = cmp.Or(merged[index].RecordTTL, ep.RecordTTL)
+	}
+	return merged
+}
+
+// toRecords turns the targets of an endpoint into records as deSEC expects
+// them, i.e. with fully qualified hostnames and quoted TXT records.
+func toRecords(recordType string, targets []string) []string {
+	records := []string{}
+	for _, target := range targets {
+		switch recordType {
+		case "CNAME", "NS", "PTR":
+			if !strings.HasSuffix(target, ".") {
+				target = target + "."
+			}
+		case "TXT":
+			if !strings.HasPrefix(target, `"`) {
+				target = `"` + strings.ReplaceAll(target, `"`, `\"`) + `"`
+			}
+		}
+		records = append(records, target)
+	}
+	return records
+}
+
+// errorReason returns the reason an error of deSEC is reported with.
+func errorReason(err error) string {
+	switch {
+	case desec.IsInvalid(err):
+		return "Invalid"
+	case desec.IsNotFound(err):
+		return "DomainNotFound"
+	case desec.IsUnauthorized(err):
+		return "Unauthorized"
+	}
+	return "Error"
+}
+
+func newDNSEndpoint() *unstructured.Unstructured {
+	endpoint := new(unstructured.Unstructured)
+	endpoint.SetGroupVersionKind(dnsEndpointGVK)
+	return endpoint
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *DNSEndpointReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.ConfigDir = "./mnt"
+	return ctrl.NewControllerManagedBy(mgr).
+		// The status is written to an annotation, which must not trigger
+		// another reconciliation
+		For(newDNSEndpoint(), builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&v1.DesecDomain{}, handler.EnqueueRequestsFromMapFunc(r.allDNSEndpoints)).
+		Watches(&v1.DesecAccount{}, handler.EnqueueRequestsFromMapFunc(r.allDNSEndpoints)).
+		Complete(r)
+}
+
+// allDNSEndpoints requests all DNSEndpoints to be reconciled, e.g. as
+// endpoints may be within another domain.
+func (r *DNSEndpointReconciler) allDNSEndpoints(ctx context.Context, _ client.Object) []reconcile.Request {
+	endpoints := new(unstructured.UnstructuredList)
+	endpoints.SetGroupVersionKind(dnsEndpointGVK.GroupVersion().WithKind(dnsEndpointGVK.Kind + "List"))
+	if err := r.List(ctx, endpoints); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list DNSEndpoints")
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, endpoint := range endpoints.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&endpoint)})
+	}
+	return requests
+}
@@ -0,0 +1,339 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/events"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	v1 "github.com/j-be/desec-dns-operator/api/v1"
+	"github.com/j-be/desec-dns-operator/controllers/desec"
+	"github.com/j-be/desec-dns-operator/controllers/util"
+)
+
+var dnsEndpointRequest = reconcile.Request{NamespacedName: types.NamespacedName{Name: "some-endpoint", Namespace: "some-namespace"}}
+
+func TestDNSEndpointReconciler(t *testing.T) {
+	t.Run("Basic", func(t *testing.T) {
+		// Given
+		mock := &desecMock{domains: []desec.Domain{{Name: "some-domain.dedyn.io"}}}
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := createDNSEndpointReconciler(t, server.URL,
+			map[string]any{"dnsName": "www.some-domain.dedyn.io", "recordType": "A", "targets": []any{"1.2.3.4"}, "recordTTL": int64(7200)},
+			map[string]any{"dnsName": "some-domain.dedyn.io", "recordType": "TXT", "targets": []any{"v=spf1 -all"}},
+			map[string]any{"dnsName": "alias.some-domain.dedyn.io", "recordType": "CNAME", "targets": []any{"www.some-domain.dedyn.io"}},
+			map[string]any{"dnsName": "www.example.com", "recordType": "A", "targets": []any{"1.2.3.4"}},
+		)
+		// When
+		result := reconcileDNSEndpoint(t, &reconciler)
+		// Then
+		assert.Equal(t, 5*time.Minute, result.RequeueAfter)
+		assert.Len(t, mock.rrsets, 3)
+		for _, expected := range []desec.RRSet{
+			{Subname: "www", Type: "A", Records: []string{"1.2.3.4"}, TTL: 7200},
+			{Subname: "", Type: "TXT", Records: []string{`"v=spf1 -all"`}, TTL: 3600},
+			{Subname: "alias", Type: "CNAME", Records: []string{"www.some-domain.dedyn.io."}, TTL: 3600},
+		} {
+			rrset := findRRSet(mock.rrsets, expected.Subname, expected.Type)
+			if assert.NotNil(t, rrset) {
+				assert.Equal(t, "some-domain.dedyn.io", rrset.Domain)
+				assert.Equal(t, expected.Records, rrset.Records)
+				assert.Equal(t, expected.TTL, rrset.TTL)
+			}
+		}
+		endpoint := getDNSEndpoint(t, reconciler)
+		assert.Equal(t, []endpointStatus{
+			{DNSName: "www.some-domain.dedyn.io", RecordType: "A", Domain: "some-domain.dedyn.io", Subname: "www", Status: "Synced", Message: "A set to: [1.2.3.4]"},
+			{DNSName: "some-domain.dedyn.io", RecordType: "TXT", Domain: "some-domain.dedyn.io", Status: "Synced", Message: `TXT set to: ["v=spf1 -all"]`},
+			{DNSName: "alias.some-domain.dedyn.io", RecordType: "CNAME", Domain: "some-domain.dedyn.io", Subname: "alias", Status: "Synced", Message: "CNAME set to: [www.some-domain.dedyn.io.]"},
+			{DNSName: "www.example.com", RecordType: "A", Status: "Unmanaged", Message: "Not within any managed domain"},
+		}, getEndpointStatus(t, endpoint))
+		observedGeneration, _, _ := unstructured.NestedInt64(endpoint.Object, "status", "observedGeneration")
+		assert.Equal(t, int64(1), observedGeneration)
+
+		// When
+		bulkRequests := mock.bulkRequests
+		resourceVersion := endpoint.GetResourceVersion()
+		reconcileDNSEndpoint(t, &reconciler)
+		// Then
+		assert.Equal(t, bulkRequests, mock.bulkRequests)
+		assert.Equal(t, resourceVersion, getDNSEndpoint(t, reconciler).GetResourceVersion())
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		// Given
+		mock := &desecMock{invalid: map[string]desec.FieldErrors{"bad": {"records": []any{[]any{"Invalid record."}}}}}
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := createDNSEndpointReconciler(t, server.URL,
+			map[string]any{"dnsName": "bad.some-domain.dedyn.io", "recordType": "A", "targets": []any{"invalid"}},
+			map[string]any{"dnsName": "www.some-domain.dedyn.io", "recordType": "A", "targets": []any{"1.2.3.4"}},
+		)
+		// When
+		reconcileDNSEndpoint(t, &reconciler)
+		// Then
+		assert.Len(t, mock.rrsets, 1)
+		statuses := getEndpointStatus(t, getDNSEndpoint(t, reconciler))
+		assert.Len(t, statuses, 2)
+		assert.Equal(t, "Invalid", statuses[0].Status)
+		assert.Equal(t, "Synced", statuses[1].Status)
+
+		// When
+		bulkRequests := mock.bulkRequests
+		reconcileDNSEndpoint(t, &reconciler)
+		// Then the rejected endpoint is not retried
+		assert.Equal(t, bulkRequests, mock.bulkRequests)
+		assert.Equal(t, statuses, getEndpointStatus(t, getDNSEndpoint(t, reconciler)))
+	})
+
+	t.Run("Failed endpoint meets existing RRSet", func(t *testing.T) {
+		// Given
+		mock := &desecMock{invalid: map[string]desec.FieldErrors{"www": {"records": []any{[]any{"Invalid record."}}}}}
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := createDNSEndpointReconciler(t, server.URL,
+			map[string]any{"dnsName": "www.some-domain.dedyn.io", "recordType": "A", "targets": []any{"invalid"}},
+		)
+		assert.NoError(t, os.WriteFile(reconciler.ConfigDir+"/config/adoptionPolicy", []byte("Create"), fs.ModePerm))
+		reconcileDNSEndpoint(t, &reconciler)
+		assert.Equal(t, "Invalid", getEndpointStatus(t, getDNSEndpoint(t, reconciler))[0].Status)
+		// When somebody else creates the RRSet, and the endpoint is fixed
+		mock.invalid = nil
+		mock.rrsets = []desec.RRSet{{Domain: "some-domain.dedyn.io", Subname: "www", Type: "A", Records: []string{"5.6.7.8"}, TTL: 3600}}
+		endpoint := getDNSEndpoint(t, reconciler)
+		assert.NoError(t, unstructured.SetNestedSlice(endpoint.Object, []any{
+			map[string]any{"dnsName": "www.some-domain.dedyn.io", "recordType": "A", "targets": []any{"1.2.3.4"}},
+		}, "spec", "endpoints"))
+		endpoint.SetGeneration(2)
+		assert.NoError(t, reconciler.Update(context.TODO(), endpoint))
+		reconcileDNSEndpoint(t, &reconciler)
+		// Then
+		assert.Equal(t, []string{"5.6.7.8"}, findRRSet(mock.rrsets, "www", "A").Records)
+		assert.Equal(t, "AlreadyExists", getEndpointStatus(t, getDNSEndpoint(t, reconciler))[0].Status)
+
+		// When
+		assert.NoError(t, reconciler.Delete(context.TODO(), getDNSEndpoint(t, reconciler)))
+		reconcileDNSEndpoint(t, &reconciler)
+		// Then the RRSet of somebody else is left alone
+		assert.Len(t, mock.rrsets, 1)
+	})
+
+	t.Run("Dry-run", func(t *testing.T) {
+		// Given
+		mock := &desecMock{domains: []desec.Domain{{Name: "some-domain.dedyn.io"}}}
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := createDNSEndpointReconciler(t, server.URL,
+			map[string]any{"dnsName": "www.some-domain.dedyn.io", "recordType": "A", "targets": []any{"1.2.3.4"}},
+		)
+		reconciler.DryRun = true
+		recorder := events.NewFakeRecorder(10)
+		reconciler.Recorder = recorder
+		// When
+		reconcileDNSEndpoint(t, &reconciler)
+		// Then
+		assert.Empty(t, mock.rrsets)
+		endpoint := getDNSEndpoint(t, reconciler)
+		assert.Equal(t, `["set www/A to [1.2.3.4] in some-domain.dedyn.io"]`, endpoint.GetAnnotations()[plannedChangesAnnotation])
+		assert.Equal(t, "Normal DryRun Would set www/A to [1.2.3.4] in some-domain.dedyn.io", <-recorder.Events)
+
+		// When dry-run is turned off
+		reconciler.DryRun = false
+		reconcileDNSEndpoint(t, &reconciler)
+		// Then
+		assert.Len(t, mock.rrsets, 1)
+		assert.NotContains(t, getDNSEndpoint(t, reconciler).GetAnnotations(), plannedChangesAnnotation)
+	})
+
+	t.Run("Duplicates", func(t *testing.T) {
+		// Given
+		mock := &desecMock{domains: []desec.Domain{{Name: "some-domain.dedyn.io"}}}
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := createDNSEndpointReconciler(t, server.URL,
+			map[string]any{"dnsName": "www.some-domain.dedyn.io", "recordType": "A", "targets": []any{"1.2.3.4"}},
+			map[string]any{"dnsName": "www.some-domain.dedyn.io.", "recordType": "A", "targets": []any{"5.6.7.8", "1.2.3.4"}, "recordTTL": int64(7200)},
+		)
+		// When
+		reconcileDNSEndpoint(t, &reconciler)
+		// Then
+		assert.Len(t, mock.rrsets, 1)
+		assert.Equal(t, []string{"1.2.3.4", "5.6.7.8"}, mock.rrsets[0].Records)
+		assert.Equal(t, int64(7200), mock.rrsets[0].TTL)
+		assert.Equal(t, []endpointStatus{
+			{DNSName: "www.some-domain.dedyn.io", RecordType: "A", Domain: "some-domain.dedyn.io", Subname: "www", Status: "Synced", Message: "A set to: [1.2.3.4 5.6.7.8]"},
+		}, getEndpointStatus(t, getDNSEndpoint(t, reconciler)))
+	})
+
+	t.Run("Adoption policy", func(t *testing.T) {
+		for _, policy := range []string{"Create", "Observe"} {
+			t.Run(policy, func(t *testing.T) {
+				// Given
+				mock := &desecMock{
+					domains: []desec.Domain{{Name: "some-domain.dedyn.io"}},
+					rrsets: []desec.RRSet{
+						{Domain: "some-domain.dedyn.io", Subname: "www", Type: "A", Records: []string{"5.6.7.8"}, TTL: 3600},
+						{Domain: "some-domain.dedyn.io", Subname: "git", Type: "A", Records: []string{"1.2.3.4"}, TTL: 3600},
+					},
+				}
+				server := createDesecServer(t, mock)
+				defer server.Close()
+				reconciler := createDNSEndpointReconciler(t, server.URL,
+					map[string]any{"dnsName": "www.some-domain.dedyn.io", "recordType": "A", "targets": []any{"1.2.3.4"}},
+					map[string]any{"dnsName": "git.some-domain.dedyn.io", "recordType": "A", "targets": []any{"1.2.3.4"}},
+				)
+				assert.NoError(t, os.WriteFile(reconciler.ConfigDir+"/config/adoptionPolicy", []byte(policy), fs.ModePerm))
+				// When
+				reconcileDNSEndpoint(t, &reconciler)
+				// Then
+				assert.Equal(t, []string{"5.6.7.8"}, findRRSet(mock.rrsets, "www", "A").Records)
+				statuses := getEndpointStatus(t, getDNSEndpoint(t, reconciler))
+				if policy == "Create" {
+					assert.Equal(t, "AlreadyExists", statuses[0].Status)
+					assert.Equal(t, "The A RRSet existed before and adoptionPolicy is Create", statuses[0].Message)
+					assert.Equal(t, "AlreadyExists", statuses[1].Status)
+				} else {
+					assert.Equal(t, "Drift", statuses[0].Status)
+					assert.Equal(t, "Would set www/A to [1.2.3.4]", statuses[0].Message)
+					assert.Equal(t, "InSync", statuses[1].Status)
+				}
+
+				// When
+				assert.NoError(t, reconciler.Delete(context.TODO(), getDNSEndpoint(t, reconciler)))
+				reconcileDNSEndpoint(t, &reconciler)
+				// Then the RRSets of others are left alone
+				assert.Len(t, mock.rrsets, 2)
+			})
+		}
+	})
+
+	t.Run("Registry", func(t *testing.T) {
+		// Given
+		mock := &desecMock{
+			domains: []desec.Domain{{Name: "some-domain.dedyn.io"}},
+			rrsets: []desec.RRSet{
+				{Domain: "some-domain.dedyn.io", Subname: "git", Type: "A", Records: []string{"5.6.7.8"}, TTL: 3600},
+				{Domain: "some-domain.dedyn.io", Subname: "_desec-owner.a.git", Type: "TXT", Records: []string{`"heritage=desec-dns-operator,owner=other,resource=ingress/default/git,version=1"`}, TTL: 3600},
+			},
+		}
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := createDNSEndpointReconciler(t, server.URL,
+			map[string]any{"dnsName": "www.some-domain.dedyn.io", "recordType": "A", "targets": []any{"1.2.3.4"}},
+			map[string]any{"dnsName": "git.some-domain.dedyn.io", "recordType": "A", "targets": []any{"1.2.3.4"}},
+		)
+		reconciler.OwnerID = "my-cluster"
+		// When
+		reconcileDNSEndpoint(t, &reconciler)
+		// Then the owner is recorded in the same request
+		assert.Equal(t, 1, mock.bulkRequests)
+		assert.Equal(t, []string{"1.2.3.4"}, findRRSet(mock.rrsets, "www", "A").Records)
+		assert.Equal(t, []string{`"heritage=desec-dns-operator,owner=my-cluster,resource=dnsendpoint/some-namespace/some-endpoint,version=1"`}, findRRSet(mock.rrsets, "_desec-owner.a.www", "TXT").Records)
+		assert.Equal(t, []string{"5.6.7.8"}, findRRSet(mock.rrsets, "git", "A").Records)
+		statuses := getEndpointStatus(t, getDNSEndpoint(t, reconciler))
+		assert.Equal(t, "Synced", statuses[0].Status)
+		assert.Equal(t, endpointStatus{
+			DNSName: "git.some-domain.dedyn.io", RecordType: "A", Domain: "some-domain.dedyn.io", Subname: "git", Status: "NotOwned", Message: "A owned by other (ingress/default/git)",
+		}, statuses[1])
+
+		// When
+		assert.NoError(t, reconciler.Delete(context.TODO(), getDNSEndpoint(t, reconciler)))
+		reconcileDNSEndpoint(t, &reconciler)
+		// Then the RRSets of others are left alone
+		assert.Nil(t, findRRSet(mock.rrsets, "www", "A"))
+		assert.Nil(t, findRRSet(mock.rrsets, "_desec-owner.a.www", "TXT"))
+		assert.Len(t, mock.rrsets, 2)
+	})
+
+	t.Run("Cleanup", func(t *testing.T) {
+		// Given
+		mock := new(desecMock)
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := createDNSEndpointReconciler(t, server.URL,
+			map[string]any{"dnsName": "www.some-domain.dedyn.io", "recordType": "A", "targets": []any{"1.2.3.4"}},
+			map[string]any{"dnsName": "git.some-domain.dedyn.io", "recordType": "A", "targets": []any{"1.2.3.4"}},
+		)
+		reconcileDNSEndpoint(t, &reconciler)
+		assert.Len(t, mock.rrsets, 2)
+		// When
+		endpoint := getDNSEndpoint(t, reconciler)
+		endpoints, _, _ := unstructured.NestedSlice(endpoint.Object, "spec", "endpoints")
+		assert.NoError(t, unstructured.SetNestedSlice(endpoint.Object, endpoints[:1], "spec", "endpoints"))
+		assert.NoError(t, reconciler.Update(context.TODO(), endpoint))
+		reconcileDNSEndpoint(t, &reconciler)
+		// Then
+		assert.Len(t, mock.rrsets, 1)
+		assert.Equal(t, "www", mock.rrsets[0].Subname)
+
+		// When
+		assert.NoError(t, reconciler.Delete(context.TODO(), getDNSEndpoint(t, reconciler)))
+		reconcileDNSEndpoint(t, &reconciler)
+		// Then
+		assert.Empty(t, mock.rrsets)
+		assert.EqualError(t, reconciler.Get(context.TODO(), dnsEndpointRequest.NamespacedName, newDNSEndpoint()), `dnsendpoints.externaldns.k8s.io "some-endpoint" not found`)
+	})
+}
+
+func createDNSEndpointReconciler(t *testing.T, serverUrl string, endpoints ...any) DNSEndpointReconciler {
+	disableRateLimits(t)
+	mockScheme := runtime.NewScheme()
+	assert.NoError(t, v1.AddToScheme(mockScheme))
+	assert.NoError(t, corev1.AddToScheme(mockScheme))
+	mockScheme.AddKnownTypeWithName(dnsEndpointGVK, new(unstructured.Unstructured))
+	mockScheme.AddKnownTypeWithName(dnsEndpointGVK.GroupVersion().WithKind("DNSEndpointList"), new(unstructured.UnstructuredList))
+
+	endpoint := newDNSEndpoint()
+	endpoint.SetName(dnsEndpointRequest.Name)
+	endpoint.SetNamespace(dnsEndpointRequest.Namespace)
+	endpoint.SetGeneration(1)
+	assert.NoError(t, unstructured.SetNestedSlice(endpoint.Object, endpoints, "spec", "endpoints"))
+
+	fakeClient := fake.NewClientBuilder().
+		WithScheme(mockScheme).
+		WithObjects(endpoint).
+		WithStatusSubresource(newDNSEndpoint()).
+		Build()
+
+	return DNSEndpointReconciler{
+		Client:    fakeClient,
+		Scheme:    mockScheme,
+		ConfigDir: util.CreateConfigDir(t, serverUrl),
+	}
+}
+
+// reconcileDNSEndpoint reconciles until no further step is requested, and
+// returns the final result.
+func reconcileDNSEndpoint(t *testing.T, reconciler *DNSEndpointReconciler) reconcile.Result {
+	for {
+		result, err := reconciler.Reconcile(context.TODO(), dnsEndpointRequest)
+		assert.NoError(t, err)
+		if err != nil || result.RequeueAfter != 100*time.Millisecond {
+			return result
+		}
+	}
+}
+
+func getDNSEndpoint(t *testing.T, reconciler DNSEndpointReconciler) *unstructured.Unstructured {
+	endpoint := newDNSEndpoint()
+	assert.NoError(t, reconciler.Get(context.TODO(), dnsEndpointRequest.NamespacedName, endpoint))
+	return endpoint
+}
+
+func getEndpointStatus(t *testing.T, endpoint *unstructured.Unstructured) []endpointStatus {
+	statuses := []endpointStatus{}
+	assert.NoError(t, json.Unmarshal([]byte(endpoint.GetAnnotations()[endpointStatusAnnotation]), &statuses))
+	return statuses
+}
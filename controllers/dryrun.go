@@ -0,0 +1,91 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/events"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/j-be/desec-dns-operator/controllers/desec"
+)
+
+// dryRunAnnotation makes the operator only plan the changes to deSEC for the
+// annotated object, instead of applying them.
+const dryRunAnnotation = "desec.owly.dedyn.io/dry-run"
+
+// plannedChangesAnnotation holds the planned changes of a DNSEndpoint, as its
+// status has no room for them.
+const plannedChangesAnnotation = "desec.owly.dedyn.io/planned-changes"
+
+// ipChangePrefix starts the planned update of the IPs of a domain. Those are
+// planned by the DesecDns reconciler, all other changes of the domain by the
+// ingress reconciler.
+const ipChangePrefix = "update the IPs"
+
+// isAnnotatedDryRun reports whether the object asks for a dry-run.
+func isAnnotatedDryRun(obj client.Object) bool {
+	return obj.GetAnnotations()[dryRunAnnotation] == "true"
+}
+
+// describeChange returns the change of an RRSet as planned change, e.g.
+// set www/CNAME to [some-domain.dedyn.io.] or remove www/CNAME.
+func describeChange(rrset desec.RRSet) string {
+	if len(rrset.Records) == 0 {
+		return "remove " + nameOf(rrset.Subname, rrset.Type)
+	}
+	return fmt.Sprintf("set %s to %v", nameOf(rrset.Subname, rrset.Type), rrset.Records)
+}
+
+func isIPChange(change string) bool {
+	return strings.HasPrefix(change, ipChangePrefix)
+}
+
+func isRRSetChange(change string) bool {
+	return !isIPChange(change)
+}
+
+func isAnyChange(string) bool {
+	return true
+}
+
+// plan replaces the planned changes matching owned by the given ones, and
+// publishes those not planned before as events of the object. It returns
+// true if the planned changes changed.
+func plan(recorder events.EventRecorder, obj runtime.Object, plannedChanges *[]string, owned func(string) bool, planned []string) bool {
+	changes := slices.DeleteFunc(slices.Clone(*plannedChanges), owned)
+	for _, change := range planned {
+		if recorder != nil && !slices.Contains(*plannedChanges, change) {
+			recorder.Eventf(obj, nil, corev1.EventTypeNormal, "DryRun", "Plan", "Would %s", change)
+		}
+	}
+	changes = append(changes, planned...)
+	slices.Sort(changes)
+	if len(changes) == 0 {
+		changes = nil
+	}
+	if slices.Equal(changes, *plannedChanges) {
+		return false
+	}
+	*plannedChanges = changes
+	return true
+}
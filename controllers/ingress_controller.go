@@ -17,17 +17,33 @@ limitations under the License.
 package controllers
 
 import (
+	"cmp"
 	"context"
+	"errors"
+	"fmt"
+	"maps"
 	"slices"
+	"strings"
 	"time"
 
+	corev1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/events"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	v1 "github.com/j-be/desec-dns-operator/api/v1"
 	"github.com/j-be/desec-dns-operator/controllers/config"
@@ -35,130 +51,1066 @@ import (
 	"github.com/j-be/desec-dns-operator/controllers/util"
 )
 
+const ingressFinalizer = "desec.owly.dedyn.io/cnames"
+
+// accountAnnotation restricts the domains hosts of an ingress are routed to,
+// to the ones of the given DesecAccount.
+const accountAnnotation = "desec.owly.dedyn.io/account"
+
+// domainsAnnotation holds the comma separated domains a source published its
+// hosts in, to clean up after hosts moved to another domain.
+const domainsAnnotation = "desec.owly.dedyn.io/domains"
+
 // IngressReconciler reconciles a DesecDns object
 type IngressReconciler struct {
 	client.Client
 	Scheme    *runtime.Scheme
 	ConfigDir string
+	// Options for the deSEC clients created while reconciling
+	ClientOptions []desec.Option
+	// Resolver for the hostnames of load balancers, defaults to the one
+	// configured
+	Resolver util.Resolver
+	// Routes are the kinds of Gateway API routes hosts are taken from, in
+	// addition to ingresses
+	Routes []schema.GroupVersionKind
+	// NodeAddressType is the type of the addresses of nodes, e.g. ExternalIP,
+	// published as IPs of all domains. Nodes are not used if empty.
+	NodeAddressType corev1.NodeAddressType
+	// NodeSelector restricts the nodes whose addresses are published, all
+	// nodes are used if nil
+	NodeSelector labels.Selector
+	// OwnerID enables the registry, recording this operator as the owner of
+	// each RRSet it publishes. RRSets of others are never touched then.
+	OwnerID string
+	// Recorder for events regarding sources, none are emitted if nil
+	Recorder events.EventRecorder
+	// DryRun only plans the changes to deSEC of all domains, instead of
+	// applying them
+	DryRun bool
 }
 
 //+kubebuilder:rbac:groups=desec.owly.dedyn.io,resources=desecdnsdnses,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=desec.owly.dedyn.io,resources=desecdnsdnses/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=desec.owly.dedyn.io,resources=desecdnsdnses/finalizers,verbs=update
-//+kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch
+//+kubebuilder:rbac:groups=desec.owly.dedyn.io,resources=desecdomains,verbs=get;list;watch
+//+kubebuilder:rbac:groups=desec.owly.dedyn.io,resources=desecaccounts,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses/finalizers,verbs=update
 //+kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses/status,verbs=get
+//+kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+//+kubebuilder:rbac:groups=events.k8s.io,resources=events,verbs=create;patch
 
-// Reconcile is part of the main kubernetes reconciliation loop which aims to
-// move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the DesecDns object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
-//
-// For more details, check Reconcile and its Result here:
-// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.14.1/pkg/reconcile
+// Reconcile publishes the hosts of an Ingress in the domains managed by the
+// operator on deSEC.
 func (r *IngressReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	return r.reconcileSource(ctx, req, new(networkingv1.Ingress))
+}
+
+// reconcileSource publishes the hosts of the source, e.g. an ingress, along
+// with the ones of all other sources in the same domains.
+func (r *IngressReconciler) reconcileSource(ctx context.Context, req ctrl.Request, source client.Object) (result ctrl.Result, err error) {
+	defer func() { result, err = requeueIfThrottled(ctx, result, err) }()
 	log := log.FromContext(ctx)
 
 	log.Info("Starting", "req", req)
 
-	// Create deSEC client
+	// Fetch source, it is gone for good once its finalizer was released
+	if err := r.Get(ctx, req.NamespacedName, source); err != nil {
+		if apierrors.IsNotFound(err) {
+			log.Info("Source not found, not doing anything", "req", req)
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to load source", "req", req)
+		return ctrl.Result{}, err
+	}
+
 	desecConfig, err := config.NewConfigFor(r.ConfigDir)
 	if err != nil {
 		log.Error(err, "Failed to read the configuration")
 		return ctrl.Result{}, err
 	}
-	desecClient, err := desec.NewClient(desecConfig.Domain, r.ConfigDir)
+	managedDomains, err := getManagedDomains(ctx, r.Client, desecConfig)
 	if err != nil {
-		log.Error(err, "Cannot create client")
+		log.Error(err, "Failed to list domains")
+		return ctrl.Result{}, err
+	}
+
+	deleting := !source.GetDeletionTimestamp().IsZero()
+	hasSubnames := false
+	domainNames := domainsFor(source, managedDomains)
+	for _, domain := range domainNames {
+		hasSubnames = hasSubnames || len(util.GetSubnames(hostsOf(source), domain, domainNames...)) > 0
+	}
+
+	// Make sure CNAMEs get cleaned up once the source is deleted
+	if !deleting && hasSubnames && controllerutil.AddFinalizer(source, ingressFinalizer) {
+		err := r.Update(ctx, source)
+		return ctrl.Result{Requeue: true, RequeueAfter: 100 * time.Millisecond}, err
+	}
+
+	// Only the domains the source publishes in are affected, along with the
+	// ones it published in before, to clean up after hosts which moved
+	published := publishedDomains(source, managedDomains)
+	result, err = r.syncDomains(ctx, desecConfig, managedDomains, append(previousDomains(source), published...))
+	if err != nil || result.Requeue {
+		return result, err
+	}
+
+	// Release the source once all of its CNAMEs are gone
+	if deleting && controllerutil.RemoveFinalizer(source, ingressFinalizer) {
+		err := r.Update(ctx, source)
 		return ctrl.Result{}, err
 	}
+	if !deleting && !slices.Equal(previousDomains(source), published) {
+		setPreviousDomains(source, published)
+		if err := r.Update(ctx, source); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	return result, nil
+}
+
+// syncDomains prepares and syncs the managed domains of the given names.
+func (r *IngressReconciler) syncDomains(ctx context.Context, desecConfig config.Config, managedDomains []managedDomain, names []string) (ctrl.Result, error) {
+	// Steps ask to be requeued right away and are returned at once, anything
+	// else is a periodic check of a domain, which must not hold up the others
+	periodic := ctrl.Result{}
+	readyDomains := []readyDomain{}
+	for _, domain := range managedDomains {
+		if !slices.Contains(names, domain.name) {
+			continue
+		}
+		ready, result, err := r.prepareDomain(ctx, desecConfig, domain)
+		if err != nil || result.Requeue {
+			return result, err
+		}
+		periodic = earliestRequeue(periodic, result)
+		if ready != nil {
+			readyDomains = append(readyDomains, *ready)
+		}
+	}
+
+	resolver := r.Resolver
+	if resolver == nil {
+		resolver = util.NewResolver(desecConfig.Resolver)
+	}
+	for _, domain := range readyDomains {
+		result, err := r.syncDomain(ctx, desecConfig, domain, managedDomains, resolver)
+		if err != nil || result.Requeue {
+			return result, err
+		}
+		periodic = earliestRequeue(periodic, result)
+	}
+	return periodic, nil
+}
+
+// readyDomain is a managed domain, which exists on deSEC.
+type readyDomain struct {
+	managedDomain
+	desecClient desec.Client
+	dnsCr       *v1.DesecDns
+}
+
+// prepareDomain makes sure the DesecDns of the domain is initialized, and the
+// domain exists on deSEC. The returned domain is nil if it is not ready yet.
+func (r *IngressReconciler) prepareDomain(ctx context.Context, desecConfig config.Config, domain managedDomain) (*readyDomain, ctrl.Result, error) {
+	log := log.FromContext(ctx).WithValues("domain", domain.name)
 
 	// Fetch or create CR
 	dnsCr := new(v1.DesecDns)
-	if err := r.Get(ctx, desecConfig.GetNamespacedName(), dnsCr); err != nil {
-		if !errors.IsNotFound(err) {
-			log.Error(err, "Failed to load CR", "req", req)
-			return ctrl.Result{}, err
+	if err := r.Get(ctx, desecConfig.NamespacedNameFor(domain.name), dnsCr); err != nil {
+		if !apierrors.IsNotFound(err) {
+			log.Error(err, "Failed to load CR")
+			return nil, ctrl.Result{}, err
 		}
 		// Initialize
-		dnsCr = util.InitializeDesecDns(desecConfig.GetNamespacedName())
+		dnsCr = util.InitializeDesecDns(desecConfig.NamespacedNameFor(domain.name))
+		dnsCr.Spec.Account = domain.account
+		dnsCr.Spec.AdoptionPolicy = domain.adoptionPolicy
 		err := r.Create(ctx, dnsCr)
-		return ctrl.Result{Requeue: true, RequeueAfter: 100 * time.Millisecond}, err
+		return nil, ctrl.Result{Requeue: true, RequeueAfter: 100 * time.Millisecond}, err
+	}
+
+	// Leave the domain alone until the DesecDns is gone, it is initialized
+	// again afterwards
+	if !dnsCr.DeletionTimestamp.IsZero() {
+		log.Info("DesecDns is being deleted, waiting")
+		return nil, ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+	}
+
+	// The account and adoption policy default to the ones of the domain, but
+	// are left alone once set
+	if (dnsCr.Spec.Account == "" && domain.account != "") || (dnsCr.Spec.AdoptionPolicy == "" && domain.adoptionPolicy != "") {
+		dnsCr.Spec.Account = cmp.Or(dnsCr.Spec.Account, domain.account)
+		dnsCr.Spec.AdoptionPolicy = cmp.Or(dnsCr.Spec.AdoptionPolicy, domain.adoptionPolicy)
+		err := r.Update(ctx, dnsCr)
+		return nil, ctrl.Result{Requeue: true, RequeueAfter: 100 * time.Millisecond}, err
+	}
+	domain.account = dnsCr.Spec.Account
+	domain.adoptionPolicy = dnsCr.Spec.AdoptionPolicy
+
+	// Create deSEC client
+	desecClient, err := newDesecClient(ctx, r.Client, r.ConfigDir, domain.account, dnsCr.Namespace, domain.name, r.ClientOptions...)
+	if err != nil {
+		log.Error(err, "Cannot create client")
+		return nil, ctrl.Result{}, err
 	}
 
 	// Initialize status
 	if len(dnsCr.Status.Conditions) == 0 {
 		dnsCr.Status = util.InitializeDesecDnsStatus()
 		err := r.Status().Update(ctx, dnsCr)
-		return ctrl.Result{Requeue: true, RequeueAfter: 100 * time.Millisecond}, err
+		return nil, ctrl.Result{Requeue: true, RequeueAfter: 100 * time.Millisecond}, err
 	}
 
 	// Make sure domain exists
-	domains, err := desecClient.GetDomains()
+	domains, err := desecClient.GetDomains(ctx)
 	if err != nil {
 		log.Error(err, "Failed to fetch domains")
-		return ctrl.Result{}, err
+		return nil, ctrl.Result{}, err
 	}
-	if !slices.ContainsFunc(domains, func(domain desec.Domain) bool { return domain.Name == desecClient.Domain }) {
-		if util.UpdateDesecDnsStatus(&dnsCr.Status, "Domain", metav1.ConditionFalse, "Creating", "") {
+	index := slices.IndexFunc(domains, func(domain desec.Domain) bool { return domain.Name == desecClient.Domain })
+	if index < 0 {
+		if domain.creationPolicy == v1.CreationPolicyNever || domain.adoptionPolicy == v1.AdoptionPolicyObserve {
+			message := "The domain does not exist and creationPolicy is Never"
+			if domain.adoptionPolicy == v1.AdoptionPolicyObserve {
+				message = "The domain does not exist and adoptionPolicy is Observe"
+			}
+			if util.UpdateDesecDnsStatus(&dnsCr.Status, "Domain", metav1.ConditionFalse, "NotFound", message) {
+				if err := r.Status().Update(ctx, dnsCr); err != nil {
+					return nil, ctrl.Result{}, err
+				}
+			}
+			return nil, ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+		}
+		if r.isDryRun(domain, dnsCr) {
+			statusUpdate := util.UpdateDesecDnsStatus(&dnsCr.Status, "Domain", metav1.ConditionFalse, "DryRun", "The domain does not exist yet")
+			statusUpdate = plan(r.Recorder, dnsCr, &dnsCr.Status.PlannedChanges, isRRSetChange, []string{"create the domain"}) || statusUpdate
+			if statusUpdate {
+				if err := r.Status().Update(ctx, dnsCr); err != nil {
+					return nil, ctrl.Result{}, err
+				}
+			}
+			return nil, ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+		}
+		// Remember the domain can be deleted along with the DesecDns before
+		// creating it, so it is still recognised as ours if reporting its
+		// creation fails
+		dnsCr.Status.CreatedDomain = true
+		util.UpdateDesecDnsStatus(&dnsCr.Status, "Domain", metav1.ConditionFalse, "Creating", "")
+		if err := r.Status().Update(ctx, dnsCr); err != nil {
+			return nil, ctrl.Result{}, err
+		}
+		_, err := createDomain(ctx, desecClient)
+		if desec.IsConflict(err) || desec.IsInvalid(err) {
+			// Retrying won't help, e.g. the domain is owned by somebody else
+			log.Error(err, "Cannot create domain")
+			dnsCr.Status.CreatedDomain = false
+			util.UpdateDesecDnsStatus(&dnsCr.Status, "Domain", metav1.ConditionFalse, "Rejected", err.Error())
 			if err := r.Status().Update(ctx, dnsCr); err != nil {
-				return ctrl.Result{}, err
+				return nil, ctrl.Result{}, err
 			}
+			return nil, ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
 		}
-		_, err := desecClient.CreateDomain()
-		return ctrl.Result{Requeue: true, RequeueAfter: 100 * time.Millisecond}, err
+		if err != nil {
+			return nil, ctrl.Result{}, err
+		}
+		return nil, ctrl.Result{Requeue: true, RequeueAfter: 100 * time.Millisecond}, nil
 	}
-	if util.UpdateDesecDnsStatus(&dnsCr.Status, "Domain", metav1.ConditionTrue, "Created", "") {
+	if domain.adoptionPolicy == v1.AdoptionPolicyCreate && !dnsCr.Status.CreatedDomain && !domain.createdDomain {
+		if util.UpdateDesecDnsStatus(&dnsCr.Status, "Domain", metav1.ConditionFalse, "AlreadyExists", "The domain existed before and adoptionPolicy is Create") {
+			if err := r.Status().Update(ctx, dnsCr); err != nil {
+				return nil, ctrl.Result{}, err
+			}
+		}
+		return nil, ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+	}
+	statusUpdate := util.UpdateDesecDnsStatus(&dnsCr.Status, "Domain", metav1.ConditionTrue, "Created", "")
+	if domain.createdDomain && !dnsCr.Status.CreatedDomain {
+		// Created by the DesecDomain, so it can be deleted along with the
+		// DesecDns just as well
+		dnsCr.Status.CreatedDomain = true
+		statusUpdate = true
+	}
+	if statusUpdate {
 		err := r.Status().Update(ctx, dnsCr)
-		return ctrl.Result{Requeue: true, RequeueAfter: 100 * time.Millisecond}, err
+		return nil, ctrl.Result{Requeue: true, RequeueAfter: 100 * time.Millisecond}, err
 	}
 
-	// Fetch ingress
-	ingress := networkingv1.Ingress{}
-	if err := r.Get(ctx, req.NamespacedName, &ingress); err != nil {
-		log.Error(err, "Failed to load ingress", "req", req)
+	return &readyDomain{managedDomain: domain, desecClient: desecClient, dnsCr: dnsCr}, ctrl.Result{}, nil
+}
+
+// syncDomain brings the IPs and CNAMEs of the domain in line with what all
+// managed sources, like ingresses, ask for.
+func (r *IngressReconciler) syncDomain(ctx context.Context, desecConfig config.Config, domain readyDomain, managedDomains []managedDomain, resolver util.Resolver) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+	desecClient := domain.desecClient
+	dnsCr := domain.dnsCr
+
+	// Collect what all managed sources ask for
+	desired, err := r.getDesiredState(ctx, domain.name, managedDomains, desecConfig.HostnameMode, resolver)
+	if err != nil {
+		log.Error(err, "Failed to collect the desired state")
 		return ctrl.Result{}, err
 	}
+	subnames := desired.subnames
+	if desired.keepIPs {
+		for _, ip := range dnsCr.Spec.IPs {
+			if !slices.Contains(desired.ips, ip) {
+				desired.ips = append(desired.ips, ip)
+			}
+		}
+		slices.Sort(desired.ips)
+	}
 
 	// Make sure all IPs are in Spec
-	ips := util.GetIps(ingress)
-	slices.Sort(ips)
-	if !slices.Equal(ips, dnsCr.Spec.IPs) {
-		dnsCr.Spec.IPs = ips
+	if !slices.Equal(desired.ips, dnsCr.Spec.IPs) {
+		dnsCr.Spec.IPs = desired.ips
 		err := r.Update(ctx, dnsCr)
 		return ctrl.Result{Requeue: true, RequeueAfter: 100 * time.Millisecond}, err
 	}
 
-	// Add missing CNAMES
-	rrsets, _ := desecClient.GetRRSets()
-	for _, subname := range util.GetSubnames(ingress, desecClient.Domain) {
-		if !slices.ContainsFunc(rrsets, func(rrset desec.RRSet) bool { return rrset.Type == "CNAME" && rrset.Subname == subname }) {
-			log.Info("Adding CNAME", "subname", subname, "domain", desecClient.Domain)
-			if util.UpdateDesecDnsStatus(&dnsCr.Status, subname, metav1.ConditionFalse, "Creating", "") {
-				if err := r.Status().Update(ctx, dnsCr); err != nil {
-					return ctrl.Result{}, err
+	// The whole zone is needed, as the RRSets of all hosts, their TXT records,
+	// blocking RRSets of other types and obsolete ones are compared. Listing
+	// it takes a request per page, instead of several per host.
+	rrsets, err := desecClient.GetRRSets(ctx)
+	if desec.IsNotFound(err) {
+		// The domain vanished in the meantime, start over with backoff
+		if util.UpdateDesecDnsStatus(&dnsCr.Status, "Domain", metav1.ConditionFalse, "NotFound", "") {
+			if err := r.Status().Update(ctx, dnsCr); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, err
+	}
+	if err != nil {
+		log.Error(err, "Failed to fetch RRSets")
+		return ctrl.Result{}, err
+	}
+
+	// Apply all missing and obsolete records at once. Each change is reported
+	// by the condition of the subname of its host. The TXT records of the
+	// registry are not reported.
+	reg := registry{ownerID: r.OwnerID, rrsets: rrsets}
+	policy := domain.adoptionPolicy
+	// Nothing is tracked, nor adopted, while observing
+	trackedSRVs, adopted := slices.Clone(dnsCr.Status.SRVSubnames), slices.Clone(dnsCr.Status.Adopted)
+	changes := []desec.RRSet{}
+	changeConditions := []string{}
+	// Changes deSEC rejected before are not retried, until the sources ask
+	// for something else
+	rejected, rejectedConditions := []string{}, []string{}
+	conflicts, statusChanged := false, false
+	// block reports the subname as not published, and warns its source once
+	block := func(subname string, reason string, message string) {
+		conflicts = true
+		if util.UpdateDesecDnsStatus(&dnsCr.Status, subname, metav1.ConditionFalse, reason, message) {
+			log.Info("Not publishing subname", "subname", subname, "domain", desecClient.Domain, "reason", reason, "message", message)
+			r.warn(desired.sources[subname], reason, "Publish", fmt.Sprintf("Not publishing %s in %s: %s", subname, desecClient.Domain, message))
+			statusChanged = true
+		}
+	}
+	for _, subname := range subnames {
+		condition := util.FindSubnameCondition(dnsCr.Status, subname)
+		invalid := condition != nil && condition.Reason == "Invalid"
+		if message, ok := desired.unresolved[subname]; ok {
+			if util.UpdateDesecDnsStatus(&dnsCr.Status, subname, metav1.ConditionFalse, "ResolveFailed", message) {
+				r.warn(desired.sources[subname], "ResolveFailed", "Publish", fmt.Sprintf("Keeping %s in %s as is: %s", subname, desecClient.Domain, message))
+				statusChanged = true
+			}
+			continue
+		}
+		// Records tracked before the registry was enabled are owned as well
+		tracked := isPublished(dnsCr.Status, subname)
+		if blocking := getConflicts(reg, subname, tracked || policy == v1.AdoptionPolicyAdopt); len(blocking) > 0 {
+			block(subname, "NotOwned", strings.Join(blocking, ", "))
+			continue
+		}
+		preexisting := []string{}
+		if !tracked {
+			preexisting = getPreexistingTypes(reg, subname)
+		}
+		if policy == v1.AdoptionPolicyCreate && len(preexisting) > 0 {
+			block(subname, "AlreadyExists", fmt.Sprintf("The %s RRSets existed before and adoptionPolicy is Create", strings.Join(preexisting, ", ")))
+			continue
+		}
+		wanted := getDesiredRRSets(desecClient, domain, desired, subname)
+		if _, ok := wanted["CNAME"]; ok {
+			// deSEC rejects a CNAME along with any other RRSet of the subname
+			if blocking := getBlockingTypes(reg, subname, tracked); len(blocking) > 0 {
+				block(subname, "Conflict", fmt.Sprintf("A CNAME cannot coexist with the %s RRSets of the subname", strings.Join(blocking, ", ")))
+				continue
+			}
+		}
+		resource := r.resourceOf(desired.sources[subname])
+		subnameChanges, subnameConditions := []desec.RRSet{}, []string{}
+		for _, rrType := range subnameTypes {
+			existing := findRRSet(rrsets, subname, rrType)
+			rrset, ok := wanted[rrType]
+			txt, txtChanged := reg.release(subname, rrType)
+			if ok {
+				txt, txtChanged = reg.claim(subname, rrType, resource, getTTL(domain))
+			}
+			if txtChanged {
+				subnameChanges = append(subnameChanges, txt)
+				subnameConditions = append(subnameConditions, "")
+			}
+			if ok && slices.Contains(preexisting, rrType) {
+				statusChanged = addAdopted(&dnsCr.Status, subname, rrType) || statusChanged
+			}
+			switch {
+			case ok && (existing == nil || existing.TTL != rrset.TTL || !slices.Equal(slices.Sorted(slices.Values(existing.Records)), rrset.Records)):
+				log.Info("Setting "+rrType, "subname", subname, "domain", desecClient.Domain, "records", rrset.Records)
+			case !ok && existing != nil:
+				// e.g. a CNAME replaced by the addresses of a service
+				log.Info("Removing "+rrType, "subname", subname, "domain", desecClient.Domain)
+				rrset = desec.RRSet{Subname: subname, Type: rrType, Records: []string{}, TTL: existing.TTL}
+			default:
+				continue
+			}
+			subnameChanges = append(subnameChanges, rrset)
+			subnameConditions = append(subnameConditions, subname)
+		}
+		if invalid && isRejected(dnsCr.Status, subnameChanges, subnameConditions) {
+			for _, change := range subnameChanges {
+				rejected = append(rejected, describeRejected(change))
+			}
+			rejectedConditions = append(rejectedConditions, subname)
+			continue
+		}
+		changes = append(changes, subnameChanges...)
+		changeConditions = append(changeConditions, subnameConditions...)
+		if slices.Contains(subnameConditions, subname) {
+			util.UpdateDesecDnsStatus(&dnsCr.Status, subname, metav1.ConditionFalse, "Creating", "")
+		}
+	}
+	for _, subname := range util.GetCnameSubnames(dnsCr.Status) {
+		if slices.Contains(subnames, subname) {
+			continue
+		}
+		if !isPublished(dnsCr.Status, subname) {
+			// Nothing was published in the first place
+			continue
+		}
+		for _, rrType := range subnameTypes {
+			if txt, ok := reg.release(subname, rrType); ok {
+				changes = append(changes, txt)
+				changeConditions = append(changeConditions, "")
+			}
+			if existing := findRRSet(rrsets, subname, rrType); existing != nil && reg.owns(subname, rrType, true) {
+				log.Info("Removing "+rrType, "subname", subname, "domain", desecClient.Domain)
+				util.UpdateDesecDnsStatus(&dnsCr.Status, subname, metav1.ConditionFalse, "Deleting", "")
+				changes = append(changes, desec.RRSet{Subname: subname, Type: rrType, Records: []string{}, TTL: existing.TTL})
+				changeConditions = append(changeConditions, subname)
+			}
+		}
+	}
+	for _, srvSubname := range slices.Sorted(maps.Keys(desired.srvs)) {
+		srv := desired.srvs[srvSubname]
+		conditionType := srv.host
+		if conditionType == "" {
+			conditionType = util.ApexConditionType
+		}
+		rrset := desec.RRSet{Subname: srvSubname, Type: "SRV", Records: srv.records, TTL: getTTL(domain)}
+		if condition := util.FindSubnameCondition(dnsCr.Status, conditionType); condition != nil && condition.Reason == "Invalid" &&
+			isRejected(dnsCr.Status, []desec.RRSet{rrset}, []string{conditionType}) {
+			rejected = append(rejected, describeRejected(rrset))
+			rejectedConditions = append(rejectedConditions, conditionType)
+			continue
+		}
+		tracked := slices.Contains(dnsCr.Status.SRVSubnames, srvSubname)
+		if blocking := reg.conflict(srvSubname, "SRV", tracked || policy == v1.AdoptionPolicyAdopt); blocking != "" {
+			conflicts = true
+			r.warn(srv.source, "NotOwned", "Publish", fmt.Sprintf("Not publishing %s in %s: %s", srvSubname, desecClient.Domain, blocking))
+			continue
+		}
+		existing := findRRSet(rrsets, srvSubname, "SRV")
+		if !tracked && slices.Contains(getPreexistingTypes(reg, srvSubname), "SRV") {
+			if policy == v1.AdoptionPolicyCreate {
+				conflicts = true
+				r.warn(srv.source, "AlreadyExists", "Publish", fmt.Sprintf("Not publishing %s in %s: The SRV RRSet existed before and adoptionPolicy is Create", srvSubname, desecClient.Domain))
+				continue
+			}
+			statusChanged = addAdopted(&dnsCr.Status, srvSubname, "SRV") || statusChanged
+		}
+		if txt, ok := reg.claim(srvSubname, "SRV", r.resourceOf(srv.source), getTTL(domain)); ok {
+			changes = append(changes, txt)
+			changeConditions = append(changeConditions, "")
+		}
+		if existing == nil || existing.TTL != getTTL(domain) || !slices.Equal(slices.Sorted(slices.Values(existing.Records)), srv.records) {
+			log.Info("Setting SRV", "subname", srvSubname, "domain", desecClient.Domain, "records", srv.records)
+			changes = append(changes, rrset)
+			changeConditions = append(changeConditions, conditionType)
+		}
+		// Track the SRV records before creating them, to clean them up later on
+		if !slices.Contains(dnsCr.Status.SRVSubnames, srvSubname) {
+			dnsCr.Status.SRVSubnames = append(dnsCr.Status.SRVSubnames, srvSubname)
+			slices.Sort(dnsCr.Status.SRVSubnames)
+		}
+	}
+	for _, srvSubname := range dnsCr.Status.SRVSubnames {
+		if _, ok := desired.srvs[srvSubname]; ok {
+			continue
+		}
+		if txt, ok := reg.release(srvSubname, "SRV"); ok {
+			changes = append(changes, txt)
+			changeConditions = append(changeConditions, "")
+		}
+		if existing := findRRSet(rrsets, srvSubname, "SRV"); existing != nil && reg.owns(srvSubname, "SRV", true) {
+			log.Info("Removing SRV", "subname", srvSubname, "domain", desecClient.Domain)
+			changes = append(changes, desec.RRSet{Subname: srvSubname, Type: "SRV", Records: []string{}, TTL: existing.TTL})
+			changeConditions = append(changeConditions, "")
+		}
+	}
+	if policy == v1.AdoptionPolicyObserve {
+		dnsCr.Status.SRVSubnames, dnsCr.Status.Adopted = trackedSRVs, adopted
+		return r.reportDrift(ctx, dnsCr, desired, changes, changeConditions, statusChanged)
+	}
+	if r.isDryRun(domain.managedDomain, dnsCr) {
+		dnsCr.Status.SRVSubnames, dnsCr.Status.Adopted = trackedSRVs, adopted
+		return r.reportPlan(ctx, dnsCr, changes, statusChanged)
+	}
+	if len(changes) > 0 {
+		_, err := desecClient.BulkUpsertRRSets(ctx, changes)
+		apiErr := new(desec.APIError)
+		if desec.IsInvalid(err) && errors.As(err, &apiErr) && len(apiErr.Items) > 0 {
+			// Nothing was applied, mark the offending RRSets to not retry them
+			// over and over again, unless the sources change them
+			for i, item := range apiErr.Items {
+				if i < len(changes) && len(item) > 0 && changeConditions[i] != "" {
+					util.UpdateDesecDnsStatus(&dnsCr.Status, changeConditions[i], metav1.ConditionFalse, "Invalid", item.String())
+					if description := describeRejected(changes[i]); !slices.Contains(dnsCr.Status.Rejected, description) {
+						dnsCr.Status.Rejected = append(dnsCr.Status.Rejected, description)
+					}
 				}
 			}
-			cname, err := desecClient.CreateCNAME(subname)
-			if err == nil {
-				log.Info("CNAME created", "cname", cname)
+		} else if err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.Status().Update(ctx, dnsCr); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{Requeue: true, RequeueAfter: 100 * time.Millisecond}, nil
+	}
+
+	// Reflect the applied changes in the status, only changes still asked
+	// for are remembered as rejected
+	statusUpdate := updateApexStatus(&dnsCr.Status, desired) || statusChanged
+	slices.Sort(rejected)
+	if rejected = slices.Compact(rejected); len(rejected) == 0 {
+		rejected = nil
+	}
+	if !slices.Equal(rejected, dnsCr.Status.Rejected) {
+		dnsCr.Status.Rejected = rejected
+		statusUpdate = true
+	}
+	statusUpdate = plan(r.Recorder, dnsCr, &dnsCr.Status.PlannedChanges, isRRSetChange, nil) || statusUpdate
+	srvSubnames := slices.DeleteFunc(slices.Sorted(maps.Keys(desired.srvs)), func(srvSubname string) bool {
+		return !reg.owns(srvSubname, "SRV", slices.Contains(dnsCr.Status.SRVSubnames, srvSubname))
+	})
+	if !slices.Equal(srvSubnames, dnsCr.Status.SRVSubnames) {
+		dnsCr.Status.SRVSubnames = srvSubnames
+		statusUpdate = true
+	}
+	for _, subname := range util.GetCnameSubnames(dnsCr.Status) {
+		published := isPublished(dnsCr.Status, subname)
+		if _, ok := desired.unresolved[subname]; (!published || ok || slices.Contains(rejectedConditions, subname)) && slices.Contains(subnames, subname) {
+			// Conflicts, failures to resolve and rejected changes are
+			// reported until resolved
+			continue
+		}
+		if published && slices.ContainsFunc(subnameTypes, func(rrType string) bool { return reg.owns(subname, rrType, true) }) {
+			statusUpdate = util.UpdateDesecDnsStatus(&dnsCr.Status, subname, metav1.ConditionTrue, "Created", "") || statusUpdate
+		} else if !slices.Contains(subnames, subname) {
+			statusUpdate = util.RemoveSubnameCondition(&dnsCr.Status, subname) || statusUpdate
+		}
+	}
+	// Forget about adopted RRSets no longer published
+	adopted = slices.DeleteFunc(slices.Clone(dnsCr.Status.Adopted), func(name string) bool {
+		subname, _, _ := strings.Cut(name, "/")
+		return subname != "@" && !slices.Contains(util.GetCnameSubnames(dnsCr.Status), subname) && !slices.Contains(dnsCr.Status.SRVSubnames, subname)
+	})
+	if !slices.Equal(adopted, dnsCr.Status.Adopted) {
+		dnsCr.Status.Adopted = adopted
+		statusUpdate = true
+	}
+	if statusUpdate {
+		err := r.Status().Update(ctx, dnsCr)
+		return ctrl.Result{Requeue: true, RequeueAfter: 100 * time.Millisecond}, err
+	}
+
+	// Hostnames of load balancers may point somewhere else at any time, and
+	// RRSets of others may be gone
+	periodic := ctrl.Result{}
+	if desired.resolved {
+		periodic = ctrl.Result{RequeueAfter: desecConfig.ResolveInterval}
+	}
+	if conflicts {
+		periodic = earliestRequeue(periodic, ctrl.Result{RequeueAfter: 5 * time.Minute})
+	}
+	return periodic, nil
+}
+
+// isRejected reports whether deSEC rejected all of the changes of a condition
+// before. Changes of the registry, i.e. without condition, don't count.
+func isRejected(status v1.DesecDnsStatus, changes []desec.RRSet, changeConditions []string) bool {
+	found := false
+	for i, change := range changes {
+		if changeConditions[i] == "" {
+			continue
+		}
+		if !slices.Contains(status.Rejected, describeRejected(change)) {
+			return false
+		}
+		found = true
+	}
+	return found
+}
+
+// describeRejected describes a change rejected by deSEC, including its TTL,
+// e.g. set www/CNAME to [some-domain.dedyn.io.] with TTL 3600.
+func describeRejected(rrset desec.RRSet) string {
+	return fmt.Sprintf("%s with TTL %d", describeChange(rrset), rrset.TTL)
+}
+
+// getConflicts returns the RRSets of others at the subname, which would be
+// replaced by the ones of sources.
+func getConflicts(reg registry, subname string, tracked bool) []string {
+	conflicts := []string{}
+	for _, rrType := range subnameTypes {
+		if conflict := reg.conflict(subname, rrType, tracked); conflict != "" {
+			conflicts = append(conflicts, conflict)
+		}
+	}
+	return conflicts
+}
+
+// reportDrift reports the changes needed to reach the desired state by the
+// conditions of the subnames, instead of applying them.
+func (r *IngressReconciler) reportDrift(ctx context.Context, dnsCr *v1.DesecDns, desired desiredState, changes []desec.RRSet, changeConditions []string, statusUpdate bool) (ctrl.Result, error) {
+	drift := map[string][]string{}
+	for i, change := range changes {
+		if changeConditions[i] == "" {
+			// The registry is not reported
+			continue
+		}
+		verb := "set"
+		if len(change.Records) == 0 {
+			verb = "remove"
+		}
+		drift[changeConditions[i]] = append(drift[changeConditions[i]], verb+" "+nameOf(change.Subname, change.Type))
+	}
+
+	statusUpdate = updateApexStatus(&dnsCr.Status, desired) || statusUpdate
+	for conditionType, changes := range drift {
+		message := "Would " + strings.Join(changes, ", ")
+		statusUpdate = util.UpdateDesecDnsStatus(&dnsCr.Status, conditionType, metav1.ConditionFalse, "Drift", message) || statusUpdate
+	}
+	for _, subname := range util.GetCnameSubnames(dnsCr.Status) {
+		condition := util.FindSubnameCondition(dnsCr.Status, subname)
+		switch {
+		case drift[subname] != nil || slices.Contains(blockedReasons, condition.Reason) || condition.Reason == "Invalid" || condition.Reason == "ResolveFailed":
+		case slices.Contains(desired.subnames, subname):
+			statusUpdate = util.UpdateDesecDnsStatus(&dnsCr.Status, subname, metav1.ConditionTrue, "InSync", "") || statusUpdate
+		default:
+			statusUpdate = util.RemoveSubnameCondition(&dnsCr.Status, subname) || statusUpdate
+		}
+	}
+	if statusUpdate {
+		if err := r.Status().Update(ctx, dnsCr); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	// Check for drift every now and then
+	return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+}
+
+// isDryRun reports whether the changes to the domain are only planned.
+func (r *IngressReconciler) isDryRun(domain managedDomain, dnsCr *v1.DesecDns) bool {
+	return r.DryRun || domain.dryRun || isAnnotatedDryRun(dnsCr)
+}
+
+// reportPlan publishes the changes of the RRSets as planned, instead of
+// applying them.
+func (r *IngressReconciler) reportPlan(ctx context.Context, dnsCr *v1.DesecDns, changes []desec.RRSet, statusUpdate bool) (ctrl.Result, error) {
+	planned := []string{}
+	for _, change := range changes {
+		planned = append(planned, describeChange(change))
+	}
+	statusUpdate = plan(r.Recorder, dnsCr, &dnsCr.Status.PlannedChanges, isRRSetChange, planned) || statusUpdate
+	if statusUpdate {
+		if err := r.Status().Update(ctx, dnsCr); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	// Plan again every now and then, as the RRSets may change
+	return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+}
+
+// getPreexistingTypes returns the types of the RRSets of sources at the
+// subname, which were not published by the operator according to the
+// registry. Without registry, this cannot be told, so all are returned.
+func getPreexistingTypes(reg registry, subname string) []string {
+	existing := []string{}
+	for _, rrType := range append(slices.Clone(subnameTypes), "SRV") {
+		if findRRSet(reg.rrsets, subname, rrType) != nil && (reg.ownerID == "" || !reg.owns(subname, rrType, false)) {
+			existing = append(existing, rrType)
+		}
+	}
+	return existing
+}
+
+// nameOf returns subname and type of an RRSet as subname/type, e.g.
+// www/CNAME or @/A for the domain itself.
+func nameOf(subname string, rrType string) string {
+	if subname == "" {
+		subname = "@"
+	}
+	return subname + "/" + rrType
+}
+
+// addAdopted records the RRSet as adopted.
+func addAdopted(status *v1.DesecDnsStatus, subname string, rrType string) bool {
+	name := nameOf(subname, rrType)
+	if slices.Contains(status.Adopted, name) {
+		return false
+	}
+	status.Adopted = append(status.Adopted, name)
+	slices.Sort(status.Adopted)
+	return true
+}
+
+// getBlockingTypes returns the sorted types of the RRSets of the subname,
+// which prevent publishing a CNAME there. Records of the subname published by
+// the operator itself are replaced instead.
+func getBlockingTypes(reg registry, subname string, tracked bool) []string {
+	blocking := []string{}
+	for _, rrset := range reg.rrsets {
+		if rrset.Subname != subname || rrset.Type == "CNAME" {
+			continue
+		}
+		if slices.Contains(subnameTypes, rrset.Type) && tracked && reg.owns(subname, rrset.Type, tracked) {
+			continue
+		}
+		blocking = append(blocking, rrset.Type)
+	}
+	slices.Sort(blocking)
+	return blocking
+}
+
+// blockedReasons are the reasons of conditions of subnames taken by RRSets
+// of others.
+var blockedReasons = []string{"NotOwned", "Conflict", "AlreadyExists"}
+
+// isPublished reports whether the operator published RRSets at the subname,
+// i.e. it is tracked in the status, not taken by RRSets of others, nor only
+// observed.
+func isPublished(status v1.DesecDnsStatus, subname string) bool {
+	condition := util.FindSubnameCondition(status, subname)
+	return condition != nil && !slices.Contains(blockedReasons, condition.Reason) && condition.Reason != "Drift" && condition.Reason != "InSync"
+}
+
+// warn emits a warning event regarding the object, if events are recorded.
+func (r *IngressReconciler) warn(obj client.Object, reason string, action string, note string) {
+	if r.Recorder != nil && obj != nil {
+		r.Recorder.Eventf(obj, nil, corev1.EventTypeWarning, reason, action, "%s", note)
+	}
+}
+
+// earliestRequeue returns the result requeueing first, if any.
+func earliestRequeue(a ctrl.Result, b ctrl.Result) ctrl.Result {
+	if a.RequeueAfter == 0 || (b.RequeueAfter > 0 && b.RequeueAfter < a.RequeueAfter) {
+		return b
+	}
+	return a
+}
+
+// updateApexStatus reports whether the domain itself is served by its A and
+// AAAA records. It can never be a CNAME, so a load balancer only reporting a
+// hostname cannot be published there unless it is flattened.
+func updateApexStatus(status *v1.DesecDnsStatus, desired desiredState) bool {
+	switch {
+	case !desired.apex:
+		return meta.RemoveStatusCondition(&status.Conditions, util.ApexConditionType)
+	case desired.apexHostname != "":
+		message := fmt.Sprintf("Cannot point the domain at %s, use hostnameMode Flatten instead", desired.apexHostname)
+		return util.UpdateDesecDnsStatus(status, util.ApexConditionType, metav1.ConditionFalse, "HostnameNotFlattened", message)
+	default:
+		return util.UpdateDesecDnsStatus(status, util.ApexConditionType, metav1.ConditionTrue, "Covered", "Served by the A and AAAA records of the domain")
+	}
+}
+
+func findRRSet(rrsets []desec.RRSet, subname string, rrType string) *desec.RRSet {
+	index := slices.IndexFunc(rrsets, func(rrset desec.RRSet) bool { return rrset.Type == rrType && rrset.Subname == subname })
+	if index < 0 {
+		return nil
+	}
+	return &rrsets[index]
+}
+
+// getTTL returns the TTL of the records of sources in the domain.
+func getTTL(domain readyDomain) int64 {
+	if domain.ttl > 0 {
+		return domain.ttl
+	}
+	return 3600
+}
+
+// subnameTypes are the types of the RRSets subnames of sources are published
+// with.
+var subnameTypes = []string{"CNAME", "A", "AAAA"}
+
+// getDesiredRRSets returns the RRSets the subname is to be published with, by
+// type. That is A and AAAA records, if a service publishes its addresses
+// there, or a CNAME otherwise.
+func getDesiredRRSets(desecClient desec.Client, domain readyDomain, desired desiredState, subname string) map[string]desec.RRSet {
+	ttl := getTTL(domain)
+
+	if addresses, ok := desired.addresses[subname]; ok {
+		rrsets := map[string]desec.RRSet{}
+		ipv4s, ipv6s := util.SplitIps(addresses)
+		for rrType, ips := range map[string][]string{"A": ipv4s, "AAAA": ipv6s} {
+			if len(ips) > 0 {
+				slices.Sort(ips)
+				rrsets[rrType] = desec.RRSet{Subname: subname, Type: rrType, Records: ips, TTL: ttl}
 			}
-			return ctrl.Result{Requeue: true, RequeueAfter: 100 * time.Millisecond}, err
 		}
-		if util.UpdateDesecDnsStatus(&dnsCr.Status, subname, metav1.ConditionTrue, "Created", "") {
-			err := r.Status().Update(ctx, dnsCr)
-			return ctrl.Result{Requeue: true, RequeueAfter: 100 * time.Millisecond}, err
+		return rrsets
+	}
+
+	cname := desecClient.CNAME(subname)
+	cname.TTL = ttl
+	if target := desired.targets[subname]; target != "" {
+		cname.Records = []string{target}
+	}
+	return map[string]desec.RRSet{"CNAME": cname}
+}
+
+// desiredState is what all managed sources, like ingresses, ask for.
+type desiredState struct {
+	subnames []string
+	// targets holds the CNAME target of subnames not pointing at the domain,
+	// i.e. the hostname of the load balancer
+	targets map[string]string
+	// addresses holds the IPs of subnames published with A and AAAA records
+	// instead of a CNAME, i.e. the ones of services
+	addresses map[string][]string
+	// sources holds the source publishing each subname, which owns its
+	// RRSets
+	sources map[string]client.Object
+	// srvs holds the SRV records of the ports of services by subname, e.g.
+	// _minecraft._tcp.mc
+	srvs map[string]desiredSRV
+	// ips is the sorted union of the IPs of all load balancers
+	ips []string
+	// resolved is true if any hostname of a load balancer was resolved
+	resolved bool
+	// apex is true if any source serves the domain itself
+	apex bool
+	// apexHostname is the hostname of a load balancer serving the domain
+	// itself, which cannot be published
+	apexHostname string
+	// unresolved holds the subnames of sources whose load balancers could not
+	// be resolved, along with the error. Their RRSets are kept as they are.
+	unresolved map[string]string
+	// keepIPs is set if the IPs of load balancers could not be resolved, so
+	// the IPs of the domain are kept along with the ones known
+	keepIPs bool
+}
+
+// desiredSRV are the SRV records of a subname, along with the subname of the
+// host they point at.
+type desiredSRV struct {
+	records []string
+	host    string
+	source  client.Object
+}
+
+// addSRVs adds the SRV records pointing at the subname, unless another source
+// already published the same port there.
+func (d *desiredState) addSRVs(srvs []srvRecord, subname string, domain string, source client.Object) {
+	host := domain
+	if subname != "" {
+		host = subname + "." + domain
+	}
+	for _, srv := range srvs {
+		srvSubname := srv.label
+		if subname != "" {
+			srvSubname = srvSubname + "." + subname
+		}
+		if _, ok := d.srvs[srvSubname]; !ok {
+			d.srvs[srvSubname] = desiredSRV{records: []string{srv.forHost(host)}, host: subname, source: source}
 		}
 	}
+}
 
-	return ctrl.Result{}, err
+// getDesiredState collects the subnames and IPs of all sources with hosts
+// in the domain, which are not being deleted. Load balancers only reporting a
+// hostname are either targeted by the CNAMEs, or resolved and merged into the
+// IPs, depending on the mode.
+func (r *IngressReconciler) getDesiredState(ctx context.Context, domain string, managedDomains []managedDomain, mode config.HostnameMode, resolver util.Resolver) (desiredState, error) {
+	sources, err := r.getSources(ctx)
+	if err != nil {
+		return desiredState{}, err
+	}
+
+	desired := desiredState{subnames: []string{}, targets: map[string]string{}, addresses: map[string][]string{}, sources: map[string]client.Object{}, srvs: map[string]desiredSRV{}, ips: []string{}, unresolved: map[string]string{}}
+	for _, source := range sources {
+		domainNames := domainsFor(source, managedDomains)
+		if !slices.Contains(domainNames, domain) {
+			continue
+		}
+		sourceSubnames := util.GetSubnames(source.hosts, domain, domainNames...)
+		apex := util.ServesApex(source.hosts, domain)
+		if !source.GetDeletionTimestamp().IsZero() || (len(sourceSubnames) == 0 && !apex) {
+			continue
+		}
+		if source.direct && len(source.ips) == 0 && len(source.hostnames) == 0 {
+			// Nothing to publish yet, pointing at the domain would be wrong
+			continue
+		}
+
+		ips := slices.Clone(source.ips)
+		hostnames := slices.Clone(source.hostnames)
+		slices.Sort(hostnames)
+		// A hostname failing to resolve only affects the hosts of its source
+		var resolveErr error
+		if len(hostnames) > 0 && mode == config.HostnameModeFlatten {
+			for _, hostname := range hostnames {
+				resolved, err := resolver.LookupHost(ctx, hostname)
+				if err != nil {
+					log.FromContext(ctx).Error(err, "Failed to resolve hostname of load balancer", "hostname", hostname, "source", client.ObjectKeyFromObject(source))
+					resolveErr = err
+					continue
+				}
+				ips = append(ips, resolved...)
+			}
+			desired.resolved = true
+		}
+
+		if apex {
+			desired.apex = true
+			if len(ips) == 0 && len(hostnames) > 0 && desired.apexHostname == "" {
+				desired.apexHostname = hostnames[0]
+			}
+			desired.addSRVs(source.srvs, "", domain, source.Object)
+		}
+		for _, subname := range sourceSubnames {
+			if slices.Contains(desired.subnames, subname) {
+				continue
+			}
+			desired.subnames = append(desired.subnames, subname)
+			desired.sources[subname] = source.Object
+			desired.addSRVs(source.srvs, subname, domain, source.Object)
+			if resolveErr != nil {
+				desired.unresolved[subname] = resolveErr.Error()
+			}
+			if source.direct && len(ips) > 0 {
+				desired.addresses[subname] = ips
+			} else if len(ips) == 0 && len(hostnames) > 0 {
+				// A CNAME has a single target, so stick to the first one
+				desired.targets[subname] = hostnames[0] + "."
+			}
+		}
+		if source.direct && !apex {
+			continue
+		}
+		desired.keepIPs = desired.keepIPs || resolveErr != nil
+		for _, ip := range ips {
+			if !slices.Contains(desired.ips, ip) {
+				desired.ips = append(desired.ips, ip)
+			}
+		}
+	}
+	// Nodes serve all domains of the cluster
+	nodeIPs, err := r.getNodeIPs(ctx)
+	if err != nil {
+		return desiredState{}, err
+	}
+	for _, ip := range nodeIPs {
+		if !slices.Contains(desired.ips, ip) {
+			desired.ips = append(desired.ips, ip)
+		}
+	}
+	slices.Sort(desired.ips)
+	return desired, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *IngressReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	r.ConfigDir = "./mnt"
+	// Changes of nodes are handled by the NodeReconciler
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&networkingv1.Ingress{}).
+		Watches(&v1.DesecDomain{}, handler.EnqueueRequestsFromMapFunc(r.ingressesIn), domainChanged).
+		Watches(&v1.DesecAccount{}, handler.EnqueueRequestsFromMapFunc(r.ingressesIn), accountChanged).
 		Complete(r)
 }
+
+// domainChanged passes changes of the spec of a DesecDomain, as well as of
+// its dry-run annotation, and whether it created the domain.
+var domainChanged = builder.WithPredicates(predicate.Or(
+	predicate.GenerationChangedPredicate{},
+	predicate.AnnotationChangedPredicate{},
+	predicate.Funcs{UpdateFunc: func(e event.UpdateEvent) bool {
+		oldDomain, oldOk := e.ObjectOld.(*v1.DesecDomain)
+		newDomain, newOk := e.ObjectNew.(*v1.DesecDomain)
+		return oldOk && newOk && oldDomain.Status.CreatedDomain != newDomain.Status.CreatedDomain
+	}},
+))
+
+// accountChanged passes changes of the spec of a DesecAccount.
+var accountChanged = builder.WithPredicates(predicate.GenerationChangedPredicate{})
+
+// ingressesIn requests the ingresses with hosts in the domains affected by a
+// change of a DesecDomain or DesecAccount to be reconciled, as hosts may be
+// routed to another domain.
+func (r *IngressReconciler) ingressesIn(ctx context.Context, obj client.Object) []reconcile.Request {
+	ingresses := networkingv1.IngressList{}
+	if err := r.List(ctx, &ingresses); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list ingresses")
+		return nil
+	}
+	sources := []client.Object{}
+	for _, ingress := range ingresses.Items {
+		sources = append(sources, &ingress)
+	}
+	return requestsIn(sources, r.affectedDomains(ctx, obj))
+}
+
+// affectedDomains returns the names of the domains affected by a change of
+// the DesecDomain or DesecAccount, i.e. the domain itself, or the domains
+// using the account.
+func (r *IngressReconciler) affectedDomains(ctx context.Context, obj client.Object) []string {
+	account, ok := obj.(*v1.DesecAccount)
+	if !ok {
+		return []string{obj.GetName()}
+	}
+	desecDomains := v1.DesecDomainList{}
+	if err := r.List(ctx, &desecDomains); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list domains")
+		return nil
+	}
+	domains := []string{}
+	for _, desecDomain := range desecDomains.Items {
+		if desecDomain.Spec.Account == account.Name {
+			domains = append(domains, desecDomain.Name)
+		}
+	}
+	return domains
+}
+
+// requestsIn returns requests for the sources with hosts in any of the
+// domains.
+func requestsIn(sources []client.Object, domains []string) []reconcile.Request {
+	requests := []reconcile.Request{}
+	for _, source := range sources {
+		if slices.ContainsFunc(hostsOf(source), func(host string) bool { return util.MatchDomain(strings.TrimRight(host, "."), domains) != "" }) {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(source)})
+		}
+	}
+	return requests
+}
@@ -3,9 +3,15 @@ package controllers
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
+	"io/fs"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
@@ -14,14 +20,23 @@ import (
 	"github.com/j-be/desec-dns-operator/controllers/desec"
 	"github.com/j-be/desec-dns-operator/controllers/util"
 	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
 	netv1 "k8s.io/api/networking/v1"
 
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/tools/events"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
@@ -30,56 +45,21 @@ var ingressRequest = reconcile.Request{NamespacedName: types.NamespacedName{Name
 func TestIngressReconciler(t *testing.T) {
 	t.Run("Basic", func(t *testing.T) {
 		// Given
-		domains := make([]desec.Domain, 0)
-		rrsets := make([]desec.RRSet, 0)
-		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			switch r.URL.Path {
-			case "/api/v1/domains/":
-				switch r.Method {
-				case "GET":
-					body, err := json.Marshal(domains)
-					assert.NoError(t, err)
-					_, err = w.Write(body)
-					assert.NoError(t, err)
-				case "POST":
-					body, err := io.ReadAll(r.Body)
-					assert.NoError(t, err)
-					domain := desec.Domain{}
-					assert.NoError(t, json.Unmarshal(body, &domain))
-					domains = append(domains, domain)
-					w.WriteHeader(201)
-					_, err = w.Write(body)
-					assert.NoError(t, err)
-				default:
-					t.Fail()
-				}
-			case "/api/v1/domains/some-domain.dedyn.io/rrsets/":
-				switch r.Method {
-				case "GET":
-					body, err := json.Marshal(rrsets)
-					assert.NoError(t, err)
-					_, err = w.Write(body)
-					assert.NoError(t, err)
-				case "POST":
-					body, err := io.ReadAll(r.Body)
-					assert.NoError(t, err)
-					rrset := desec.RRSet{}
-					assert.NoError(t, json.Unmarshal(body, &rrset))
-					rrsets = append(rrsets, rrset)
-					w.WriteHeader(201)
-					_, err = w.Write(body)
-					assert.NoError(t, err)
-				default:
-					t.Fail()
-				}
-			default:
-				t.Fail()
-			}
-		}))
+		mock := new(desecMock)
+		server := createDesecServer(t, mock)
 		defer server.Close()
 		reconciler := createIngressReconciler(t, server.URL)
 		dnsCr := new(v1.DesecDns)
 		assert.EqualError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr), `desecdnses.desec.owly.dedyn.io "some-domain.dedyn.io" not found`)
+		// Add finalizer
+		{
+			result, err := reconciler.Reconcile(context.TODO(), ingressRequest)
+			assert.NoError(t, err)
+			assert.Equal(t, 100*time.Millisecond, result.RequeueAfter)
+			ingress := new(netv1.Ingress)
+			assert.NoError(t, reconciler.Get(context.TODO(), ingressRequest.NamespacedName, ingress))
+			assert.Equal(t, []string{ingressFinalizer}, ingress.Finalizers)
+		}
 		// Init CR + Status
 		for i := 0; i < 2; i = i + 1 {
 			result, err := reconciler.Reconcile(context.TODO(), ingressRequest)
@@ -96,7 +76,7 @@ func TestIngressReconciler(t *testing.T) {
 		}
 		// Create the domain
 		{
-			assert.Empty(t, domains)
+			assert.Empty(t, mock.domains)
 			result, err := reconciler.Reconcile(context.TODO(), ingressRequest)
 			assert.NoError(t, err)
 			assert.Equal(t, 100*time.Millisecond, result.RequeueAfter)
@@ -106,20 +86,21 @@ func TestIngressReconciler(t *testing.T) {
 			assert.Equal(t, metav1.ConditionFalse, domainCondition.Status)
 			assert.Equal(t, "Creating", domainCondition.Reason)
 			// Actually, it is already created here
-			assert.Len(t, domains, 1)
-			assert.Equal(t, "some-domain.dedyn.io", domains[0].Name)
+			assert.Len(t, mock.domains, 1)
+			assert.Equal(t, "some-domain.dedyn.io", mock.domains[0].Name)
 		}
 		// Update condition
 		{
 			result, err := reconciler.Reconcile(context.TODO(), ingressRequest)
 			assert.NoError(t, err)
 			assert.Equal(t, 100*time.Millisecond, result.RequeueAfter)
-			assert.Len(t, domains, 1)
+			assert.Len(t, mock.domains, 1)
 			assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
 			domainCondition := meta.FindStatusCondition(dnsCr.Status.Conditions, "Domain")
 			assert.NotNil(t, domainCondition)
 			assert.Equal(t, metav1.ConditionTrue, domainCondition.Status)
 			assert.Equal(t, "Created", domainCondition.Reason)
+			assert.True(t, dnsCr.Status.CreatedDomain)
 		}
 		// Update IPs
 		{
@@ -130,38 +111,46 @@ func TestIngressReconciler(t *testing.T) {
 			assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
 			assert.Equal(t, dnsCr.Spec.IPs, []string{"1.2.3.4", "2.3.4.5"})
 		}
-		assert.Empty(t, rrsets)
-		for i, subname := range []string{"www", "git"} {
-			// Create CNAME
-			{
-				assert.Nil(t, meta.FindStatusCondition(dnsCr.Status.Conditions, subname))
-				result, err := reconciler.Reconcile(context.TODO(), ingressRequest)
-				assert.NoError(t, err)
-				assert.Equal(t, 100*time.Millisecond, result.RequeueAfter)
-				assert.Len(t, rrsets, i+1)
-				cname := rrsets[i]
+		assert.Empty(t, mock.rrsets)
+		// Create CNAMEs
+		{
+			result, err := reconciler.Reconcile(context.TODO(), ingressRequest)
+			assert.NoError(t, err)
+			assert.Equal(t, 100*time.Millisecond, result.RequeueAfter)
+			assert.Equal(t, 1, mock.bulkRequests)
+			assert.Len(t, mock.rrsets, 2)
+			assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+			for i, subname := range []string{"www", "git"} {
+				cname := mock.rrsets[i]
 				assert.Equal(t, "some-domain.dedyn.io", cname.Domain)
 				assert.Equal(t, "CNAME", cname.Type)
 				assert.Equal(t, subname, cname.Subname)
 				assert.Equal(t, subname+".some-domain.dedyn.io.", cname.Name)
 				assert.Equal(t, []string{"some-domain.dedyn.io."}, cname.Records)
-				assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
 				condition := meta.FindStatusCondition(dnsCr.Status.Conditions, subname)
 				assert.NotNil(t, condition)
 				assert.Equal(t, metav1.ConditionFalse, condition.Status)
 				assert.Equal(t, "Creating", condition.Reason)
 			}
-			// Update associated condition
-			{
-				result, err := reconciler.Reconcile(context.TODO(), ingressRequest)
-				assert.NoError(t, err)
-				assert.Equal(t, 100*time.Millisecond, result.RequeueAfter)
-				assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		}
+		// Update associated conditions
+		{
+			result, err := reconciler.Reconcile(context.TODO(), ingressRequest)
+			assert.NoError(t, err)
+			assert.Equal(t, 100*time.Millisecond, result.RequeueAfter)
+			assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+			for _, subname := range []string{"www", "git"} {
 				condition := meta.FindStatusCondition(dnsCr.Status.Conditions, subname)
 				assert.NotNil(t, condition)
 				assert.Equal(t, metav1.ConditionTrue, condition.Status)
 				assert.Equal(t, "Created", condition.Reason)
 			}
+			// The apex is served by the A records instead of a CNAME
+			condition := meta.FindStatusCondition(dnsCr.Status.Conditions, util.ApexConditionType)
+			assert.NotNil(t, condition)
+			assert.Equal(t, metav1.ConditionTrue, condition.Status)
+			assert.Equal(t, "Covered", condition.Reason)
+			assert.NotContains(t, util.GetCnameSubnames(dnsCr.Status), "")
 		}
 		// Do nothing
 		{
@@ -183,32 +172,940 @@ func TestIngressReconciler(t *testing.T) {
 		}
 	})
 
-	t.Run("Not doing anything if not found", func(t *testing.T) {
+	t.Run("Cleanup", func(t *testing.T) {
+		// Given
+		mock := new(desecMock)
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := createIngressReconciler(t, server.URL)
+		reconcileIngress(t, &reconciler, ingressRequest)
+		assert.Len(t, mock.rrsets, 2)
+		// When
+		ingress := new(netv1.Ingress)
+		assert.NoError(t, reconciler.Get(context.TODO(), ingressRequest.NamespacedName, ingress))
+		ingress.Spec.Rules = ingress.Spec.Rules[:2]
+		assert.NoError(t, reconciler.Update(context.TODO(), ingress))
+		reconcileIngress(t, &reconciler, ingressRequest)
+		// Then
+		assert.Len(t, mock.rrsets, 1)
+		assert.Equal(t, "www", mock.rrsets[0].Subname)
+		dnsCr := new(v1.DesecDns)
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		assert.Equal(t, []string{"www"}, util.GetCnameSubnames(dnsCr.Status))
+
+		// When
+		assert.NoError(t, reconciler.Delete(context.TODO(), ingress))
+		reconcileIngress(t, &reconciler, ingressRequest)
+		// Then
+		assert.Empty(t, mock.rrsets)
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		assert.Empty(t, util.GetCnameSubnames(dnsCr.Status))
+		assert.Nil(t, meta.FindStatusCondition(dnsCr.Status.Conditions, util.ApexConditionType))
+		assert.EqualError(t, reconciler.Get(context.TODO(), ingressRequest.NamespacedName, ingress), `ingresses.networking.k8s.io "some-ingress" not found`)
+	})
+
+	t.Run("Subname taken by other RRSets", func(t *testing.T) {
+		// Given
+		mock := new(desecMock)
+		mock.rrsets = []desec.RRSet{
+			{Domain: "some-domain.dedyn.io", Subname: "www", Type: "TXT", Records: []string{`"some text"`}},
+			{Domain: "some-domain.dedyn.io", Subname: "www", Type: "MX", Records: []string{"10 mail.some-domain.dedyn.io."}},
+		}
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := createIngressReconciler(t, server.URL)
+		recorder := events.NewFakeRecorder(10)
+		reconciler.Recorder = recorder
+		// When
+		reconcileIngress(t, &reconciler, ingressRequest)
+		// Then
+		assert.Nil(t, findCname(mock.rrsets, "www"))
+		assert.NotNil(t, findCname(mock.rrsets, "git"))
+		dnsCr := new(v1.DesecDns)
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		condition := meta.FindStatusCondition(dnsCr.Status.Conditions, "www")
+		if assert.NotNil(t, condition) {
+			assert.Equal(t, metav1.ConditionFalse, condition.Status)
+			assert.Equal(t, "Conflict", condition.Reason)
+			assert.Equal(t, "A CNAME cannot coexist with the MX, TXT RRSets of the subname", condition.Message)
+		}
+		if assert.Len(t, recorder.Events, 1) {
+			assert.Equal(t, "Warning Conflict Not publishing www in some-domain.dedyn.io: "+condition.Message, <-recorder.Events)
+		}
+		// Backing off instead of retrying right away
+		bulkRequests := mock.bulkRequests
+		result, err := reconciler.Reconcile(context.TODO(), ingressRequest)
+		assert.NoError(t, err)
+		assert.Equal(t, 5*time.Minute, result.RequeueAfter)
+		assert.Equal(t, bulkRequests, mock.bulkRequests)
+		assert.Empty(t, recorder.Events)
+
+		// When
+		mock.rrsets = slices.DeleteFunc(mock.rrsets, func(rrset desec.RRSet) bool { return rrset.Subname == "www" })
+		reconcileIngress(t, &reconciler, ingressRequest)
+		// Then
+		assert.NotNil(t, findCname(mock.rrsets, "www"))
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		assert.True(t, isConditionReason(dnsCr.Status.Conditions, "www", "Created"))
+
+		// When
+		assert.NoError(t, reconciler.Delete(context.TODO(), &netv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "some-ingress", Namespace: "some-namespace"}}))
+		reconcileIngress(t, &reconciler, ingressRequest)
+		// Then
+		assert.Empty(t, mock.rrsets)
+	})
+
+	t.Run("Registry", func(t *testing.T) {
+		// Given
+		mock := new(desecMock)
+		mock.rrsets = []desec.RRSet{{Domain: "some-domain.dedyn.io", Subname: "git", Type: "CNAME", Records: []string{"elsewhere.example.com."}}}
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := createIngressReconciler(t, server.URL)
+		reconciler.OwnerID = "some-cluster"
+		recorder := events.NewFakeRecorder(10)
+		reconciler.Recorder = recorder
+		// When
+		reconcileIngress(t, &reconciler, ingressRequest)
+		// Then
+		cname := findCname(mock.rrsets, "www")
+		if assert.NotNil(t, cname) {
+			assert.Equal(t, []string{"some-domain.dedyn.io."}, cname.Records)
+		}
+		txt := findRRSet(mock.rrsets, "_desec-owner.cname.www", "TXT")
+		if assert.NotNil(t, txt) {
+			assert.Equal(t, []string{`"heritage=desec-dns-operator,owner=some-cluster,resource=ingress/some-namespace/some-ingress,version=1"`}, txt.Records)
+		}
+		assert.Equal(t, []string{"elsewhere.example.com."}, findCname(mock.rrsets, "git").Records)
+		assert.Nil(t, findRRSet(mock.rrsets, "_desec-owner.cname.git", "TXT"))
+		dnsCr := new(v1.DesecDns)
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		condition := meta.FindStatusCondition(dnsCr.Status.Conditions, "git")
+		if assert.NotNil(t, condition) {
+			assert.Equal(t, "NotOwned", condition.Reason)
+			assert.Equal(t, "CNAME not owned by the operator", condition.Message)
+		}
+		if assert.Len(t, recorder.Events, 1) {
+			assert.Equal(t, "Warning NotOwned Not publishing git in some-domain.dedyn.io: CNAME not owned by the operator", <-recorder.Events)
+		}
+
+		// When
+		ingress := new(netv1.Ingress)
+		assert.NoError(t, reconciler.Get(context.TODO(), ingressRequest.NamespacedName, ingress))
+		assert.NoError(t, reconciler.Delete(context.TODO(), ingress))
+		reconcileIngress(t, &reconciler, ingressRequest)
+		// Then
+		assert.Nil(t, findCname(mock.rrsets, "www"))
+		assert.Nil(t, findRRSet(mock.rrsets, "_desec-owner.cname.www", "TXT"))
+		assert.NotNil(t, findCname(mock.rrsets, "git"))
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		assert.Empty(t, util.GetCnameSubnames(dnsCr.Status))
+	})
+
+	t.Run("Registry takes over tracked records", func(t *testing.T) {
+		// Given
+		mock := new(desecMock)
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := createIngressReconciler(t, server.URL)
+		reconcileIngress(t, &reconciler, ingressRequest)
+		// Records published before the registry was enabled are adopted
+		// When
+		reconciler.OwnerID = "some-cluster"
+		reconcileIngress(t, &reconciler, ingressRequest)
+		// Then
+		for _, subname := range []string{"www", "git"} {
+			assert.NotNil(t, findRRSet(mock.rrsets, "_desec-owner.cname."+subname, "TXT"), subname)
+		}
+
+		// When
+		reconciler.OwnerID = "other-cluster"
+		reconcileIngress(t, &reconciler, ingressRequest)
+		// Then
+		dnsCr := new(v1.DesecDns)
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		for _, subname := range []string{"www", "git"} {
+			condition := meta.FindStatusCondition(dnsCr.Status.Conditions, subname)
+			if assert.NotNil(t, condition) {
+				assert.Equal(t, "NotOwned", condition.Reason)
+				assert.Equal(t, "CNAME owned by some-cluster (ingress/some-namespace/some-ingress)", condition.Message)
+			}
+		}
+	})
+
+	t.Run("Adoption policies", func(t *testing.T) {
+		setup := func(t *testing.T, policy string) (*desecMock, *httptest.Server, IngressReconciler) {
+			mock := new(desecMock)
+			mock.domains = []desec.Domain{{AuditInfo: desec.AuditInfo{Created: "2023-01-01T00:00:00Z"}, Name: "some-domain.dedyn.io", Minimum_TTL: 3600}}
+			mock.rrsets = []desec.RRSet{{Domain: "some-domain.dedyn.io", Subname: "www", Type: "CNAME", Records: []string{"elsewhere.example.com."}}}
+			server := createDesecServer(t, mock)
+			reconciler := createIngressReconciler(t, server.URL)
+			assert.NoError(t, os.WriteFile(reconciler.ConfigDir+"/config/adoptionPolicy", []byte(policy), fs.ModePerm))
+			return mock, server, reconciler
+		}
+
+		t.Run("Create", func(t *testing.T) {
+			// Given
+			mock, server, reconciler := setup(t, "Create")
+			defer server.Close()
+			// The operator creates the domain, but not the RRSet
+			mock.domains = nil
+			// When
+			reconcileIngress(t, &reconciler, ingressRequest)
+			// Then
+			assert.Equal(t, []string{"elsewhere.example.com."}, findCname(mock.rrsets, "www").Records)
+			assert.NotNil(t, findCname(mock.rrsets, "git"))
+			dnsCr := new(v1.DesecDns)
+			assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+			assert.Equal(t, v1.AdoptionPolicyCreate, dnsCr.Spec.AdoptionPolicy)
+			condition := meta.FindStatusCondition(dnsCr.Status.Conditions, "www")
+			if assert.NotNil(t, condition) {
+				assert.Equal(t, metav1.ConditionFalse, condition.Status)
+				assert.Equal(t, "AlreadyExists", condition.Reason)
+				assert.Equal(t, "The CNAME RRSets existed before and adoptionPolicy is Create", condition.Message)
+			}
+			assert.Empty(t, dnsCr.Status.Adopted)
+
+			// When
+			assert.NoError(t, reconciler.Delete(context.TODO(), &netv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "some-ingress", Namespace: "some-namespace"}}))
+			reconcileIngress(t, &reconciler, ingressRequest)
+			// Then
+			assert.NotNil(t, findCname(mock.rrsets, "www"))
+			assert.Nil(t, findCname(mock.rrsets, "git"))
+		})
+
+		t.Run("Create existing domain", func(t *testing.T) {
+			// Given
+			mock, server, reconciler := setup(t, "Create")
+			defer server.Close()
+			// When
+			reconcileIngress(t, &reconciler, ingressRequest)
+			// Then
+			assert.Zero(t, mock.bulkRequests)
+			dnsCr := new(v1.DesecDns)
+			assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+			assert.True(t, isConditionReason(dnsCr.Status.Conditions, "Domain", "AlreadyExists"))
+			assert.False(t, dnsCr.Status.CreatedDomain)
+		})
+
+		t.Run("Create, reporting creation fails", func(t *testing.T) {
+			// Given
+			mock, server, reconciler := setup(t, "Create")
+			defer server.Close()
+			mock.domains = nil
+			failed := false
+			reconciler.Client = interceptor.NewClient(reconciler.Client.(client.WithWatch), interceptor.Funcs{
+				SubResourceUpdate: func(ctx context.Context, c client.Client, subResource string, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+					if len(mock.domains) > 0 && !failed {
+						failed = true
+						return fmt.Errorf("connection refused")
+					}
+					return c.SubResource(subResource).Update(ctx, obj, opts...)
+				},
+			})
+			for i := 0; i < 10 && !failed; i = i + 1 {
+				_, _ = reconciler.Reconcile(context.TODO(), ingressRequest)
+			}
+			assert.True(t, failed)
+			// When
+			reconcileIngress(t, &reconciler, ingressRequest)
+			// Then
+			assert.Len(t, mock.domains, 1)
+			assert.NotNil(t, findCname(mock.rrsets, "git"))
+			dnsCr := new(v1.DesecDns)
+			assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+			assert.True(t, isConditionReason(dnsCr.Status.Conditions, "Domain", "Created"))
+			assert.True(t, dnsCr.Status.CreatedDomain)
+		})
+
+		t.Run("Adopt", func(t *testing.T) {
+			// Given
+			mock, server, reconciler := setup(t, "Adopt")
+			defer server.Close()
+			// When
+			reconcileIngress(t, &reconciler, ingressRequest)
+			// Then
+			assert.Equal(t, []string{"some-domain.dedyn.io."}, findCname(mock.rrsets, "www").Records)
+			dnsCr := new(v1.DesecDns)
+			assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+			assert.True(t, isConditionReason(dnsCr.Status.Conditions, "www", "Created"))
+			assert.Equal(t, []string{"www/CNAME"}, dnsCr.Status.Adopted)
+
+			// When
+			assert.NoError(t, reconciler.Delete(context.TODO(), &netv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "some-ingress", Namespace: "some-namespace"}}))
+			reconcileIngress(t, &reconciler, ingressRequest)
+			// Then
+			assert.Empty(t, mock.rrsets)
+			assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+			assert.Empty(t, dnsCr.Status.Adopted)
+		})
+
+		t.Run("Observe", func(t *testing.T) {
+			// Given
+			mock, server, reconciler := setup(t, "Observe")
+			defer server.Close()
+			// When
+			reconcileIngress(t, &reconciler, ingressRequest)
+			// Then
+			assert.Zero(t, mock.bulkRequests)
+			assert.Len(t, mock.rrsets, 1)
+			dnsCr := new(v1.DesecDns)
+			assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+			for _, subname := range []string{"www", "git"} {
+				condition := meta.FindStatusCondition(dnsCr.Status.Conditions, subname)
+				if assert.NotNil(t, condition, subname) {
+					assert.Equal(t, metav1.ConditionFalse, condition.Status)
+					assert.Equal(t, "Drift", condition.Reason)
+					assert.Equal(t, "Would set "+subname+"/CNAME", condition.Message)
+				}
+			}
+
+			// When in sync
+			mock.rrsets = []desec.RRSet{
+				{Domain: "some-domain.dedyn.io", Subname: "www", Type: "CNAME", Records: []string{"some-domain.dedyn.io."}, TTL: 3600},
+				{Domain: "some-domain.dedyn.io", Subname: "git", Type: "CNAME", Records: []string{"some-domain.dedyn.io."}, TTL: 3600},
+			}
+			result, err := reconciler.Reconcile(context.TODO(), ingressRequest)
+			// Then
+			assert.NoError(t, err)
+			assert.Equal(t, 5*time.Minute, result.RequeueAfter)
+			assert.Zero(t, mock.bulkRequests)
+			assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+			for _, subname := range []string{"www", "git"} {
+				condition := meta.FindStatusCondition(dnsCr.Status.Conditions, subname)
+				if assert.NotNil(t, condition, subname) {
+					assert.Equal(t, metav1.ConditionTrue, condition.Status)
+					assert.Equal(t, "InSync", condition.Reason)
+				}
+			}
+
+			// When
+			assert.NoError(t, reconciler.Delete(context.TODO(), &netv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "some-ingress", Namespace: "some-namespace"}}))
+			reconcileIngress(t, &reconciler, ingressRequest)
+			// Then nothing is removed
+			assert.Zero(t, mock.bulkRequests)
+			assert.Len(t, mock.rrsets, 2)
+		})
+	})
+
+	t.Run("Dry-run", func(t *testing.T) {
+		t.Run("Missing domain", func(t *testing.T) {
+			// Given
+			mock := new(desecMock)
+			server := createDesecServer(t, mock)
+			defer server.Close()
+			reconciler := createIngressReconciler(t, server.URL)
+			reconciler.DryRun = true
+			recorder := events.NewFakeRecorder(10)
+			reconciler.Recorder = recorder
+			// When
+			reconcileIngress(t, &reconciler, ingressRequest)
+			// Then
+			assert.Empty(t, mock.domains)
+			dnsCr := new(v1.DesecDns)
+			assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+			assert.True(t, isConditionReason(dnsCr.Status.Conditions, "Domain", "DryRun"))
+			assert.Equal(t, []string{"create the domain"}, dnsCr.Status.PlannedChanges)
+			assert.Equal(t, "Normal DryRun Would create the domain", <-recorder.Events)
+		})
+
+		t.Run("Existing domain", func(t *testing.T) {
+			// Given
+			mock := new(desecMock)
+			mock.domains = []desec.Domain{{Name: "some-domain.dedyn.io", Minimum_TTL: 3600}}
+			server := createDesecServer(t, mock)
+			defer server.Close()
+			reconciler := createIngressReconciler(t, server.URL)
+			reconciler.Recorder = events.NewFakeRecorder(10)
+			dnsCr := util.InitializeDesecDns(util.NamespacedName)
+			dnsCr.Annotations = map[string]string{dryRunAnnotation: "true"}
+			assert.NoError(t, reconciler.Create(context.TODO(), dnsCr))
+			// When
+			reconcileIngress(t, &reconciler, ingressRequest)
+			// Then
+			assert.Zero(t, mock.bulkRequests)
+			assert.Empty(t, mock.rrsets)
+			assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+			assert.Equal(t, []string{
+				"set git/CNAME to [some-domain.dedyn.io.]",
+				"set www/CNAME to [some-domain.dedyn.io.]",
+			}, dnsCr.Status.PlannedChanges)
+
+			// When dry-run is turned off
+			delete(dnsCr.Annotations, dryRunAnnotation)
+			assert.NoError(t, reconciler.Update(context.TODO(), dnsCr))
+			reconcileIngress(t, &reconciler, ingressRequest)
+			// Then
+			assert.Equal(t, 1, mock.bulkRequests)
+			assert.Len(t, mock.rrsets, 2)
+			assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+			assert.Empty(t, dnsCr.Status.PlannedChanges)
+		})
+	})
+
+	t.Run("Domain owned by somebody else", func(t *testing.T) {
 		// Given
 		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			assert.Equal(t, "/api/v1/domains/", r.URL.Path)
-			assert.Equal(t, "GET", r.Method)
-			body, err := json.Marshal([]desec.Domain{{Name: "some-domain.dedyn.io"}})
-			assert.NoError(t, err)
-			_, err = w.Write(body)
+			if r.Method == "POST" {
+				w.WriteHeader(409)
+				_, err := w.Write([]byte(`{"name": ["This domain name conflicts with an existing domain."]}`))
+				assert.NoError(t, err)
+				return
+			}
+			_, err := w.Write([]byte("[]"))
 			assert.NoError(t, err)
 		}))
 		defer server.Close()
 		reconciler := createIngressReconciler(t, server.URL)
-		request := reconcile.Request{NamespacedName: types.NamespacedName{Name: "IDoNotExist", Namespace: ingressRequest.Namespace}}
 		for i := 0; i < 3; i = i + 1 {
-			_, err := reconciler.Reconcile(context.TODO(), request)
+			_, err := reconciler.Reconcile(context.TODO(), ingressRequest)
 			assert.NoError(t, err)
 		}
 		// When
+		result, err := reconciler.Reconcile(context.TODO(), ingressRequest)
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, 5*time.Minute, result.RequeueAfter)
+		dnsCr := new(v1.DesecDns)
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		condition := meta.FindStatusCondition(dnsCr.Status.Conditions, "Domain")
+		assert.NotNil(t, condition)
+		assert.Equal(t, metav1.ConditionFalse, condition.Status)
+		assert.Equal(t, "Rejected", condition.Reason)
+		assert.Equal(t, "got status 409 while trying to POST /api/v1/domains/: name: This domain name conflicts with an existing domain.", condition.Message)
+	})
+
+	t.Run("Invalid CNAMEs", func(t *testing.T) {
+		// Given
+		mock := &desecMock{invalid: map[string]desec.FieldErrors{"git": {"subname": []any{"Subname is reserved."}}}}
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := createIngressReconciler(t, server.URL)
+		// When
+		reconcileIngress(t, &reconciler, ingressRequest)
+		// Then
+		assert.Equal(t, 2, mock.bulkRequests)
+		assert.Len(t, mock.rrsets, 1)
+		assert.Equal(t, "www", mock.rrsets[0].Subname)
+		dnsCr := new(v1.DesecDns)
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		condition := meta.FindStatusCondition(dnsCr.Status.Conditions, "git")
+		assert.NotNil(t, condition)
+		assert.Equal(t, metav1.ConditionFalse, condition.Status)
+		assert.Equal(t, "Invalid", condition.Reason)
+		assert.Equal(t, "subname: Subname is reserved.", condition.Message)
+		assert.Equal(t, []string{"set git/CNAME to [some-domain.dedyn.io.] with TTL 3600"}, dnsCr.Status.Rejected)
+		condition = meta.FindStatusCondition(dnsCr.Status.Conditions, "www")
+		assert.NotNil(t, condition)
+		assert.Equal(t, metav1.ConditionTrue, condition.Status)
+
+		// When deSEC would accept it, but nothing changed
+		mock.invalid = nil
+		reconcileIngress(t, &reconciler, ingressRequest)
+		// Then the rejected CNAME is not retried
+		assert.Equal(t, 2, mock.bulkRequests)
+		assert.Nil(t, findRRSet(mock.rrsets, "git", "CNAME"))
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		assert.Equal(t, "Invalid", meta.FindStatusCondition(dnsCr.Status.Conditions, "git").Reason)
+
+		// When the CNAME changes
+		ingress := new(netv1.Ingress)
+		assert.NoError(t, reconciler.Get(context.TODO(), ingressRequest.NamespacedName, ingress))
+		ingress.Status.LoadBalancer.Ingress = []netv1.IngressLoadBalancerIngress{{Hostname: "lb.example.com"}}
+		assert.NoError(t, reconciler.Status().Update(context.TODO(), ingress))
+		reconcileIngress(t, &reconciler, ingressRequest)
+		// Then it is retried
+		if cname := findRRSet(mock.rrsets, "git", "CNAME"); assert.NotNil(t, cname) {
+			assert.Equal(t, []string{"lb.example.com."}, cname.Records)
+		}
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		assert.Empty(t, dnsCr.Status.Rejected)
+		condition = meta.FindStatusCondition(dnsCr.Status.Conditions, "git")
+		assert.NotNil(t, condition)
+		assert.Equal(t, metav1.ConditionTrue, condition.Status)
+	})
+
+	t.Run("Wildcard host", func(t *testing.T) {
+		// Given
+		mock := new(desecMock)
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := createIngressReconciler(t, server.URL)
+		ingress := new(netv1.Ingress)
+		assert.NoError(t, reconciler.Get(context.TODO(), ingressRequest.NamespacedName, ingress))
+		ingress.Spec.Rules = []netv1.IngressRule{{Host: "*.some-domain.dedyn.io"}, {Host: "*.www.some-domain.dedyn.io"}}
+		assert.NoError(t, reconciler.Update(context.TODO(), ingress))
+		// Reject invalid conditions, as the API server does
+		reconciler.Client = interceptor.NewClient(reconciler.Client.(client.WithWatch), interceptor.Funcs{
+			SubResourceUpdate: func(ctx context.Context, c client.Client, subResource string, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+				if dnsCr, ok := obj.(*v1.DesecDns); ok {
+					if errs := metav1validation.ValidateConditions(dnsCr.Status.Conditions, field.NewPath("status", "conditions")); len(errs) > 0 {
+						return errs.ToAggregate()
+					}
+				}
+				return c.SubResource(subResource).Update(ctx, obj, opts...)
+			},
+		})
+		// When
+		reconcileIngress(t, &reconciler, ingressRequest)
+		// Then
+		assert.NotNil(t, findRRSet(mock.rrsets, "*", "CNAME"))
+		assert.NotNil(t, findRRSet(mock.rrsets, "*.www", "CNAME"))
+		dnsCr := new(v1.DesecDns)
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		assert.ElementsMatch(t, []string{"*", "*.www"}, util.GetCnameSubnames(dnsCr.Status))
+		condition := meta.FindStatusCondition(dnsCr.Status.Conditions, "wild_card")
+		assert.NotNil(t, condition)
+		assert.Equal(t, metav1.ConditionTrue, condition.Status)
+
+		// When the wildcard is dropped
+		assert.NoError(t, reconciler.Get(context.TODO(), ingressRequest.NamespacedName, ingress))
+		ingress.Spec.Rules = ingress.Spec.Rules[1:]
+		assert.NoError(t, reconciler.Update(context.TODO(), ingress))
+		reconcileIngress(t, &reconciler, ingressRequest)
+		// Then
+		assert.Nil(t, findRRSet(mock.rrsets, "*", "CNAME"))
+		assert.NotNil(t, findRRSet(mock.rrsets, "*.www", "CNAME"))
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		assert.Equal(t, []string{"*.www"}, util.GetCnameSubnames(dnsCr.Status))
+	})
+
+	t.Run("IPs of all ingresses", func(t *testing.T) {
+		// Given
+		mock := new(desecMock)
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := createIngressReconciler(t, server.URL,
+			&netv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{Name: "other-ingress", Namespace: "some-namespace"},
+				Spec:       netv1.IngressSpec{Rules: []netv1.IngressRule{{Host: "blog.some-domain.dedyn.io"}}},
+				Status: netv1.IngressStatus{LoadBalancer: netv1.IngressLoadBalancerStatus{Ingress: []netv1.IngressLoadBalancerIngress{
+					{IP: "2.3.4.5"},
+					{IP: "3.4.5.6"},
+				}}},
+			},
+			&netv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{Name: "pending-ingress", Namespace: "some-namespace"},
+				Spec:       netv1.IngressSpec{Rules: []netv1.IngressRule{{Host: "wiki.some-domain.dedyn.io"}}},
+			},
+			&netv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{Name: "foreign-ingress", Namespace: "some-namespace"},
+				Spec:       netv1.IngressSpec{Rules: []netv1.IngressRule{{Host: "www.wrong-domain.dedyn.io"}}},
+				Status: netv1.IngressStatus{LoadBalancer: netv1.IngressLoadBalancerStatus{Ingress: []netv1.IngressLoadBalancerIngress{
+					{IP: "9.9.9.9"},
+				}}},
+			},
+		)
+		dnsCr := new(v1.DesecDns)
+		for _, name := range []string{"some-ingress", "pending-ingress", "other-ingress", "foreign-ingress"} {
+			// When
+			reconcileIngress(t, &reconciler, reconcile.Request{NamespacedName: types.NamespacedName{Name: name, Namespace: "some-namespace"}})
+			// Then
+			assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+			assert.Equal(t, []string{"1.2.3.4", "2.3.4.5", "3.4.5.6"}, dnsCr.Spec.IPs)
+		}
+	})
+
+	t.Run("IPs of nodes", func(t *testing.T) {
+		// Given
+		mock := new(desecMock)
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := createIngressReconciler(t, server.URL,
+			createNode("node-1", true, "ingress", "5.6.7.8"),
+			createNode("node-2", false, "ingress", "6.7.8.9"),
+			createNode("node-3", true, "", "7.8.9.0"),
+		)
+		reconciler.NodeAddressType = corev1.NodeExternalIP
+		reconciler.NodeSelector = labels.SelectorFromSet(labels.Set{"role": "ingress"})
+		// When
+		reconcileIngress(t, &reconciler, ingressRequest)
+		// Then
+		dnsCr := new(v1.DesecDns)
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		assert.Equal(t, []string{"1.2.3.4", "2.3.4.5", "5.6.7.8"}, dnsCr.Spec.IPs)
+
+		// When
+		node := new(corev1.Node)
+		assert.NoError(t, reconciler.Get(context.TODO(), types.NamespacedName{Name: "node-2"}, node))
+		node.Status.Conditions[0].Status = corev1.ConditionTrue
+		assert.NoError(t, reconciler.Status().Update(context.TODO(), node))
+		assert.NoError(t, reconciler.Delete(context.TODO(), &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}))
+		reconcileIngress(t, &reconciler, ingressRequest)
+		// Then
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		assert.Equal(t, []string{"1.2.3.4", "2.3.4.5", "6.7.8.9"}, dnsCr.Spec.IPs)
+	})
+
+	t.Run("Hostname of load balancer as CNAME target", func(t *testing.T) {
+		// Given
+		mock := new(desecMock)
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := createIngressReconciler(t, server.URL, createElbIngress())
+		elbRequest := reconcile.Request{NamespacedName: types.NamespacedName{Name: "elb-ingress", Namespace: "some-namespace"}}
+		// When
+		reconcileIngress(t, &reconciler, elbRequest)
+		// Then
+		dnsCr := new(v1.DesecDns)
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		assert.Equal(t, []string{"1.2.3.4", "2.3.4.5"}, dnsCr.Spec.IPs)
+		shop := slices.IndexFunc(mock.rrsets, func(rrset desec.RRSet) bool { return rrset.Subname == "shop" })
+		assert.GreaterOrEqual(t, shop, 0)
+		assert.Equal(t, []string{"lb-1.elb.example.com."}, mock.rrsets[shop].Records)
+		www := slices.IndexFunc(mock.rrsets, func(rrset desec.RRSet) bool { return rrset.Subname == "www" })
+		assert.GreaterOrEqual(t, www, 0)
+		assert.Equal(t, []string{"some-domain.dedyn.io."}, mock.rrsets[www].Records)
+		condition := meta.FindStatusCondition(dnsCr.Status.Conditions, "shop")
+		assert.NotNil(t, condition)
+		assert.Equal(t, metav1.ConditionTrue, condition.Status)
+
+		// When the load balancer moves
+		elbIngress := new(netv1.Ingress)
+		assert.NoError(t, reconciler.Get(context.TODO(), elbRequest.NamespacedName, elbIngress))
+		elbIngress.Status.LoadBalancer.Ingress = []netv1.IngressLoadBalancerIngress{{Hostname: "lb-3.elb.example.com"}}
+		assert.NoError(t, reconciler.Status().Update(context.TODO(), elbIngress))
+		reconcileIngress(t, &reconciler, elbRequest)
+		// Then
+		shop = slices.IndexFunc(mock.rrsets, func(rrset desec.RRSet) bool { return rrset.Subname == "shop" })
+		assert.GreaterOrEqual(t, shop, 0)
+		assert.Equal(t, []string{"lb-3.elb.example.com."}, mock.rrsets[shop].Records)
+	})
+
+	t.Run("Flatten hostname of load balancer", func(t *testing.T) {
+		// Given
+		mock := new(desecMock)
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := createIngressReconciler(t, server.URL, createElbIngress())
+		assert.NoError(t, os.WriteFile(reconciler.ConfigDir+"/config/hostnameMode", []byte("Flatten"), fs.ModePerm))
+		assert.NoError(t, os.WriteFile(reconciler.ConfigDir+"/config/resolveInterval", []byte("10m"), fs.ModePerm))
+		resolver := fakeResolver{
+			"lb-1.elb.example.com": {"5.6.7.8", "2001:db8::1"},
+			"lb-2.elb.example.com": {"5.6.7.9"},
+		}
+		reconciler.Resolver = resolver
+		elbRequest := reconcile.Request{NamespacedName: types.NamespacedName{Name: "elb-ingress", Namespace: "some-namespace"}}
+		reconcileIngress(t, &reconciler, elbRequest)
+		// When
+		result, err := reconciler.Reconcile(context.TODO(), elbRequest)
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, 10*time.Minute, result.RequeueAfter)
+		dnsCr := new(v1.DesecDns)
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		assert.Equal(t, []string{"1.2.3.4", "2.3.4.5", "2001:db8::1", "5.6.7.8", "5.6.7.9"}, dnsCr.Spec.IPs)
+		for _, rrset := range mock.rrsets {
+			assert.Equal(t, []string{"some-domain.dedyn.io."}, rrset.Records)
+		}
+
+		// When resolving fails
+		delete(resolver, "lb-2.elb.example.com")
+		mock.touched = 0
+		reconcileIngress(t, &reconciler, elbRequest)
+		// Then the records already known are kept
+		assert.Zero(t, mock.touched)
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		assert.Equal(t, []string{"1.2.3.4", "2.3.4.5", "2001:db8::1", "5.6.7.8", "5.6.7.9"}, dnsCr.Spec.IPs)
+		condition := meta.FindStatusCondition(dnsCr.Status.Conditions, "shop")
+		if assert.NotNil(t, condition) {
+			assert.Equal(t, metav1.ConditionFalse, condition.Status)
+			assert.Equal(t, "ResolveFailed", condition.Reason)
+			assert.Equal(t, "lookup lb-2.elb.example.com: no such host", condition.Message)
+		}
+		assert.True(t, isConditionReason(dnsCr.Status.Conditions, "www", "Created"))
+
+		// When resolving works again
+		resolver["lb-2.elb.example.com"] = []string{"5.6.7.9"}
+		reconcileIngress(t, &reconciler, elbRequest)
+		// Then
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		assert.True(t, isConditionReason(dnsCr.Status.Conditions, "shop", "Created"))
+	})
+
+	t.Run("Apex behind hostname of load balancer", func(t *testing.T) {
+		// Given
+		mock := new(desecMock)
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := createIngressReconciler(t, server.URL, &netv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Name: "apex-ingress", Namespace: "some-namespace"},
+			Spec:       netv1.IngressSpec{Rules: []netv1.IngressRule{{Host: "some-domain.dedyn.io."}}},
+			Status: netv1.IngressStatus{LoadBalancer: netv1.IngressLoadBalancerStatus{Ingress: []netv1.IngressLoadBalancerIngress{
+				{Hostname: "lb-1.elb.example.com"},
+			}}},
+		})
+		assert.NoError(t, reconciler.Delete(context.TODO(), &netv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "some-ingress", Namespace: "some-namespace"}}))
+		// When
+		reconcileIngress(t, &reconciler, reconcile.Request{NamespacedName: types.NamespacedName{Name: "apex-ingress", Namespace: "some-namespace"}})
+		// Then
+		assert.Empty(t, mock.rrsets)
+		dnsCr := new(v1.DesecDns)
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		assert.Empty(t, dnsCr.Spec.IPs)
+		condition := meta.FindStatusCondition(dnsCr.Status.Conditions, util.ApexConditionType)
+		assert.NotNil(t, condition)
+		assert.Equal(t, metav1.ConditionFalse, condition.Status)
+		assert.Equal(t, "HostnameNotFlattened", condition.Reason)
+		assert.Equal(t, "Cannot point the domain at lb-1.elb.example.com, use hostnameMode Flatten instead", condition.Message)
+	})
+
+	t.Run("Longest matching domain", func(t *testing.T) {
+		// Given
+		mock := new(desecMock)
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := createIngressReconciler(t, server.URL,
+			&v1.DesecDomain{
+				ObjectMeta: metav1.ObjectMeta{Name: "eu.some-domain.dedyn.io", Namespace: util.NamespacedName.Namespace},
+				Spec:       v1.DesecDomainSpec{CreationPolicy: v1.CreationPolicyIfNotPresent, TTL: 7200},
+			},
+			&v1.DesecDomain{
+				ObjectMeta: metav1.ObjectMeta{Name: "other-domain.dedyn.io", Namespace: util.NamespacedName.Namespace},
+				Spec:       v1.DesecDomainSpec{CreationPolicy: v1.CreationPolicyNever},
+			},
+			&netv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{Name: "eu-ingress", Namespace: "some-namespace"},
+				Spec: netv1.IngressSpec{Rules: []netv1.IngressRule{
+					{Host: "eu.some-domain.dedyn.io"},
+					{Host: "shop.eu.some-domain.dedyn.io"},
+					{Host: "blog.some-domain.dedyn.io"},
+					{Host: "www.other-domain.dedyn.io"},
+				}},
+				Status: netv1.IngressStatus{LoadBalancer: netv1.IngressLoadBalancerStatus{Ingress: []netv1.IngressLoadBalancerIngress{
+					{IP: "5.6.7.8"},
+				}}},
+			},
+		)
+		// When
+		reconcileIngress(t, &reconciler, reconcile.Request{NamespacedName: types.NamespacedName{Name: "eu-ingress", Namespace: "some-namespace"}})
+		// Then
+		assert.ElementsMatch(t, []desec.Domain{
+			{AuditInfo: desec.AuditInfo{Created: "2023-01-01T00:00:00Z"}, Name: "eu.some-domain.dedyn.io", Minimum_TTL: 3600},
+			{AuditInfo: desec.AuditInfo{Created: "2023-01-01T00:00:00Z"}, Name: "some-domain.dedyn.io", Minimum_TTL: 3600},
+		}, mock.domains)
+		names := []string{}
+		for _, rrset := range mock.rrsets {
+			names = append(names, rrset.Name)
+			if rrset.Domain == "eu.some-domain.dedyn.io" {
+				assert.Equal(t, int64(7200), rrset.TTL)
+				assert.Equal(t, []string{"eu.some-domain.dedyn.io."}, rrset.Records)
+			} else {
+				assert.Equal(t, int64(3600), rrset.TTL)
+				assert.Equal(t, []string{"some-domain.dedyn.io."}, rrset.Records)
+			}
+		}
+		assert.ElementsMatch(t, []string{
+			"shop.eu.some-domain.dedyn.io.",
+			"blog.some-domain.dedyn.io.",
+			"www.some-domain.dedyn.io.",
+			"git.some-domain.dedyn.io.",
+		}, names)
+		euCr := new(v1.DesecDns)
+		assert.NoError(t, reconciler.Get(context.TODO(), types.NamespacedName{Name: "eu.some-domain.dedyn.io", Namespace: util.NamespacedName.Namespace}, euCr))
+		assert.Equal(t, []string{"5.6.7.8"}, euCr.Spec.IPs)
+		assert.NotNil(t, meta.FindStatusCondition(euCr.Status.Conditions, util.ApexConditionType))
+		otherCr := new(v1.DesecDns)
+		assert.NoError(t, reconciler.Get(context.TODO(), types.NamespacedName{Name: "other-domain.dedyn.io", Namespace: util.NamespacedName.Namespace}, otherCr))
+		condition := meta.FindStatusCondition(otherCr.Status.Conditions, "Domain")
+		assert.NotNil(t, condition)
+		assert.Equal(t, metav1.ConditionFalse, condition.Status)
+		assert.Equal(t, "NotFound", condition.Reason)
+	})
+
+	t.Run("Domain created concurrently", func(t *testing.T) {
+		// Given
+		mock := &desecMock{racing: true}
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := createIngressReconciler(t, server.URL)
+		// When
+		reconcileIngress(t, &reconciler, ingressRequest)
+		// Then
+		dnsCr := new(v1.DesecDns)
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		assert.True(t, isConditionReason(dnsCr.Status.Conditions, "Domain", "Created"))
+		assert.True(t, dnsCr.Status.CreatedDomain)
+		assert.NotNil(t, findCname(mock.rrsets, "www"))
+	})
+
+	t.Run("Domain created by DesecDomain", func(t *testing.T) {
+		// Given
+		mock := &desecMock{domains: []desec.Domain{{Name: "some-domain.dedyn.io"}}}
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := createIngressReconciler(t, server.URL, &v1.DesecDomain{
+			ObjectMeta: metav1.ObjectMeta{Name: "some-domain.dedyn.io", Namespace: util.NamespacedName.Namespace},
+			Status:     v1.DesecDomainStatus{CreatedDomain: true},
+		})
+		// When
+		reconcileIngress(t, &reconciler, ingressRequest)
+		// Then
+		dnsCr := new(v1.DesecDns)
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		assert.True(t, dnsCr.Status.CreatedDomain)
+	})
+
+	t.Run("TTL of the domain", func(t *testing.T) {
+		// Given
+		mock := &desecMock{domains: []desec.Domain{{Name: "some-domain.dedyn.io"}}}
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		desecDomain := &v1.DesecDomain{
+			ObjectMeta: metav1.ObjectMeta{Name: "some-domain.dedyn.io", Namespace: util.NamespacedName.Namespace},
+			Spec:       v1.DesecDomainSpec{CreationPolicy: v1.CreationPolicyNever, TTL: 3600},
+		}
+		reconciler := createIngressReconciler(t, server.URL, desecDomain)
+		reconcileIngress(t, &reconciler, ingressRequest)
+		assert.Equal(t, int64(3600), findCname(mock.rrsets, "www").TTL)
+		// When
+		assert.NoError(t, reconciler.Get(context.TODO(), client.ObjectKeyFromObject(desecDomain), desecDomain))
+		desecDomain.Spec.TTL = 7200
+		assert.NoError(t, reconciler.Update(context.TODO(), desecDomain))
+		reconcileIngress(t, &reconciler, ingressRequest)
+		// Then
+		for _, subname := range []string{"www", "git"} {
+			assert.Equal(t, int64(7200), findCname(mock.rrsets, subname).TTL, subname)
+		}
+	})
+
+	t.Run("Host moved to another domain", func(t *testing.T) {
+		// Given
+		mock := &desecMock{domains: []desec.Domain{{Name: "some-domain.dedyn.io"}, {Name: "other-domain.dedyn.io"}}}
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		ingress := &netv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Name: "moving-ingress", Namespace: "some-namespace"},
+			Spec:       netv1.IngressSpec{Rules: []netv1.IngressRule{{Host: "app.some-domain.dedyn.io"}}},
+		}
+		reconciler := createIngressReconciler(t, server.URL,
+			&v1.DesecDomain{
+				ObjectMeta: metav1.ObjectMeta{Name: "other-domain.dedyn.io", Namespace: util.NamespacedName.Namespace},
+				Spec:       v1.DesecDomainSpec{CreationPolicy: v1.CreationPolicyNever},
+			},
+			ingress,
+		)
+		request := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(ingress)}
+		reconcileIngress(t, &reconciler, request)
+		assert.NoError(t, reconciler.Get(context.TODO(), request.NamespacedName, ingress))
+		assert.Equal(t, "some-domain.dedyn.io", ingress.Annotations[domainsAnnotation])
+		// When
+		ingress.Spec.Rules[0].Host = "app.other-domain.dedyn.io"
+		assert.NoError(t, reconciler.Update(context.TODO(), ingress))
+		reconcileIngress(t, &reconciler, request)
+		// Then
+		names := []string{}
+		for _, rrset := range mock.rrsets {
+			if rrset.Subname == "app" {
+				names = append(names, rrset.Name)
+			}
+		}
+		assert.Equal(t, []string{"app.other-domain.dedyn.io."}, names)
+		assert.NoError(t, reconciler.Get(context.TODO(), request.NamespacedName, ingress))
+		assert.Equal(t, "other-domain.dedyn.io", ingress.Annotations[domainsAnnotation])
+	})
+
+	t.Run("Changes of domains only affect their ingresses", func(t *testing.T) {
+		// Given
+		reconciler := createIngressReconciler(t, "http://localhost",
+			&v1.DesecDomain{
+				ObjectMeta: metav1.ObjectMeta{Name: "eu.some-domain.dedyn.io", Namespace: util.NamespacedName.Namespace},
+				Spec:       v1.DesecDomainSpec{Account: accountRequest.Name},
+			},
+			&netv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{Name: "eu-ingress", Namespace: "some-namespace"},
+				Spec:       netv1.IngressSpec{Rules: []netv1.IngressRule{{Host: "shop.eu.some-domain.dedyn.io"}}},
+			},
+		)
+		euIngress := reconcile.Request{NamespacedName: types.NamespacedName{Name: "eu-ingress", Namespace: "some-namespace"}}
+		// When / Then
+		assert.ElementsMatch(t, []reconcile.Request{ingressRequest, euIngress}, reconciler.ingressesIn(context.TODO(), &v1.DesecDomain{ObjectMeta: metav1.ObjectMeta{Name: "some-domain.dedyn.io"}}))
+		assert.Equal(t, []reconcile.Request{euIngress}, reconciler.ingressesIn(context.TODO(), &v1.DesecAccount{ObjectMeta: metav1.ObjectMeta{Name: accountRequest.Name}}))
+		assert.Empty(t, reconciler.ingressesIn(context.TODO(), &v1.DesecDomain{ObjectMeta: metav1.ObjectMeta{Name: "other-domain.dedyn.io"}}))
+	})
+
+	t.Run("Spec of DesecDns is left alone", func(t *testing.T) {
+		// Given
+		mock := &desecMock{domains: []desec.Domain{{Name: "some-domain.dedyn.io"}}}
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		dnsCr := util.InitializeDesecDns(util.NamespacedName)
+		dnsCr.Spec.AdoptionPolicy = v1.AdoptionPolicyObserve
+		reconciler := createIngressReconciler(t, server.URL, dnsCr)
+		assert.NoError(t, os.WriteFile(reconciler.ConfigDir+"/config/adoptionPolicy", []byte("Adopt"), fs.ModePerm))
+		// When
+		reconcileIngress(t, &reconciler, ingressRequest)
+		// Then
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		assert.Equal(t, v1.AdoptionPolicyObserve, dnsCr.Spec.AdoptionPolicy)
+		assert.Zero(t, mock.bulkRequests)
+	})
+
+	t.Run("Account of ingress", func(t *testing.T) {
+		// Given
+		mock := new(desecMock)
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := createIngressReconciler(t, server.URL,
+			&v1.DesecDomain{
+				ObjectMeta: metav1.ObjectMeta{Name: "eu.some-domain.dedyn.io", Namespace: util.NamespacedName.Namespace},
+				Spec:       v1.DesecDomainSpec{CreationPolicy: v1.CreationPolicyIfNotPresent, Account: accountRequest.Name},
+			},
+			&netv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "eu-ingress",
+					Namespace:   "some-namespace",
+					Annotations: map[string]string{accountAnnotation: accountRequest.Name},
+				},
+				Spec: netv1.IngressSpec{Rules: []netv1.IngressRule{
+					{Host: "shop.eu.some-domain.dedyn.io"},
+					{Host: "blog.some-domain.dedyn.io"},
+				}},
+			},
+		)
+		createAccount(t, reconciler.Client, server.URL, "account token")
+		// When the account does not allow the namespace
+		reconcileIngress(t, &reconciler, reconcile.Request{NamespacedName: types.NamespacedName{Name: "eu-ingress", Namespace: "some-namespace"}})
+		// Then
+		assert.Empty(t, mock.rrsets)
+
+		// When it does
+		account := getAccountOf(t, reconciler.Client)
+		account.Spec.Namespaces = []string{"some-namespace"}
+		assert.NoError(t, reconciler.Update(context.TODO(), account))
+		reconcileIngress(t, &reconciler, reconcile.Request{NamespacedName: types.NamespacedName{Name: "eu-ingress", Namespace: "some-namespace"}})
+		// Then
+		names := []string{}
+		for _, rrset := range mock.rrsets {
+			names = append(names, rrset.Name)
+		}
+		// Other domains are left to their sources
+		assert.ElementsMatch(t, []string{"shop.eu.some-domain.dedyn.io."}, names)
+		euCr := new(v1.DesecDns)
+		assert.NoError(t, reconciler.Get(context.TODO(), types.NamespacedName{Name: "eu.some-domain.dedyn.io", Namespace: util.NamespacedName.Namespace}, euCr))
+		assert.Equal(t, accountRequest.Name, euCr.Spec.Account)
+	})
+
+	t.Run("Not doing anything if not found", func(t *testing.T) {
+		// Given
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Fail(t, "Unexpected request", r.URL.Path)
+		}))
+		defer server.Close()
+		reconciler := createIngressReconciler(t, server.URL)
+		request := reconcile.Request{NamespacedName: types.NamespacedName{Name: "IDoNotExist", Namespace: ingressRequest.Namespace}}
+		// When
 		result, err := reconciler.Reconcile(context.TODO(), request)
 		// Then
-		assert.EqualError(t, err, `ingresses.networking.k8s.io "IDoNotExist" not found`)
+		assert.NoError(t, err)
 		assert.True(t, result.IsZero())
+		assert.True(t, errors.IsNotFound(reconciler.Get(context.TODO(), util.NamespacedName, new(v1.DesecDns))))
 	})
 }
 
-func createIngressReconciler(t *testing.T, serverUrl string) IngressReconciler {
+func createIngressReconciler(t *testing.T, serverUrl string, additionalObjects ...client.Object) IngressReconciler {
+	disableRateLimits(t)
 	objects := []client.Object{
 		&netv1.Ingress{
 			ObjectMeta: metav1.ObjectMeta{Name: "some-ingress", Namespace: "some-namespace"},
@@ -226,9 +1123,16 @@ func createIngressReconciler(t *testing.T, serverUrl string) IngressReconciler {
 		},
 	}
 
+	objects = append(objects, additionalObjects...)
+
 	mockScheme := runtime.NewScheme()
 	assert.NoError(t, v1.AddToScheme(mockScheme))
+	assert.NoError(t, corev1.AddToScheme(mockScheme))
 	assert.NoError(t, netv1.AddToScheme(mockScheme))
+	for _, gvk := range []schema.GroupVersionKind{gatewayGVK, routeGVKs["HTTPRoute"], routeGVKs["GRPCRoute"], routeGVKs["TLSRoute"], TraefikIngressRouteGVK, OpenShiftRouteGVK} {
+		mockScheme.AddKnownTypeWithName(gvk, new(unstructured.Unstructured))
+		mockScheme.AddKnownTypeWithName(gvk.GroupVersion().WithKind(gvk.Kind+"List"), new(unstructured.UnstructuredList))
+	}
 
 	fakeClient := fake.NewClientBuilder().
 		WithScheme(mockScheme).
@@ -245,3 +1149,202 @@ func createIngressReconciler(t *testing.T, serverUrl string) IngressReconciler {
 		ConfigDir: configDir,
 	}
 }
+
+// createElbIngress returns an ingress behind load balancers only reporting a
+// hostname.
+func createElbIngress() *netv1.Ingress {
+	return &netv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "elb-ingress", Namespace: "some-namespace"},
+		Spec:       netv1.IngressSpec{Rules: []netv1.IngressRule{{Host: "shop.some-domain.dedyn.io"}}},
+		Status: netv1.IngressStatus{LoadBalancer: netv1.IngressLoadBalancerStatus{Ingress: []netv1.IngressLoadBalancerIngress{
+			{Hostname: "lb-2.elb.example.com"},
+			{Hostname: "lb-1.elb.example.com"},
+		}}},
+	}
+}
+
+// createNode returns a node with the role label, if given, and the external
+// IP, as well as an internal one.
+func createNode(name string, ready bool, role string, externalIP string) *corev1.Node {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	if role != "" {
+		node.Labels = map[string]string{"role": role}
+	}
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	node.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: status}}
+	node.Status.Addresses = []corev1.NodeAddress{
+		{Type: corev1.NodeInternalIP, Address: "10.0.0.1"},
+		{Type: corev1.NodeExternalIP, Address: externalIP},
+	}
+	return node
+}
+
+// fakeResolver resolves hostnames to the given IPs.
+type fakeResolver map[string][]string
+
+func (f fakeResolver) LookupHost(_ context.Context, host string) ([]string, error) {
+	ips, ok := f[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return ips, nil
+}
+
+// reconcileIngress reconciles until no further immediate requeue is requested, or the ingress is gone.
+func reconcileIngress(t *testing.T, reconciler reconcile.Reconciler, request reconcile.Request) {
+	for i := 0; i < 50; i = i + 1 {
+		result, err := reconciler.Reconcile(context.TODO(), request)
+		if errors.IsNotFound(err) {
+			return
+		}
+		assert.NoError(t, err)
+		if result.RequeueAfter != 100*time.Millisecond {
+			return
+		}
+	}
+	assert.Fail(t, "Reconciliation did not settle")
+}
+
+// desecMock keeps the state of the deSEC API served by createDesecServer.
+type desecMock struct {
+	domains []desec.Domain
+	rrsets  []desec.RRSet
+	// Validation errors returned for bulk requests, by subname
+	invalid      map[string]desec.FieldErrors
+	bulkRequests int
+	// Number of RRSets touched so far, used to generate audit info
+	touched int
+	// racing answers creating a domain with a conflict, although it is
+	// created, as if by somebody else at the same time
+	racing bool
+}
+
+func findCname(rrsets []desec.RRSet, subname string) *desec.RRSet {
+	return findRRSet(rrsets, subname, "CNAME")
+}
+
+func createDesecServer(t *testing.T, mock *desecMock) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Paths of RRSets look like /api/v1/domains/{domain}/rrsets/{subname}/{type}/
+		domain, rrsetKey, isRRSets := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/v1/domains/"), "/rrsets/")
+		inDomain := func(rrset desec.RRSet) bool { return rrset.Domain == domain }
+		switch {
+		case r.URL.Path == "/api/v1/domains/":
+			switch r.Method {
+			case "GET":
+				body, err := json.Marshal(mock.domains)
+				assert.NoError(t, err)
+				_, err = w.Write(body)
+				assert.NoError(t, err)
+			case "POST":
+				body, err := io.ReadAll(r.Body)
+				assert.NoError(t, err)
+				domain := desec.Domain{}
+				assert.NoError(t, json.Unmarshal(body, &domain))
+				domain.Created = "2023-01-01T00:00:00Z"
+				domain.Minimum_TTL = 3600
+				mock.domains = append(mock.domains, domain)
+				if mock.racing {
+					w.WriteHeader(409)
+					_, err = w.Write([]byte(`{"name": ["This domain name conflicts with an existing domain."]}`))
+					assert.NoError(t, err)
+					return
+				}
+				body, err = json.Marshal(domain)
+				assert.NoError(t, err)
+				w.WriteHeader(201)
+				_, err = w.Write(body)
+				assert.NoError(t, err)
+			default:
+				t.Fail()
+			}
+		case isRRSets && rrsetKey == "":
+			switch r.Method {
+			case "GET":
+				query := r.URL.Query()
+				rrsets := slices.DeleteFunc(slices.Clone(mock.rrsets), func(rrset desec.RRSet) bool {
+					return !inDomain(rrset) ||
+						(query.Has("subname") && query.Get("subname") != rrset.Subname) ||
+						(query.Has("type") && query.Get("type") != rrset.Type)
+				})
+				body, err := json.Marshal(rrsets)
+				assert.NoError(t, err)
+				_, err = w.Write(body)
+				assert.NoError(t, err)
+			case "POST":
+				body, err := io.ReadAll(r.Body)
+				assert.NoError(t, err)
+				rrset := desec.RRSet{}
+				assert.NoError(t, json.Unmarshal(body, &rrset))
+				rrset.Domain = domain
+				mock.rrsets = append(mock.rrsets, rrset)
+				w.WriteHeader(201)
+				_, err = w.Write(body)
+				assert.NoError(t, err)
+			case "PATCH":
+				mock.bulkRequests = mock.bulkRequests + 1
+				body, err := io.ReadAll(r.Body)
+				assert.NoError(t, err)
+				changes := []desec.RRSet{}
+				assert.NoError(t, json.Unmarshal(body, &changes))
+				itemErrors := []desec.FieldErrors{}
+				for _, change := range changes {
+					itemErrors = append(itemErrors, mock.invalid[change.Subname])
+				}
+				if slices.ContainsFunc(itemErrors, func(item desec.FieldErrors) bool { return len(item) > 0 }) {
+					body, err = json.Marshal(itemErrors)
+					assert.NoError(t, err)
+					w.WriteHeader(400)
+					_, err = w.Write(body)
+					assert.NoError(t, err)
+					return
+				}
+				upserted := []desec.RRSet{}
+				for _, change := range changes {
+					change.Created = "2023-01-01T00:00:00Z"
+					index := slices.IndexFunc(mock.rrsets, func(rrset desec.RRSet) bool {
+						return inDomain(rrset) && rrset.Subname == change.Subname && rrset.Type == change.Type
+					})
+					if index >= 0 {
+						change.Created = mock.rrsets[index].Created
+						mock.rrsets = slices.Delete(mock.rrsets, index, index+1)
+					}
+					if len(change.Records) > 0 {
+						mock.touched = mock.touched + 1
+						change.Touched = fmt.Sprintf("2023-01-01T00:00:%02dZ", mock.touched)
+						change.Domain = domain
+						change.Name = change.Subname + "." + domain + "."
+						mock.rrsets = append(mock.rrsets, change)
+						upserted = append(upserted, change)
+					}
+				}
+				body, err = json.Marshal(upserted)
+				assert.NoError(t, err)
+				_, err = w.Write(body)
+				assert.NoError(t, err)
+			default:
+				t.Fail()
+			}
+		case !isRRSets && r.Method == "DELETE":
+			domain = strings.TrimSuffix(domain, "/")
+			mock.domains = slices.DeleteFunc(mock.domains, func(d desec.Domain) bool { return d.Name == domain })
+			mock.rrsets = slices.DeleteFunc(mock.rrsets, inDomain)
+			w.WriteHeader(204)
+		case isRRSets && r.Method == "DELETE":
+			key := strings.Split(strings.Trim(rrsetKey, "/"), "/")
+			assert.Len(t, key, 2)
+			if key[0] == "@" {
+				key[0] = ""
+			}
+			mock.rrsets = slices.DeleteFunc(mock.rrsets, func(rrset desec.RRSet) bool {
+				return inDomain(rrset) && rrset.Subname == key[0] && rrset.Type == key[1]
+			})
+			w.WriteHeader(204)
+		default:
+			t.Fail()
+		}
+	}))
+}
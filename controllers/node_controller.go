@@ -0,0 +1,76 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/j-be/desec-dns-operator/controllers/config"
+)
+
+// nodesRequest is the only request of the NodeReconciler, as a change of any
+// node affects all domains alike.
+var nodesRequest = reconcile.Request{NamespacedName: types.NamespacedName{Name: "nodes"}}
+
+// NodeReconciler publishes the addresses of nodes as the IPs of all managed
+// domains, whether or not any source publishes hosts in them.
+type NodeReconciler struct {
+	IngressReconciler
+}
+
+// Reconcile syncs all managed domains.
+func (r *NodeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, err error) {
+	defer func() { result, err = requeueIfThrottled(ctx, result, err) }()
+	log := log.FromContext(ctx)
+
+	log.Info("Starting", "req", req)
+
+	desecConfig, err := config.NewConfigFor(r.ConfigDir)
+	if err != nil {
+		log.Error(err, "Failed to read the configuration")
+		return ctrl.Result{}, err
+	}
+	managedDomains, err := getManagedDomains(ctx, r.Client, desecConfig)
+	if err != nil {
+		log.Error(err, "Failed to list domains")
+		return ctrl.Result{}, err
+	}
+	names := []string{}
+	for _, domain := range managedDomains {
+		names = append(names, domain.name)
+	}
+	return r.syncDomains(ctx, desecConfig, managedDomains, names)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *NodeReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.ConfigDir = "./mnt"
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("node").
+		Watches(&corev1.Node{}, handler.EnqueueRequestsFromMapFunc(func(context.Context, client.Object) []reconcile.Request {
+			return []reconcile.Request{nodesRequest}
+		}), r.nodeIPsChanged()).
+		Complete(r)
+}
@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	v1 "github.com/j-be/desec-dns-operator/api/v1"
+	"github.com/j-be/desec-dns-operator/controllers/desec"
+	"github.com/j-be/desec-dns-operator/controllers/util"
+)
+
+func TestNodeReconciler(t *testing.T) {
+	t.Run("Domain without sources", func(t *testing.T) {
+		// Given
+		mock := &desecMock{domains: []desec.Domain{{Name: "some-domain.dedyn.io"}}}
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		ingressReconciler := createIngressReconciler(t, server.URL, createNode("node-1", true, "", "5.6.7.8"))
+		ingressReconciler.NodeAddressType = corev1.NodeExternalIP
+		assert.NoError(t, ingressReconciler.Delete(context.TODO(), &netv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "some-ingress", Namespace: "some-namespace"}}))
+		reconciler := NodeReconciler{IngressReconciler: ingressReconciler}
+		// When
+		reconcileIngress(t, &reconciler, nodesRequest)
+		// Then
+		dnsCr := new(v1.DesecDns)
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		assert.Equal(t, []string{"5.6.7.8"}, dnsCr.Spec.IPs)
+
+		// When
+		node := new(corev1.Node)
+		assert.NoError(t, reconciler.Get(context.TODO(), types.NamespacedName{Name: "node-1"}, node))
+		node.Status.Addresses[1].Address = "6.7.8.9"
+		assert.NoError(t, reconciler.Status().Update(context.TODO(), node))
+		reconcileIngress(t, &reconciler, nodesRequest)
+		// Then
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		assert.Equal(t, []string{"6.7.8.9"}, dnsCr.Spec.IPs)
+	})
+}
@@ -0,0 +1,239 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	v1 "github.com/j-be/desec-dns-operator/api/v1"
+	"github.com/j-be/desec-dns-operator/controllers/config"
+	"github.com/j-be/desec-dns-operator/controllers/desec"
+	"github.com/j-be/desec-dns-operator/controllers/util"
+)
+
+// TokenSecretLabel marks the Secrets holding tokens, i.e. the ones referenced
+// by DesecAccounts and the ones of DesecTokens. Only those are cached and
+// read, so no other Secret is ever sent to deSEC.
+const TokenSecretLabel = "desec.owly.dedyn.io/token-secret"
+
+// requeueIfThrottled turns a throttling error of deSEC into a requeue once
+// deSEC accepts requests again, instead of retrying right away.
+func requeueIfThrottled(ctx context.Context, result ctrl.Result, err error) (ctrl.Result, error) {
+	throttled := new(desec.ThrottledError)
+	if !errors.As(err, &throttled) {
+		return result, err
+	}
+
+	log.FromContext(ctx).Info("Throttled by deSEC", "class", throttled.Class, "retryAfter", throttled.RetryAfter)
+	return ctrl.Result{RequeueAfter: throttled.RetryAfter}, nil
+}
+
+// newDesecClient creates a client for the domain using the DesecAccount, or
+// the credentials mounted to configDir if no account is given. The token is
+// read on every call, so a rotated token is used right away. A
+// *ForbiddenError is returned if the account does not allow the namespace of
+// the object the client is created for.
+func newDesecClient(ctx context.Context, c client.Client, configDir string, account string, namespace string, domain string, opts ...desec.Option) (desec.Client, error) {
+	if account == "" {
+		return desec.NewClient(domain, configDir, opts...)
+	}
+
+	desecAccount := new(v1.DesecAccount)
+	if err := c.Get(ctx, types.NamespacedName{Name: account}, desecAccount); err != nil {
+		return desec.Client{}, err
+	}
+	desecConfig, err := config.NewConfigFor(configDir)
+	if err != nil {
+		return desec.Client{}, err
+	}
+	if !slices.Contains(accountNamespaces(desecAccount, desecConfig), namespace) {
+		return desec.Client{}, &ForbiddenError{Account: account, Namespace: namespace}
+	}
+	token, err := getToken(ctx, c, desecAccount.Spec.TokenSecretRef)
+	if err != nil {
+		return desec.Client{}, err
+	}
+	return desec.NewClientFor(domain, desec.Account{
+		Token:        token,
+		MgmtHost:     desecAccount.Spec.MgmtHost,
+		UpdateIpHost: desecAccount.Spec.UpdateIpHost,
+	}, opts...), nil
+}
+
+// ForbiddenError tells a DesecAccount does not allow a namespace to use it.
+type ForbiddenError struct {
+	Account   string
+	Namespace string
+}
+
+func (e *ForbiddenError) Error() string {
+	return fmt.Sprintf("DesecAccount %s does not list namespace %s in namespaces", e.Account, e.Namespace)
+}
+
+// isForbidden reports whether the error is a *ForbiddenError.
+func isForbidden(err error) bool {
+	forbidden := new(ForbiddenError)
+	return errors.As(err, &forbidden)
+}
+
+// accountNamespaces returns the namespaces allowed to use the DesecAccount,
+// including the one of the operator.
+func accountNamespaces(account *v1.DesecAccount, desecConfig config.Config) []string {
+	return slices.Concat([]string{desecConfig.Namespace}, account.Spec.Namespaces, account.Spec.TokenManagerNamespaces)
+}
+
+// managedDomain is a domain sources, like ingresses, may have hosts in.
+type managedDomain struct {
+	name           string
+	creationPolicy v1.CreationPolicy
+	adoptionPolicy v1.AdoptionPolicy
+	ttl            int64
+	account        string
+	// namespaces of the sources allowed to use the account, any if nil
+	namespaces []string
+	// dryRun is set if the DesecDomain asks for a dry-run
+	dryRun bool
+	// createdDomain is set if the DesecDomain created the domain on deSEC
+	createdDomain bool
+}
+
+// getManagedDomains returns the domains declared by DesecDomains, and the one
+// from the configuration, if any.
+func getManagedDomains(ctx context.Context, c client.Reader, desecConfig config.Config) ([]managedDomain, error) {
+	desecDomains := v1.DesecDomainList{}
+	if err := c.List(ctx, &desecDomains, client.InNamespace(desecConfig.Namespace)); err != nil {
+		return nil, err
+	}
+
+	managedDomains := []managedDomain{}
+	for _, desecDomain := range desecDomains.Items {
+		var namespaces []string
+		if desecDomain.Spec.Account != "" {
+			// Without the account, nobody else may use it
+			account := new(v1.DesecAccount)
+			if err := c.Get(ctx, types.NamespacedName{Name: desecDomain.Spec.Account}, account); client.IgnoreNotFound(err) != nil {
+				return nil, err
+			}
+			namespaces = accountNamespaces(account, desecConfig)
+		}
+		managedDomains = append(managedDomains, managedDomain{
+			name:           desecDomain.Name,
+			creationPolicy: desecDomain.Spec.CreationPolicy,
+			adoptionPolicy: desecDomain.Spec.AdoptionPolicy,
+			ttl:            desecDomain.Spec.TTL,
+			account:        desecDomain.Spec.Account,
+			namespaces:     namespaces,
+			dryRun:         isAnnotatedDryRun(&desecDomain),
+			createdDomain:  desecDomain.Status.CreatedDomain,
+		})
+	}
+	if desecConfig.Domain != "" && !slices.ContainsFunc(managedDomains, func(domain managedDomain) bool { return domain.name == desecConfig.Domain }) {
+		managedDomains = append(managedDomains, managedDomain{
+			name:           desecConfig.Domain,
+			creationPolicy: v1.CreationPolicyIfNotPresent,
+			adoptionPolicy: v1.AdoptionPolicy(desecConfig.AdoptionPolicy),
+		})
+	}
+	return managedDomains, nil
+}
+
+// domainsFor returns the names of the domains hosts of the object may be
+// routed to, i.e. those of the DesecAccount it selects, if any, and whose
+// account allows the namespace of the object.
+func domainsFor(obj client.Object, managedDomains []managedDomain) []string {
+	account, selected := obj.GetAnnotations()[accountAnnotation]
+	domainNames := []string{}
+	for _, domain := range managedDomains {
+		allowed := domain.namespaces == nil || slices.Contains(domain.namespaces, obj.GetNamespace())
+		if allowed && (!selected || domain.account == account) {
+			domainNames = append(domainNames, domain.name)
+		}
+	}
+	return domainNames
+}
+
+// publishedDomains returns the sorted names of the domains the object has
+// hosts in, either subnames or the domain itself.
+func publishedDomains(obj client.Object, managedDomains []managedDomain) []string {
+	hosts := hostsOf(obj)
+	domainNames := domainsFor(obj, managedDomains)
+	published := []string{}
+	for _, domain := range domainNames {
+		if len(util.GetSubnames(hosts, domain, domainNames...)) > 0 || util.ServesApex(hosts, domain) {
+			published = append(published, domain)
+		}
+	}
+	slices.Sort(published)
+	return published
+}
+
+// previousDomains returns the domains the object published its hosts in
+// before, according to its annotation.
+func previousDomains(obj client.Object) []string {
+	annotation := obj.GetAnnotations()[domainsAnnotation]
+	if annotation == "" {
+		return nil
+	}
+	return strings.Split(annotation, ",")
+}
+
+// setPreviousDomains records the domains the object published its hosts in.
+func setPreviousDomains(obj client.Object, domains []string) {
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	delete(annotations, domainsAnnotation)
+	if len(domains) > 0 {
+		annotations[domainsAnnotation] = strings.Join(domains, ",")
+	}
+	obj.SetAnnotations(annotations)
+}
+
+// getToken reads the token from the referenced Secret.
+func getToken(ctx context.Context, c client.Client, ref v1.SecretKeyReference) (string, error) {
+	secret := new(corev1.Secret)
+	if err := c.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: ref.Namespace}, secret); err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", fmt.Errorf("secret %s/%s not found, or not labeled %s=true", ref.Namespace, ref.Name, TokenSecretLabel)
+		}
+		return "", err
+	}
+	if secret.Labels[TokenSecretLabel] != "true" {
+		return "", fmt.Errorf("secret %s/%s is not labeled %s=true", ref.Namespace, ref.Name, TokenSecretLabel)
+	}
+	key := ref.Key
+	if key == "" {
+		key = "token"
+	}
+	token, ok := secret.Data[key]
+	if !ok || len(token) == 0 {
+		return "", fmt.Errorf("secret %s/%s has no key %q", ref.Namespace, ref.Name, key)
+	}
+	return string(token), nil
+}
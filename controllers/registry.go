@@ -0,0 +1,153 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/j-be/desec-dns-operator/controllers/desec"
+)
+
+const (
+	// registryPrefix is the first label of the subnames of the TXT records
+	// telling the owner of an RRSet
+	registryPrefix = "_desec-owner"
+	// registryHeritage tells the TXT records of the registry apart from any
+	// other ones
+	registryHeritage = "desec-dns-operator"
+	// registryVersion is the version of the format of the TXT records
+	registryVersion = "1"
+)
+
+// registry tells the RRSets owned by this operator apart from the ones of
+// others, by the TXT records published along with each of them. Without an
+// owner ID, all existing RRSets are considered owned.
+type registry struct {
+	ownerID string
+	rrsets  []desec.RRSet
+}
+
+// owner is the content of a TXT record of the registry.
+type owner struct {
+	id       string
+	resource string
+	version  string
+}
+
+// registrySubname returns the subname of the TXT record telling the owner of
+// the RRSet, e.g. _desec-owner.cname.www. A wildcard is replaced, as the TXT
+// record would match all hosts otherwise.
+func registrySubname(subname string, rrType string) string {
+	name := registryPrefix + "." + strings.ToLower(rrType)
+	if subname == "" {
+		return name
+	}
+	if subname == "*" || strings.HasPrefix(subname, "*.") {
+		subname = "_wildcard" + strings.TrimPrefix(subname, "*")
+	}
+	return name + "." + subname
+}
+
+// ownerOf returns the owner recorded for the RRSet, if any.
+func (g registry) ownerOf(subname string, rrType string) (owner, bool) {
+	txt := findRRSet(g.rrsets, registrySubname(subname, rrType), "TXT")
+	if txt == nil {
+		return owner{}, false
+	}
+	for _, record := range txt.Records {
+		if o, ok := parseOwner(record); ok {
+			return o, true
+		}
+	}
+	return owner{}, false
+}
+
+// owns reports whether the RRSet exists and is owned by this operator. RRSets
+// without owner, which were tracked before the registry was enabled, are
+// owned as well.
+func (g registry) owns(subname string, rrType string, tracked bool) bool {
+	if findRRSet(g.rrsets, subname, rrType) == nil {
+		return false
+	}
+	if g.ownerID == "" {
+		return true
+	}
+	o, ok := g.ownerOf(subname, rrType)
+	return (ok && o.id == g.ownerID) || (!ok && tracked)
+}
+
+// conflict describes why the existing RRSet is not owned by this operator,
+// empty if it is owned, or does not exist at all.
+func (g registry) conflict(subname string, rrType string, tracked bool) string {
+	if findRRSet(g.rrsets, subname, rrType) == nil || g.owns(subname, rrType, tracked) {
+		return ""
+	}
+	if o, ok := g.ownerOf(subname, rrType); ok {
+		return fmt.Sprintf("%s owned by %s (%s)", rrType, o.id, o.resource)
+	}
+	return rrType + " not owned by the operator"
+}
+
+// claim returns the TXT RRSet recording the source as owner of the RRSet, if
+// it is not already.
+func (g registry) claim(subname string, rrType string, resource string, ttl int64) (desec.RRSet, bool) {
+	if g.ownerID == "" {
+		return desec.RRSet{}, false
+	}
+	record := formatOwner(owner{id: g.ownerID, resource: resource, version: registryVersion})
+	txtSubname := registrySubname(subname, rrType)
+	existing := findRRSet(g.rrsets, txtSubname, "TXT")
+	if existing != nil && existing.TTL == ttl && len(existing.Records) == 1 && existing.Records[0] == record {
+		return desec.RRSet{}, false
+	}
+	return desec.RRSet{Subname: txtSubname, Type: "TXT", Records: []string{record}, TTL: ttl}, true
+}
+
+// release returns the TXT RRSet removing the owner of the RRSet, if owned by
+// this operator.
+func (g registry) release(subname string, rrType string) (desec.RRSet, bool) {
+	txtSubname := registrySubname(subname, rrType)
+	existing := findRRSet(g.rrsets, txtSubname, "TXT")
+	if g.ownerID == "" || existing == nil {
+		return desec.RRSet{}, false
+	}
+	if o, ok := g.ownerOf(subname, rrType); !ok || o.id != g.ownerID {
+		return desec.RRSet{}, false
+	}
+	return desec.RRSet{Subname: txtSubname, Type: "TXT", Records: []string{}, TTL: existing.TTL}, true
+}
+
+// formatOwner returns the quoted TXT record of the owner, e.g.
+// "heritage=desec-dns-operator,owner=default,resource=ingress/ns/name,version=1".
+func formatOwner(o owner) string {
+	return fmt.Sprintf(`"heritage=%s,owner=%s,resource=%s,version=%s"`, registryHeritage, o.id, o.resource, o.version)
+}
+
+// parseOwner parses a TXT record of the registry, records of others are
+// rejected.
+func parseOwner(record string) (owner, bool) {
+	fields := map[string]string{}
+	for _, field := range strings.Split(strings.Trim(record, `"`), ",") {
+		key, value, _ := strings.Cut(field, "=")
+		fields[key] = value
+	}
+	if fields["heritage"] != registryHeritage || fields["owner"] == "" {
+		return owner{}, false
+	}
+	return owner{id: fields["owner"], resource: fields["resource"], version: fields["version"]}, true
+}
@@ -0,0 +1,300 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"regexp"
+	"slices"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	v1 "github.com/j-be/desec-dns-operator/api/v1"
+)
+
+const gatewayGroup = "gateway.networking.k8s.io"
+
+var gatewayGVK = schema.GroupVersionKind{Group: gatewayGroup, Version: "v1", Kind: "Gateway"}
+
+// routeGVKs are the Gateway API routes with hostnames, by kind. They are
+// handled as unstructured, to not depend on the Gateway API.
+var routeGVKs = map[string]schema.GroupVersionKind{
+	"HTTPRoute": {Group: gatewayGroup, Version: "v1", Kind: "HTTPRoute"},
+	"GRPCRoute": {Group: gatewayGroup, Version: "v1", Kind: "GRPCRoute"},
+	"TLSRoute":  {Group: gatewayGroup, Version: "v1alpha2", Kind: "TLSRoute"},
+}
+
+// RouteGVK returns the GVK of the Gateway API route kind, or false if the
+// kind has no hostnames.
+func RouteGVK(kind string) (schema.GroupVersionKind, bool) {
+	gvk, ok := routeGVKs[kind]
+	return gvk, ok
+}
+
+// TraefikIngressRouteGVK is the kind of Traefik's IngressRoute, whose hosts
+// are taken from the Host rules of its routes.
+var TraefikIngressRouteGVK = schema.GroupVersionKind{Group: "traefik.io", Version: "v1alpha1", Kind: "IngressRoute"}
+
+// OpenShiftRouteGVK is the kind of OpenShift's Route, whose host is published
+// pointing at the router.
+var OpenShiftRouteGVK = schema.GroupVersionKind{Group: "route.openshift.io", Version: "v1", Kind: "Route"}
+
+// traefikHostRule matches Host rules, and traefikHost the hosts within them.
+var (
+	traefikHostRule = regexp.MustCompile("(?:^|[^A-Za-z])Host\\(([^)]*)\\)")
+	traefikHost     = regexp.MustCompile("`([^`]*)`|\"([^\"]*)\"")
+)
+
+func isRoute(gvk schema.GroupVersionKind) bool {
+	return (gvk.Group == gatewayGroup && routeGVKs[gvk.Kind] == gvk) || gvk == TraefikIngressRouteGVK || gvk == OpenShiftRouteGVK
+}
+
+// RouteReconciler publishes the hostnames of a kind of routes, i.e. Gateway
+// API routes, Traefik IngressRoutes or OpenShift Routes, the same way
+// IngressReconciler does for ingresses.
+type RouteReconciler struct {
+	IngressReconciler
+	// GVK of the routes reconciled, see RouteGVK, TraefikIngressRouteGVK and
+	// OpenShiftRouteGVK
+	GVK schema.GroupVersionKind
+}
+
+//+kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=httproutes;grpcroutes;tlsroutes,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=httproutes/finalizers;grpcroutes/finalizers;tlsroutes/finalizers,verbs=update
+//+kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=gateways,verbs=get;list;watch
+//+kubebuilder:rbac:groups=traefik.io,resources=ingressroutes,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=traefik.io,resources=ingressroutes/finalizers,verbs=update
+//+kubebuilder:rbac:groups=route.openshift.io,resources=routes,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=route.openshift.io,resources=routes/finalizers,verbs=update
+
+// Reconcile publishes the hostnames of a route, along with the hosts of all
+// other sources in the same domains.
+func (r *RouteReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	return r.reconcileSource(ctx, req, r.newRoute())
+}
+
+func (r *RouteReconciler) newRoute() *unstructured.Unstructured {
+	route := new(unstructured.Unstructured)
+	route.SetGroupVersionKind(r.GVK)
+	return route
+}
+
+// getRouteHosts returns the hostnames of the route.
+func getRouteHosts(route *unstructured.Unstructured) []string {
+	switch route.GroupVersionKind() {
+	case TraefikIngressRouteGVK:
+		return getTraefikHosts(route)
+	case OpenShiftRouteGVK:
+		host, _, _ := unstructured.NestedString(route.Object, "spec", "host")
+		if host == "" {
+			return nil
+		}
+		return []string{host}
+	}
+	hostnames, _, _ := unstructured.NestedStringSlice(route.Object, "spec", "hostnames")
+	return hostnames
+}
+
+// getTraefikHosts returns the hosts of the Host rules in the match of each
+// route of the IngressRoute, e.g. Host(`example.com`) && PathPrefix(`/api`).
+func getTraefikHosts(route *unstructured.Unstructured) []string {
+	routes, _, _ := unstructured.NestedSlice(route.Object, "spec", "routes")
+	hosts := []string{}
+	for _, route := range routes {
+		route, ok := route.(map[string]any)
+		if !ok {
+			continue
+		}
+		match, _, _ := unstructured.NestedString(route, "match")
+		for _, rule := range traefikHostRule.FindAllStringSubmatch(match, -1) {
+			for _, host := range traefikHost.FindAllStringSubmatch(rule[1], -1) {
+				if host := host[1] + host[2]; host != "" && !slices.Contains(hosts, host) {
+					hosts = append(hosts, host)
+				}
+			}
+		}
+	}
+	return hosts
+}
+
+// getRouteSource returns the hostnames of the route, along with the addresses
+// serving it. Those are the addresses of the parent gateways of Gateway API
+// routes, and the router of OpenShift Routes. Traefik does not report any,
+// so its hosts point at the domain. Gateways are cached in gateways, as routes
+// commonly share them.
+func (r *IngressReconciler) getRouteSource(ctx context.Context, route *unstructured.Unstructured, gateways map[client.ObjectKey]*unstructured.Unstructured) (hostSource, error) {
+	source := hostSource{Object: route, hosts: getRouteHosts(route), ips: []string{}, hostnames: []string{}}
+	switch route.GroupVersionKind() {
+	case TraefikIngressRouteGVK:
+		return source, nil
+	case OpenShiftRouteGVK:
+		ingresses, _, _ := unstructured.NestedSlice(route.Object, "status", "ingress")
+		for _, ingress := range ingresses {
+			ingress, ok := ingress.(map[string]any)
+			if !ok {
+				continue
+			}
+			hostname, _, _ := unstructured.NestedString(ingress, "routerCanonicalHostname")
+			if hostname != "" && !slices.Contains(source.hostnames, hostname) {
+				source.hostnames = append(source.hostnames, hostname)
+			}
+		}
+		return source, nil
+	}
+
+	parentRefs, _, _ := unstructured.NestedSlice(route.Object, "spec", "parentRefs")
+	for _, parentRef := range parentRefs {
+		ref, ok := parentRef.(map[string]any)
+		if !ok {
+			continue
+		}
+		group, found, _ := unstructured.NestedString(ref, "group")
+		if !found {
+			group = gatewayGroup
+		}
+		kind, found, _ := unstructured.NestedString(ref, "kind")
+		if !found {
+			kind = gatewayGVK.Kind
+		}
+		if group != gatewayGroup || kind != gatewayGVK.Kind {
+			continue
+		}
+		key := client.ObjectKey{Namespace: route.GetNamespace()}
+		key.Name, _, _ = unstructured.NestedString(ref, "name")
+		if namespace, found, _ := unstructured.NestedString(ref, "namespace"); found {
+			key.Namespace = namespace
+		}
+
+		gateway, ok := gateways[key]
+		if !ok {
+			gateway = new(unstructured.Unstructured)
+			gateway.SetGroupVersionKind(gatewayGVK)
+			if err := r.Get(ctx, key, gateway); err != nil {
+				if !apierrors.IsNotFound(err) {
+					return hostSource{}, err
+				}
+				gateway = nil
+			}
+			gateways[key] = gateway
+		}
+		if gateway == nil {
+			continue
+		}
+
+		addresses, _, _ := unstructured.NestedSlice(gateway.Object, "status", "addresses")
+		for _, address := range addresses {
+			address, ok := address.(map[string]any)
+			if !ok {
+				continue
+			}
+			addressType, found, _ := unstructured.NestedString(address, "type")
+			if !found {
+				addressType = "IPAddress"
+			}
+			value, _, _ := unstructured.NestedString(address, "value")
+			switch {
+			case value == "":
+			case addressType == "IPAddress" && !slices.Contains(source.ips, value):
+				source.ips = append(source.ips, value)
+			case addressType == "Hostname" && !slices.Contains(source.hostnames, value):
+				source.hostnames = append(source.hostnames, value)
+			}
+		}
+	}
+	return source, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *RouteReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.ConfigDir = "./mnt"
+	builder := ctrl.NewControllerManagedBy(mgr).
+		For(r.newRoute()).
+		Watches(&v1.DesecDomain{}, handler.EnqueueRequestsFromMapFunc(r.routesIn), domainChanged).
+		Watches(&v1.DesecAccount{}, handler.EnqueueRequestsFromMapFunc(r.routesIn), accountChanged)
+	if r.GVK.Group == gatewayGroup {
+		gateway := new(unstructured.Unstructured)
+		gateway.SetGroupVersionKind(gatewayGVK)
+		builder = builder.Watches(gateway, handler.EnqueueRequestsFromMapFunc(r.allRoutes), gatewayAddressesChanged)
+	}
+	return builder.Complete(r)
+}
+
+// gatewayAddressesChanged only passes updates of gateways changing their
+// addresses, which are all routes take from them.
+var gatewayAddressesChanged = builder.WithPredicates(predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldGateway, oldOk := e.ObjectOld.(*unstructured.Unstructured)
+		newGateway, newOk := e.ObjectNew.(*unstructured.Unstructured)
+		if !oldOk || !newOk {
+			return true
+		}
+		oldAddresses, _, _ := unstructured.NestedSlice(oldGateway.Object, "status", "addresses")
+		newAddresses, _, _ := unstructured.NestedSlice(newGateway.Object, "status", "addresses")
+		return !equality.Semantic.DeepEqual(oldAddresses, newAddresses)
+	},
+})
+
+// listRoutes returns all routes of the kind reconciled.
+func (r *RouteReconciler) listRoutes(ctx context.Context) ([]client.Object, error) {
+	routes := new(unstructured.UnstructuredList)
+	routes.SetGroupVersionKind(r.GVK.GroupVersion().WithKind(r.GVK.Kind + "List"))
+	if err := r.List(ctx, routes); err != nil {
+		return nil, err
+	}
+	objects := []client.Object{}
+	for _, route := range routes.Items {
+		objects = append(objects, &route)
+	}
+	return objects, nil
+}
+
+// allRoutes requests all routes to be reconciled, e.g. as the addresses of a
+// gateway changed.
+func (r *RouteReconciler) allRoutes(ctx context.Context, _ client.Object) []reconcile.Request {
+	routes, err := r.listRoutes(ctx)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list routes", "kind", r.GVK.Kind)
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, route := range routes {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(route)})
+	}
+	return requests
+}
+
+// routesIn requests the routes with hostnames in the domains affected by a
+// change of a DesecDomain or DesecAccount to be reconciled.
+func (r *RouteReconciler) routesIn(ctx context.Context, obj client.Object) []reconcile.Request {
+	routes, err := r.listRoutes(ctx)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list routes", "kind", r.GVK.Kind)
+		return nil
+	}
+	return requestsIn(routes, r.affectedDomains(ctx, obj))
+}
@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	v1 "github.com/j-be/desec-dns-operator/api/v1"
+	"github.com/j-be/desec-dns-operator/controllers/util"
+)
+
+var routeRequest = reconcile.Request{NamespacedName: types.NamespacedName{Name: "some-route", Namespace: "some-namespace"}}
+
+func TestRouteReconciler(t *testing.T) {
+	t.Run("Basic", func(t *testing.T) {
+		// Given
+		mock := new(desecMock)
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := RouteReconciler{
+			IngressReconciler: createIngressReconciler(t, server.URL,
+				createUnstructured(gatewayGVK, "some-gateway", map[string]any{
+					"status": map[string]any{"addresses": []any{
+						map[string]any{"value": "5.6.7.8"},
+						map[string]any{"type": "Hostname", "value": "lb.example.com"},
+					}},
+				}),
+				createUnstructured(routeGVKs["HTTPRoute"], routeRequest.Name, map[string]any{
+					"spec": map[string]any{
+						"hostnames":  []any{"api.some-domain.dedyn.io"},
+						"parentRefs": []any{map[string]any{"name": "some-gateway"}},
+					},
+				}),
+				createUnstructured(routeGVKs["TLSRoute"], "tls-route", map[string]any{
+					"spec": map[string]any{
+						"hostnames":  []any{"tls.some-domain.dedyn.io"},
+						"parentRefs": []any{map[string]any{"name": "other-gateway", "namespace": "other-namespace"}},
+					},
+				}),
+			),
+			GVK: routeGVKs["HTTPRoute"],
+		}
+		reconciler.Routes = []schema.GroupVersionKind{routeGVKs["HTTPRoute"], routeGVKs["TLSRoute"]}
+		// When
+		reconcileIngress(t, &reconciler, routeRequest)
+		// Then
+		dnsCr := new(v1.DesecDns)
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		assert.Equal(t, []string{"1.2.3.4", "2.3.4.5", "5.6.7.8"}, dnsCr.Spec.IPs)
+		assert.ElementsMatch(t, []string{"www", "git", "api", "tls"}, util.GetCnameSubnames(dnsCr.Status))
+		for _, subname := range []string{"api", "tls"} {
+			cname := findCname(mock.rrsets, subname)
+			if assert.NotNil(t, cname) {
+				assert.Equal(t, []string{"some-domain.dedyn.io."}, cname.Records)
+			}
+		}
+		route := reconciler.newRoute()
+		assert.NoError(t, reconciler.Get(context.TODO(), routeRequest.NamespacedName, route))
+		assert.Equal(t, []string{ingressFinalizer}, route.GetFinalizers())
+
+		// When
+		reconcileIngress(t, &reconciler.IngressReconciler, ingressRequest)
+		// Then
+		assert.NotNil(t, findCname(mock.rrsets, "api"))
+
+		// When
+		assert.NoError(t, reconciler.Delete(context.TODO(), route))
+		reconcileIngress(t, &reconciler, routeRequest)
+		// Then
+		assert.Nil(t, findCname(mock.rrsets, "api"))
+		assert.NotNil(t, findCname(mock.rrsets, "tls"))
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		assert.Equal(t, []string{"1.2.3.4", "2.3.4.5"}, dnsCr.Spec.IPs)
+	})
+
+	t.Run("Traefik IngressRoute", func(t *testing.T) {
+		// Given
+		mock := new(desecMock)
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := RouteReconciler{
+			IngressReconciler: createIngressReconciler(t, server.URL,
+				createUnstructured(TraefikIngressRouteGVK, routeRequest.Name, map[string]any{
+					"spec": map[string]any{"routes": []any{
+						map[string]any{"match": "Host(`traefik.some-domain.dedyn.io`) && PathPrefix(`/api`)"},
+						map[string]any{"match": "Host(`a.some-domain.dedyn.io`, `b.some-domain.dedyn.io`) || HostRegexp(`.+\\.example\\.com`)"},
+					}},
+				}),
+			),
+			GVK: TraefikIngressRouteGVK,
+		}
+		reconciler.Routes = []schema.GroupVersionKind{TraefikIngressRouteGVK}
+		// When
+		reconcileIngress(t, &reconciler, routeRequest)
+		// Then
+		dnsCr := new(v1.DesecDns)
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		assert.ElementsMatch(t, []string{"www", "git", "traefik", "a", "b"}, util.GetCnameSubnames(dnsCr.Status))
+		for _, subname := range []string{"traefik", "a", "b"} {
+			cname := findCname(mock.rrsets, subname)
+			if assert.NotNil(t, cname, subname) {
+				assert.Equal(t, []string{"some-domain.dedyn.io."}, cname.Records)
+			}
+		}
+	})
+
+	t.Run("OpenShift Route", func(t *testing.T) {
+		// Given
+		mock := new(desecMock)
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := RouteReconciler{
+			IngressReconciler: createIngressReconciler(t, server.URL,
+				createUnstructured(OpenShiftRouteGVK, routeRequest.Name, map[string]any{
+					"spec":   map[string]any{"host": "shop.some-domain.dedyn.io"},
+					"status": map[string]any{"ingress": []any{map[string]any{"routerCanonicalHostname": "router.apps.example.com"}}},
+				}),
+			),
+			GVK: OpenShiftRouteGVK,
+		}
+		reconciler.Routes = []schema.GroupVersionKind{OpenShiftRouteGVK}
+		// When
+		reconcileIngress(t, &reconciler, routeRequest)
+		// Then
+		cname := findCname(mock.rrsets, "shop")
+		if assert.NotNil(t, cname) {
+			assert.Equal(t, []string{"router.apps.example.com."}, cname.Records)
+		}
+		route := reconciler.newRoute()
+		assert.NoError(t, reconciler.Get(context.TODO(), routeRequest.NamespacedName, route))
+		assert.Equal(t, []string{ingressFinalizer}, route.GetFinalizers())
+	})
+}
+
+func createUnstructured(gvk schema.GroupVersionKind, name string, content map[string]any) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: content}
+	obj.SetGroupVersionKind(gvk)
+	obj.SetName(name)
+	obj.SetNamespace("some-namespace")
+	return obj
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// hostnameAnnotation holds the comma separated hosts a service of type
+// LoadBalancer is published at.
+const hostnameAnnotation = "desec.owly.dedyn.io/hostname"
+
+// Annotations of a service holding the priority and weight of its SRV
+// records, both default to 0.
+const (
+	srvPriorityAnnotation = "desec.owly.dedyn.io/srv-priority"
+	srvWeightAnnotation   = "desec.owly.dedyn.io/srv-weight"
+)
+
+// srvRecord is the SRV record of a port of a service, without the target.
+type srvRecord struct {
+	// label is the first part of the subname, e.g. _minecraft._tcp
+	label    string
+	priority int
+	weight   int
+	port     int32
+}
+
+// forHost returns the SRV record pointing at the host.
+func (s srvRecord) forHost(host string) string {
+	return fmt.Sprintf("%d %d %d %s.", s.priority, s.weight, s.port, strings.TrimRight(host, "."))
+}
+
+// ServiceReconciler publishes services of type LoadBalancer at the hosts given
+// by their annotation. Unlike ingresses, each host gets A and AAAA records of
+// the service's own load balancers, instead of a CNAME to the domain, as well
+// as SRV records for its named ports.
+type ServiceReconciler struct {
+	IngressReconciler
+}
+
+//+kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups="",resources=services/finalizers,verbs=update
+
+// Reconcile publishes the hosts of a service, along with the hosts of all
+// other sources in the same domains.
+func (r *ServiceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	return r.reconcileSource(ctx, req, new(corev1.Service))
+}
+
+// getServiceHosts returns the hosts of the annotation of the service, if it is
+// of type LoadBalancer.
+func getServiceHosts(service corev1.Service) []string {
+	annotation := service.Annotations[hostnameAnnotation]
+	if service.Spec.Type != corev1.ServiceTypeLoadBalancer || annotation == "" {
+		return nil
+	}
+	hosts := []string{}
+	for _, host := range strings.Split(annotation, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// getServiceSRVs returns the SRV records of the named ports of the service.
+func getServiceSRVs(ctx context.Context, service corev1.Service) []srvRecord {
+	priority := getIntAnnotation(ctx, service, srvPriorityAnnotation)
+	weight := getIntAnnotation(ctx, service, srvWeightAnnotation)
+
+	srvs := []srvRecord{}
+	for _, port := range service.Spec.Ports {
+		if port.Name == "" {
+			continue
+		}
+		protocol := strings.ToLower(string(port.Protocol))
+		if protocol == "" {
+			protocol = "tcp"
+		}
+		srvs = append(srvs, srvRecord{
+			label:    "_" + port.Name + "._" + protocol,
+			priority: priority,
+			weight:   weight,
+			port:     port.Port,
+		})
+	}
+	return srvs
+}
+
+func getIntAnnotation(ctx context.Context, service corev1.Service, annotation string) int {
+	value, ok := service.Annotations[annotation]
+	if !ok {
+		return 0
+	}
+	parsed, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || parsed < 0 || parsed > 65535 {
+		log.FromContext(ctx).Info("Ignoring invalid annotation", "service", client.ObjectKeyFromObject(&service), "annotation", annotation, "value", value)
+		return 0
+	}
+	return parsed
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ServiceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.ConfigDir = "./mnt"
+	// Services are only of interest once annotated, or still holding records
+	published := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		_, annotated := obj.GetAnnotations()[hostnameAnnotation]
+		return annotated || controllerutil.ContainsFinalizer(obj, ingressFinalizer)
+	})
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&corev1.Service{}, builder.WithPredicates(published)).
+		Complete(r)
+}
@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	v1 "github.com/j-be/desec-dns-operator/api/v1"
+	"github.com/j-be/desec-dns-operator/controllers/util"
+)
+
+var serviceRequest = reconcile.Request{NamespacedName: types.NamespacedName{Name: "mqtt", Namespace: "some-namespace"}}
+
+func TestServiceReconciler(t *testing.T) {
+	t.Run("Basic", func(t *testing.T) {
+		// Given
+		mock := new(desecMock)
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := ServiceReconciler{IngressReconciler: createIngressReconciler(t, server.URL,
+			createLoadBalancerService(serviceRequest.Name, "mqtt.some-domain.dedyn.io, broker.some-domain.dedyn.io", "9.9.9.9", "2001:db8::1"),
+			// Not published until the load balancer is ready
+			createLoadBalancerService("pending", "pending.some-domain.dedyn.io"),
+		)}
+		// When
+		reconcileIngress(t, &reconciler, serviceRequest)
+		// Then
+		for _, subname := range []string{"mqtt", "broker"} {
+			a := findRRSet(mock.rrsets, subname, "A")
+			if assert.NotNil(t, a) {
+				assert.Equal(t, []string{"9.9.9.9"}, a.Records)
+			}
+			aaaa := findRRSet(mock.rrsets, subname, "AAAA")
+			if assert.NotNil(t, aaaa) {
+				assert.Equal(t, []string{"2001:db8::1"}, aaaa.Records)
+			}
+			assert.Nil(t, findCname(mock.rrsets, subname))
+		}
+		assert.Nil(t, findRRSet(mock.rrsets, "pending", "A"))
+		assert.Nil(t, findCname(mock.rrsets, "pending"))
+		dnsCr := new(v1.DesecDns)
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		// The IPs of the service are not merged into the ones of the domain
+		assert.Equal(t, []string{"1.2.3.4", "2.3.4.5"}, dnsCr.Spec.IPs)
+		condition := meta.FindStatusCondition(dnsCr.Status.Conditions, "mqtt")
+		assert.NotNil(t, condition)
+		assert.Equal(t, metav1.ConditionTrue, condition.Status)
+		assert.Equal(t, "Created", condition.Reason)
+		service := new(corev1.Service)
+		assert.NoError(t, reconciler.Get(context.TODO(), serviceRequest.NamespacedName, service))
+		assert.Equal(t, []string{ingressFinalizer}, service.Finalizers)
+
+		// When
+		service.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "8.8.8.8"}}
+		assert.NoError(t, reconciler.Status().Update(context.TODO(), service))
+		reconcileIngress(t, &reconciler, serviceRequest)
+		// Then
+		assert.Equal(t, []string{"8.8.8.8"}, findRRSet(mock.rrsets, "mqtt", "A").Records)
+		assert.Nil(t, findRRSet(mock.rrsets, "mqtt", "AAAA"))
+
+		// When
+		assert.NoError(t, reconciler.Get(context.TODO(), serviceRequest.NamespacedName, service))
+		assert.NoError(t, reconciler.Delete(context.TODO(), service))
+		reconcileIngress(t, &reconciler, serviceRequest)
+		// Then
+		for _, subname := range []string{"mqtt", "broker"} {
+			assert.Nil(t, findRRSet(mock.rrsets, subname, "A"))
+			assert.Nil(t, findRRSet(mock.rrsets, subname, "AAAA"))
+		}
+		assert.NotNil(t, findCname(mock.rrsets, "www"))
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		assert.Nil(t, meta.FindStatusCondition(dnsCr.Status.Conditions, "mqtt"))
+	})
+
+	t.Run("SRV records", func(t *testing.T) {
+		// Given
+		service := createLoadBalancerService(serviceRequest.Name, "mqtt.some-domain.dedyn.io,some-domain.dedyn.io", "9.9.9.9")
+		service.Annotations[srvPriorityAnnotation] = "10"
+		service.Annotations[srvWeightAnnotation] = "5"
+		service.Spec.Ports = []corev1.ServicePort{
+			{Name: "mqtt", Protocol: corev1.ProtocolTCP, Port: 1883},
+			{Name: "coap", Protocol: corev1.ProtocolUDP, Port: 5683},
+			{Port: 8080},
+		}
+		mock := new(desecMock)
+		server := createDesecServer(t, mock)
+		defer server.Close()
+		reconciler := ServiceReconciler{IngressReconciler: createIngressReconciler(t, server.URL, service)}
+		// When
+		reconcileIngress(t, &reconciler, serviceRequest)
+		// Then
+		expected := map[string][]string{
+			"_mqtt._tcp.mqtt": {"10 5 1883 mqtt.some-domain.dedyn.io."},
+			"_coap._udp.mqtt": {"10 5 5683 mqtt.some-domain.dedyn.io."},
+			"_mqtt._tcp":      {"10 5 1883 some-domain.dedyn.io."},
+			"_coap._udp":      {"10 5 5683 some-domain.dedyn.io."},
+		}
+		for subname, records := range expected {
+			srv := findRRSet(mock.rrsets, subname, "SRV")
+			if assert.NotNil(t, srv, subname) {
+				assert.Equal(t, records, srv.Records)
+			}
+		}
+		dnsCr := new(v1.DesecDns)
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		assert.Equal(t, []string{"_coap._udp", "_coap._udp.mqtt", "_mqtt._tcp", "_mqtt._tcp.mqtt"}, dnsCr.Status.SRVSubnames)
+		// The apex is served by the domain's IPs, including the ones of the service
+		assert.Equal(t, []string{"1.2.3.4", "2.3.4.5", "9.9.9.9"}, dnsCr.Spec.IPs)
+
+		// When
+		assert.NoError(t, reconciler.Get(context.TODO(), serviceRequest.NamespacedName, service))
+		service.Spec.Ports = service.Spec.Ports[:1]
+		assert.NoError(t, reconciler.Update(context.TODO(), service))
+		reconcileIngress(t, &reconciler, serviceRequest)
+		// Then
+		assert.Nil(t, findRRSet(mock.rrsets, "_coap._udp.mqtt", "SRV"))
+		assert.NotNil(t, findRRSet(mock.rrsets, "_mqtt._tcp.mqtt", "SRV"))
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		assert.Equal(t, []string{"_mqtt._tcp", "_mqtt._tcp.mqtt"}, dnsCr.Status.SRVSubnames)
+
+		// When
+		assert.NoError(t, reconciler.Delete(context.TODO(), service))
+		reconcileIngress(t, &reconciler, serviceRequest)
+		// Then
+		for _, rrset := range mock.rrsets {
+			assert.NotEqual(t, "SRV", rrset.Type)
+		}
+		assert.NoError(t, reconciler.Get(context.TODO(), util.NamespacedName, dnsCr))
+		assert.Empty(t, dnsCr.Status.SRVSubnames)
+	})
+}
+
+// createLoadBalancerService returns a service of type LoadBalancer published
+// at the hosts, with load balancers of the given IPs.
+func createLoadBalancerService(name string, hosts string, ips ...string) *corev1.Service {
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "some-namespace", Annotations: map[string]string{hostnameAnnotation: hosts}},
+		Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+	}
+	for _, ip := range ips {
+		service.Status.LoadBalancer.Ingress = append(service.Status.LoadBalancer.Ingress, corev1.LoadBalancerIngress{IP: ip})
+	}
+	return service
+}
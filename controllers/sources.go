@@ -0,0 +1,190 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	"github.com/j-be/desec-dns-operator/controllers/util"
+)
+
+// hostSource is an object publishing hosts, like an ingress, along with the
+// addresses of the load balancers serving them.
+type hostSource struct {
+	client.Object
+	hosts []string
+	ips   []string
+	// hostnames of load balancers, which publish a hostname instead of an IP
+	hostnames []string
+	// direct sources publish their IPs at their hosts, instead of merging them
+	// into the IPs of the domain
+	direct bool
+	// srvs are published for each host
+	srvs []srvRecord
+}
+
+// getSources returns all objects publishing hosts.
+func (r *IngressReconciler) getSources(ctx context.Context) ([]hostSource, error) {
+	sources := []hostSource{}
+
+	ingresses := networkingv1.IngressList{}
+	if err := r.List(ctx, &ingresses); err != nil {
+		return nil, err
+	}
+	for _, ingress := range ingresses.Items {
+		sources = append(sources, hostSource{
+			Object:    &ingress,
+			hosts:     util.GetHosts(ingress),
+			ips:       util.GetIps(ingress),
+			hostnames: util.GetHostnames(ingress),
+		})
+	}
+
+	services := corev1.ServiceList{}
+	if err := r.List(ctx, &services); err != nil {
+		return nil, err
+	}
+	for _, service := range services.Items {
+		hosts := getServiceHosts(service)
+		if len(hosts) == 0 {
+			continue
+		}
+		sources = append(sources, hostSource{
+			Object:    &service,
+			hosts:     hosts,
+			ips:       util.GetServiceIps(service),
+			hostnames: util.GetServiceHostnames(service),
+			direct:    true,
+			srvs:      getServiceSRVs(ctx, service),
+		})
+	}
+
+	gateways := map[client.ObjectKey]*unstructured.Unstructured{}
+	for _, gvk := range r.Routes {
+		routes := new(unstructured.UnstructuredList)
+		routes.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+		if err := r.List(ctx, routes); err != nil {
+			return nil, err
+		}
+		for _, route := range routes.Items {
+			source, err := r.getRouteSource(ctx, &route, gateways)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, source)
+		}
+	}
+	return sources, nil
+}
+
+// hostsOf returns the hosts the source object publishes.
+func hostsOf(source client.Object) []string {
+	switch source := source.(type) {
+	case *networkingv1.Ingress:
+		return util.GetHosts(*source)
+	case *corev1.Service:
+		return getServiceHosts(*source)
+	case *unstructured.Unstructured:
+		if isRoute(source.GroupVersionKind()) {
+			return getRouteHosts(source)
+		}
+	}
+	return nil
+}
+
+// resourceOf returns the kind, namespace and name of the source, e.g.
+// ingress/some-namespace/some-ingress.
+func (r *IngressReconciler) resourceOf(source client.Object) string {
+	kind := source.GetObjectKind().GroupVersionKind().Kind
+	if gvk, err := apiutil.GVKForObject(source, r.Scheme); err == nil {
+		kind = gvk.Kind
+	}
+	return strings.ToLower(kind) + "/" + source.GetNamespace() + "/" + source.GetName()
+}
+
+// getNodeIPs returns the addresses of all ready nodes matching the selector,
+// if nodes are used at all. Those serve clusters without a load balancer,
+// e.g. by an ingress controller running as DaemonSet.
+func (r *IngressReconciler) getNodeIPs(ctx context.Context) ([]string, error) {
+	if r.NodeAddressType == "" {
+		return nil, nil
+	}
+	nodes := corev1.NodeList{}
+	if err := r.List(ctx, &nodes); err != nil {
+		return nil, err
+	}
+	ips := []string{}
+	for _, node := range nodes.Items {
+		for _, ip := range r.nodeIPs(&node) {
+			if !slices.Contains(ips, ip) {
+				ips = append(ips, ip)
+			}
+		}
+	}
+	return ips, nil
+}
+
+// nodeIPs returns the addresses of the node to publish, none if it does not
+// match the selector, or is not ready.
+func (r *IngressReconciler) nodeIPs(node *corev1.Node) []string {
+	if r.NodeSelector != nil && !r.NodeSelector.Matches(labels.Set(node.Labels)) {
+		return nil
+	}
+	if !node.DeletionTimestamp.IsZero() || !isNodeReady(node) {
+		return nil
+	}
+	ips := []string{}
+	for _, address := range node.Status.Addresses {
+		if address.Type == r.NodeAddressType && address.Address != "" {
+			ips = append(ips, address.Address)
+		}
+	}
+	return ips
+}
+
+func isNodeReady(node *corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// nodeIPsChanged only passes updates of nodes changing the addresses to
+// publish, as nodes report their status regularly.
+func (r *IngressReconciler) nodeIPsChanged() builder.Predicates {
+	return builder.WithPredicates(predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldNode, oldOk := e.ObjectOld.(*corev1.Node)
+			newNode, newOk := e.ObjectNew.(*corev1.Node)
+			return !oldOk || !newOk || !slices.Equal(r.nodeIPs(oldNode), r.nodeIPs(newNode))
+		},
+	})
+}
@@ -1,8 +1,13 @@
 package util
 
 import (
+	"context"
+	"net"
+	"net/netip"
+	"slices"
 	"strings"
 
+	corev1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -11,27 +16,169 @@ import (
 	v1 "github.com/j-be/desec-dns-operator/api/v1"
 )
 
-func GetSubnames(ingress networkingv1.Ingress, domain string) []string {
+// Condition types a DesecDns is initialized with
+var desecDnsConditionTypes = []string{"Domain", "IpUpdate"}
+
+// Condition types of a DesecDns reporting the records per IP family
+var ipFamilyConditionTypes = []string{"IPv4", "IPv6"}
+
+// ApexConditionType is the condition type of a DesecDns reporting whether
+// ingresses serve the domain itself, as that is covered by its A and AAAA
+// records instead of a CNAME.
+const ApexConditionType = "Apex"
+
+// DeletionConditionType is the condition type of a DesecDns reporting the
+// progress of removing it from deSEC.
+const DeletionConditionType = "Deletion"
+
+// GetHosts returns the hosts of the rules of the ingress.
+func GetHosts(ingress networkingv1.Ingress) []string {
+	hosts := []string{}
+	for _, rule := range ingress.Spec.Rules {
+		hosts = append(hosts, rule.Host)
+	}
+	return hosts
+}
+
+// GetSubnames returns the subnames of the hosts in the domain. Hosts in any
+// of the other managed domains, which is longer and therefore more specific,
+// are left to that domain.
+func GetSubnames(hosts []string, domain string, managedDomains ...string) []string {
 	suffix := "." + domain
 
 	subnames := []string{}
-	for _, rule := range ingress.Spec.Rules {
-		host := strings.TrimRight(rule.Host, ".")
-		if strings.HasSuffix(host, suffix) {
+	for _, host := range hosts {
+		host := strings.TrimRight(host, ".")
+		if strings.HasSuffix(host, suffix) && MatchDomain(host, slices.Concat(managedDomains, []string{domain})) == domain {
 			subnames = append(subnames, strings.TrimSuffix(host, suffix))
 		}
 	}
 	return subnames
 }
 
+// MatchDomain returns the longest of the domains the host is in, or an empty
+// string if there is none.
+func MatchDomain(host string, domains []string) string {
+	match := ""
+	for _, domain := range domains {
+		if (host == domain || strings.HasSuffix(host, "."+domain)) && len(domain) > len(match) {
+			match = domain
+		}
+	}
+	return match
+}
+
+// ServesApex returns true if any of the hosts is the domain itself.
+func ServesApex(hosts []string, domain string) bool {
+	return slices.ContainsFunc(hosts, func(host string) bool {
+		return strings.TrimRight(host, ".") == domain
+	})
+}
+
+// lbAddress is the address of a load balancer of an ingress or service.
+type lbAddress struct {
+	ip       string
+	hostname string
+}
+
+func ingressAddresses(ingress networkingv1.Ingress) []lbAddress {
+	addresses := []lbAddress{}
+	for _, ingress := range ingress.Status.LoadBalancer.Ingress {
+		addresses = append(addresses, lbAddress{ip: ingress.IP, hostname: ingress.Hostname})
+	}
+	return addresses
+}
+
+func serviceAddresses(service corev1.Service) []lbAddress {
+	addresses := []lbAddress{}
+	for _, ingress := range service.Status.LoadBalancer.Ingress {
+		addresses = append(addresses, lbAddress{ip: ingress.IP, hostname: ingress.Hostname})
+	}
+	return addresses
+}
+
 func GetIps(ingress networkingv1.Ingress) []string {
+	return getIps(ingressAddresses(ingress))
+}
+
+// GetServiceIps returns the IPs of the load balancers of the service.
+func GetServiceIps(service corev1.Service) []string {
+	return getIps(serviceAddresses(service))
+}
+
+func getIps(addresses []lbAddress) []string {
 	ips := []string{}
-	for _, ingress := range ingress.Status.LoadBalancer.Ingress {
-		ips = append(ips, ingress.IP)
+	for _, address := range addresses {
+		if address.ip != "" {
+			ips = append(ips, address.ip)
+		}
 	}
 	return ips
 }
 
+// GetHostnames returns the hostnames of load balancers, which publish a
+// hostname instead of an IP, like AWS ELBs.
+func GetHostnames(ingress networkingv1.Ingress) []string {
+	return getHostnames(ingressAddresses(ingress))
+}
+
+// GetServiceHostnames returns the hostnames of the load balancers of the
+// service, which publish a hostname instead of an IP.
+func GetServiceHostnames(service corev1.Service) []string {
+	return getHostnames(serviceAddresses(service))
+}
+
+func getHostnames(addresses []lbAddress) []string {
+	hostnames := []string{}
+	for _, address := range addresses {
+		if address.ip == "" && address.hostname != "" {
+			hostnames = append(hostnames, address.hostname)
+		}
+	}
+	return hostnames
+}
+
+// Resolver resolves hostnames to IPs, as implemented by net.Resolver.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// NewResolver returns a resolver using the DNS server at the given address,
+// or the system's resolver if the address is empty.
+func NewResolver(address string) Resolver {
+	if address == "" {
+		return net.DefaultResolver
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "53")
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return new(net.Dialer).DialContext(ctx, network, address)
+		},
+	}
+}
+
+// SplitIps splits the IPs into IPv4 and IPv6 addresses. Anything which is not
+// an IP address is dropped.
+func SplitIps(ips []string) ([]string, []string) {
+	ipv4s := []string{}
+	ipv6s := []string{}
+	for _, ip := range ips {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			continue
+		}
+		if addr.Unmap().Is4() {
+			ipv4s = append(ipv4s, addr.Unmap().String())
+		} else {
+			ipv6s = append(ipv6s, addr.String())
+		}
+	}
+	return ipv4s, ipv6s
+}
+
 func InitializeDesecDns(namespacedName types.NamespacedName) *v1.DesecDns {
 	cr := new(v1.DesecDns)
 	cr.Name = namespacedName.Name
@@ -44,7 +191,7 @@ func InitializeDesecDnsStatus() v1.DesecDnsStatus {
 	status := v1.DesecDnsStatus{
 		Conditions: []metav1.Condition{},
 	}
-	for _, conditionType := range []string{"Domain", "IpUpdate"} {
+	for _, conditionType := range desecDnsConditionTypes {
 		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
 			Type:   conditionType,
 			Status: metav1.ConditionUnknown,
@@ -55,6 +202,58 @@ func InitializeDesecDnsStatus() v1.DesecDnsStatus {
 	return status
 }
 
+// wildcardLabel replaces the wildcard of a subname in condition types, which
+// only allow alphanumerics, '-', '_' and '.'. Like _wildcard in the registry,
+// the underscore tells it from a label of a host, but a condition type must not
+// start with one.
+const wildcardLabel = "wild_card"
+
+// subnameConditionType returns the condition type reporting the subname, e.g.
+// wild_card.www for *.www.
+func subnameConditionType(subname string) string {
+	if subname == "*" || strings.HasPrefix(subname, "*.") {
+		return wildcardLabel + strings.TrimPrefix(subname, "*")
+	}
+	return subname
+}
+
+// subnameOf returns the subname reported by the condition type.
+func subnameOf(conditionType string) string {
+	if conditionType == wildcardLabel || strings.HasPrefix(conditionType, wildcardLabel+".") {
+		return "*" + strings.TrimPrefix(conditionType, wildcardLabel)
+	}
+	return conditionType
+}
+
+// GetCnameSubnames returns the subnames of all CNAMEs, or A and AAAA records
+// of services, tracked in the status.
+func GetCnameSubnames(status v1.DesecDnsStatus) []string {
+	subnames := []string{}
+	for _, condition := range status.Conditions {
+		if !slices.Contains(desecDnsConditionTypes, condition.Type) &&
+			!slices.Contains(ipFamilyConditionTypes, condition.Type) &&
+			condition.Type != ApexConditionType &&
+			condition.Type != DeletionConditionType {
+			subnames = append(subnames, subnameOf(condition.Type))
+		}
+	}
+	return subnames
+}
+
+// FindSubnameCondition returns the condition of the subname, or nil if there
+// is none.
+func FindSubnameCondition(status v1.DesecDnsStatus, subname string) *metav1.Condition {
+	return meta.FindStatusCondition(status.Conditions, subnameConditionType(subname))
+}
+
+// RemoveSubnameCondition removes the condition of the subname, and returns
+// true if it existed.
+func RemoveSubnameCondition(status *v1.DesecDnsStatus, subname string) bool {
+	return meta.RemoveStatusCondition(&status.Conditions, subnameConditionType(subname))
+}
+
+// UpdateDesecDnsStatus sets the condition of the given type or subname, and
+// returns true if anything changed.
 func UpdateDesecDnsStatus(
 	status *v1.DesecDnsStatus,
 	conditionType string,
@@ -62,7 +261,18 @@ func UpdateDesecDnsStatus(
 	reason string,
 	message string,
 ) bool {
-	existing := meta.FindStatusCondition(status.Conditions, conditionType)
+	return UpdateCondition(&status.Conditions, subnameConditionType(conditionType), conditionStatus, reason, message)
+}
+
+// UpdateCondition sets the condition, and returns true if anything changed.
+func UpdateCondition(
+	conditions *[]metav1.Condition,
+	conditionType string,
+	conditionStatus metav1.ConditionStatus,
+	reason string,
+	message string,
+) bool {
+	existing := meta.FindStatusCondition(*conditions, conditionType)
 
 	if existing != nil &&
 		existing.Status == conditionStatus &&
@@ -71,7 +281,7 @@ func UpdateDesecDnsStatus(
 		return false
 	}
 
-	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+	meta.SetStatusCondition(conditions, metav1.Condition{
 		Type:    conditionType,
 		Status:  conditionStatus,
 		Reason:  reason,
@@ -19,21 +19,28 @@ package main
 import (
 	"flag"
 	"os"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/metrics/server"
 
 	desecv1 "github.com/j-be/desec-dns-operator/api/v1"
 	"github.com/j-be/desec-dns-operator/controllers"
+	"github.com/j-be/desec-dns-operator/controllers/desec"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -53,11 +60,42 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var desecTimeout time.Duration
+	var enableDNSEndpoints bool
+	var gatewayAPIRoutes string
+	var enableTraefik bool
+	var enableOpenShiftRoutes bool
+	var nodeAddressType string
+	var nodeSelector string
+	var txtOwnerID string
+	var dryRun bool
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.DurationVar(&desecTimeout, "desec-timeout", desec.DefaultTimeout,
+		"The maximum time a single request to deSEC may take.")
+	flag.BoolVar(&enableDNSEndpoints, "enable-dnsendpoints", false,
+		"Set RRSets for the endpoints of external-dns' DNSEndpoint resources. "+
+			"Requires the DNSEndpoint CRD to be installed.")
+	flag.StringVar(&gatewayAPIRoutes, "gateway-api-routes", "",
+		"Comma separated kinds of Gateway API routes to take hostnames from, e.g. HTTPRoute,GRPCRoute,TLSRoute. "+
+			"Requires the CRDs of the routes to be installed.")
+	flag.BoolVar(&enableTraefik, "enable-traefik", false,
+		"Take hosts from the Host rules of Traefik IngressRoutes. Requires the IngressRoute CRD to be installed.")
+	flag.BoolVar(&enableOpenShiftRoutes, "enable-openshift-routes", false,
+		"Take hosts from OpenShift Routes. Requires the Route CRD to be installed.")
+	flag.StringVar(&nodeAddressType, "node-address-type", "",
+		"Publish the addresses of this type of all ready nodes, e.g. ExternalIP or InternalIP, as IPs of all domains. "+
+			"Intended for clusters without a load balancer.")
+	flag.StringVar(&nodeSelector, "node-selector", "",
+		"Label selector restricting the nodes whose addresses are published, e.g. the ones running the ingress controller.")
+	flag.StringVar(&txtOwnerID, "txt-owner-id", "",
+		"Record this ID as owner of each RRSet published in a companion TXT record, "+
+			"and never touch RRSets owned by others. Disabled if empty.")
+	flag.BoolVar(&dryRun, "dry-run", false,
+		"Only plan the changes to deSEC, and publish them as events and in the status, instead of applying them.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -72,6 +110,10 @@ func main() {
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "b15515b6.owly.dedyn.io",
+		// Only Secrets holding tokens are cached, all others are never read
+		Cache: cache.Options{ByObject: map[client.Object]cache.ByObject{
+			&corev1.Secret{}: {Label: labels.SelectorFromSet(labels.Set{controllers.TokenSecretLabel: "true"})},
+		}},
 		// LeaderElectionReleaseOnCancel defines if the leader should step down voluntarily
 		// when the Manager ends. This requires the binary to immediately end when the
 		// Manager is stopped, otherwise, this setting is unsafe. Setting this significantly
@@ -89,20 +131,138 @@ func main() {
 		os.Exit(1)
 	}
 
+	clientOptions := []desec.Option{desec.WithTimeout(desecTimeout)}
+	recorder := mgr.GetEventRecorder("desec-dns-operator")
+
 	if err = (&controllers.DesecDnsReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:        mgr.GetClient(),
+		Scheme:        mgr.GetScheme(),
+		ClientOptions: clientOptions,
+		OwnerID:       txtOwnerID,
+		Recorder:      recorder,
+		DryRun:        dryRun,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "DesecDns")
 		os.Exit(1)
 	}
-	if err = (&controllers.IngressReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr); err != nil {
+	ingressReconciler := controllers.IngressReconciler{
+		Client:        mgr.GetClient(),
+		Scheme:        mgr.GetScheme(),
+		ClientOptions: clientOptions,
+		OwnerID:       txtOwnerID,
+		Recorder:      recorder,
+		DryRun:        dryRun,
+	}
+	switch corev1.NodeAddressType(nodeAddressType) {
+	case "", corev1.NodeExternalIP, corev1.NodeInternalIP:
+		ingressReconciler.NodeAddressType = corev1.NodeAddressType(nodeAddressType)
+	default:
+		setupLog.Error(nil, "unsupported node address type", "type", nodeAddressType)
+		os.Exit(1)
+	}
+	if nodeSelector != "" {
+		if ingressReconciler.NodeSelector, err = labels.Parse(nodeSelector); err != nil {
+			setupLog.Error(err, "invalid node selector")
+			os.Exit(1)
+		}
+	}
+	for _, kind := range strings.Split(gatewayAPIRoutes, ",") {
+		if kind = strings.TrimSpace(kind); kind == "" {
+			continue
+		}
+		gvk, ok := controllers.RouteGVK(kind)
+		if !ok {
+			setupLog.Error(nil, "unknown Gateway API route", "kind", kind)
+			os.Exit(1)
+		}
+		ingressReconciler.Routes = append(ingressReconciler.Routes, gvk)
+	}
+	if enableTraefik {
+		ingressReconciler.Routes = append(ingressReconciler.Routes, controllers.TraefikIngressRouteGVK)
+	}
+	if enableOpenShiftRoutes {
+		ingressReconciler.Routes = append(ingressReconciler.Routes, controllers.OpenShiftRouteGVK)
+	}
+	if err = (&ingressReconciler).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Ingress")
 		os.Exit(1)
 	}
+	if ingressReconciler.NodeAddressType != "" {
+		if err = (&controllers.NodeReconciler{
+			IngressReconciler: ingressReconciler,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "Node")
+			os.Exit(1)
+		}
+	}
+	if err = (&controllers.ServiceReconciler{
+		IngressReconciler: ingressReconciler,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Service")
+		os.Exit(1)
+	}
+	for _, gvk := range ingressReconciler.Routes {
+		if err = (&controllers.RouteReconciler{
+			IngressReconciler: ingressReconciler,
+			GVK:               gvk,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", gvk.Kind)
+			os.Exit(1)
+		}
+	}
+	if err = (&controllers.DesecRecordReconciler{
+		Client:        mgr.GetClient(),
+		Scheme:        mgr.GetScheme(),
+		ClientOptions: clientOptions,
+		OwnerID:       txtOwnerID,
+		Recorder:      recorder,
+		DryRun:        dryRun,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "DesecRecord")
+		os.Exit(1)
+	}
+	if err = (&controllers.DesecDomainReconciler{
+		Client:        mgr.GetClient(),
+		Scheme:        mgr.GetScheme(),
+		ClientOptions: clientOptions,
+		Recorder:      recorder,
+		DryRun:        dryRun,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "DesecDomain")
+		os.Exit(1)
+	}
+	if err = (&controllers.DesecAccountReconciler{
+		Client:        mgr.GetClient(),
+		Scheme:        mgr.GetScheme(),
+		ClientOptions: clientOptions,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "DesecAccount")
+		os.Exit(1)
+	}
+	if err = (&controllers.DesecTokenReconciler{
+		Client:        mgr.GetClient(),
+		Scheme:        mgr.GetScheme(),
+		ClientOptions: clientOptions,
+		APIReader:     mgr.GetAPIReader(),
+		Recorder:      recorder,
+		DryRun:        dryRun,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "DesecToken")
+		os.Exit(1)
+	}
+	if enableDNSEndpoints {
+		if err = (&controllers.DNSEndpointReconciler{
+			Client:        mgr.GetClient(),
+			Scheme:        mgr.GetScheme(),
+			ClientOptions: clientOptions,
+			OwnerID:       txtOwnerID,
+			Recorder:      recorder,
+			DryRun:        dryRun,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "DNSEndpoint")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {